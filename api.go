@@ -0,0 +1,98 @@
+package golibbuttplug
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/funjack/golibbuttplug/message"
+)
+
+// DeviceAPI is the method set of Device. Code that depends on DeviceAPI
+// instead of *Device can be tested with a fake, see package buttplugfake.
+type DeviceAPI interface {
+	fmt.Stringer
+	Name() string
+	Index() uint32
+	IsSupported(msgtype string) bool
+	Supported() []string
+	StopDeviceCmd() error
+	RawCmd(cmd []byte) error
+	SingleMotorVibrateCmd(spd float64) error
+	KiirooCmd(cmd int) error
+	FleshlightLaunchFW12Cmd(pos, spd int) error
+	LovenseCmd(cmd string) error
+	VorzeA10CycloneCmd(spd int, clockwise bool) error
+	Disconnected() <-chan struct{}
+}
+
+// ClientAPI is the method set of Client, with devices returned as DeviceAPI.
+// Use Client.API to get the ClientAPI of a client. Code that depends on
+// ClientAPI instead of *Client can be tested with a fake, see package
+// buttplugfake.
+type ClientAPI interface {
+	Close()
+	Send(ctx context.Context, m message.OutgoingMessage) (message.IncomingMessage, error)
+	StartScanning() error
+	StopScanning() error
+	WaitOnScanning(ctx context.Context) error
+	Scan(ctx context.Context, d time.Duration, fn func(e ScanEvent) bool) error
+	Devices() []DeviceAPI
+	Device(index uint32) (DeviceAPI, bool)
+	FindDevices(filters ...DeviceFilter) []DeviceAPI
+	WaitDevice(ctx context.Context, filters ...DeviceFilter) (DeviceAPI, error)
+	StopAllDevices() error
+	ServerInfo() message.ServerInfo
+	RequestLog(level string) error
+	Subscribe(opts ...message.SubscribeOption) (*message.Reader, error)
+	Unsubscribe(r *message.Reader)
+	Disconnected() <-chan struct{}
+	PingStats() PingStats
+	Health() Health
+}
+
+// API returns the client as ClientAPI.
+func (c *Client) API() ClientAPI {
+	return clientAPI{c}
+}
+
+// clientAPI adapts the methods of Client that return devices.
+type clientAPI struct {
+	*Client
+}
+
+func (c clientAPI) Devices() []DeviceAPI {
+	return deviceAPIs(c.Client.Devices())
+}
+
+func (c clientAPI) Device(index uint32) (DeviceAPI, bool) {
+	d, ok := c.Client.Device(index)
+	if !ok {
+		return nil, false
+	}
+	return d, true
+}
+
+func (c clientAPI) FindDevices(filters ...DeviceFilter) []DeviceAPI {
+	return deviceAPIs(c.Client.FindDevices(filters...))
+}
+
+func (c clientAPI) WaitDevice(ctx context.Context, filters ...DeviceFilter) (DeviceAPI, error) {
+	d, err := c.Client.WaitDevice(ctx, filters...)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// DeviceAPIs converts devices to DeviceAPI.
+func deviceAPIs(devices []*Device) []DeviceAPI {
+	if devices == nil {
+		return nil
+	}
+	apis := make([]DeviceAPI, len(devices))
+	for i, d := range devices {
+		apis[i] = d
+	}
+	return apis
+}
@@ -0,0 +1,351 @@
+/*
+Package bridge exposes the devices of a Buttplug client over a HTTP JSON API.
+
+The API has the following endpoints:
+
+	GET  /devices                  list all devices and their state
+	GET  /devices/{index}          state of a single device
+	POST /devices/{index}/{type}   send a device message, eg: SingleMotorVibrateCmd
+	POST /stop                     stop all devices
+	GET  /events                   device added and removed Server-Sent Events
+
+The body of a device message is the JSON encoded message without the Id and
+DeviceIndex, eg: {"Position": 90, "Speed": 50} for FleshlightLaunchFW12Cmd.
+Messages are validated by the device, invalid values result in a 400 status
+and unsupported messages in a 422 status. Errors are returned as
+{"Error": "message"}.
+*/
+package bridge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/funjack/golibbuttplug"
+	"github.com/funjack/golibbuttplug/lovense"
+	"github.com/funjack/golibbuttplug/message"
+)
+
+// maxBodySize is the maximum size of a request body.
+const maxBodySize = 1 << 16
+
+var (
+	errNotFound = errors.New("not found")
+	errMethod   = errors.New("method not allowed")
+)
+
+// Bridge is a http.Handler that serves the API for a client.
+type Bridge struct {
+	client *golibbuttplug.Client
+
+	m     sync.Mutex             // Protects state.
+	state map[uint32]lastCommand // Last command per device index.
+}
+
+// DeviceState describes a device and the last command send to it.
+type DeviceState struct {
+	Index       uint32
+	Name        string
+	Messages    []string
+	LastCommand string          `json:",omitempty"`
+	LastValues  json.RawMessage `json:",omitempty"`
+	LastUpdate  *time.Time      `json:",omitempty"`
+}
+
+type lastCommand struct {
+	cmd    string
+	values json.RawMessage
+	time   time.Time
+}
+
+// New returns a bridge for the client.
+func New(c *golibbuttplug.Client) *Bridge {
+	return &Bridge{
+		client: c,
+		state:  make(map[uint32]lastCommand),
+	}
+}
+
+// ServeHTTP dispatches the request to the API endpoint.
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var err error
+	switch {
+	case len(path) == 1 && path[0] == "devices":
+		err = b.devices(w, r)
+	case len(path) == 2 && path[0] == "devices":
+		err = b.device(w, r, path[1])
+	case len(path) == 3 && path[0] == "devices":
+		err = b.command(w, r, path[1], path[2])
+	case len(path) == 1 && path[0] == "stop":
+		err = b.stopAll(w, r)
+	case len(path) == 1 && path[0] == "events":
+		err = b.events(w, r)
+	default:
+		err = errNotFound
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+func (b *Bridge) devices(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return errMethod
+	}
+	devs := b.client.Devices()
+	states := make([]DeviceState, len(devs))
+	for i, d := range devs {
+		states[i] = b.deviceState(d)
+	}
+	return writeJSON(w, http.StatusOK, states)
+}
+
+func (b *Bridge) device(w http.ResponseWriter, r *http.Request, index string) error {
+	if r.Method != http.MethodGet {
+		return errMethod
+	}
+	d, err := b.lookup(index)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, b.deviceState(d))
+}
+
+func (b *Bridge) command(w http.ResponseWriter, r *http.Request, index, msgtype string) error {
+	if r.Method != http.MethodPost {
+		return errMethod
+	}
+	d, err := b.lookup(index)
+	if err != nil {
+		return err
+	}
+	var values json.RawMessage
+	err = json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&values)
+	if err == io.EOF {
+		// Messages without values, like StopDeviceCmd, can omit the body.
+		values = json.RawMessage("{}")
+	} else if err != nil {
+		return badRequest{err}
+	}
+	if err := execute(d, msgtype, values); err != nil {
+		return err
+	}
+	b.m.Lock()
+	b.state[d.Index()] = lastCommand{
+		cmd:    msgtype,
+		values: values,
+		time:   time.Now(),
+	}
+	b.m.Unlock()
+	return writeJSON(w, http.StatusOK, b.deviceState(d))
+}
+
+// Execute decodes the values of a device message and sends it to the
+// device.
+func execute(d *golibbuttplug.Device, msgtype string, values json.RawMessage) error {
+	decode := func(v interface{}) error {
+		if err := json.Unmarshal(values, v); err != nil {
+			return badRequest{err}
+		}
+		return nil
+	}
+	switch msgtype {
+	case golibbuttplug.CommandStopDevice:
+		return d.StopDeviceCmd()
+	case golibbuttplug.CommandRaw:
+		var m message.RawCmd
+		if err := decode(&m); err != nil {
+			return err
+		}
+		return d.RawCmd(m.Command)
+	case golibbuttplug.CommandSingleMotorVibrate:
+		var m message.SingleMotorVibrateCmd
+		if err := decode(&m); err != nil {
+			return err
+		}
+		return d.SingleMotorVibrateCmd(m.Speed)
+	case golibbuttplug.CommandKiiroo:
+		var m message.KiirooCmd
+		if err := decode(&m); err != nil {
+			return err
+		}
+		return d.KiirooCmd(m.Command)
+	case golibbuttplug.CommandFleshlightLaunchFW12:
+		var m message.FleshlightLaunchFW12Cmd
+		if err := decode(&m); err != nil {
+			return err
+		}
+		return d.FleshlightLaunchFW12Cmd(m.Position, m.Speed)
+	case golibbuttplug.CommandLovense:
+		var m message.LovenseCmd
+		if err := decode(&m); err != nil {
+			return err
+		}
+		return d.LovenseCmd(m.Command)
+	case golibbuttplug.CommandVorzeA10Cyclone:
+		var m message.VorzeA10CycloneCmd
+		if err := decode(&m); err != nil {
+			return err
+		}
+		return d.VorzeA10CycloneCmd(m.Speed, m.Clockwise)
+	}
+	return errNotFound
+}
+
+func (b *Bridge) stopAll(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return errMethod
+	}
+	if err := b.client.StopAllDevices(); err != nil {
+		return err
+	}
+	b.m.Lock()
+	now := time.Now()
+	for _, d := range b.client.Devices() {
+		b.state[d.Index()] = lastCommand{cmd: "StopAllDevices", time: now}
+	}
+	b.m.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Events streams device added and removed events.
+func (b *Bridge) events(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return errMethod
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("streaming not supported")
+	}
+	sub, err := b.client.Subscribe(
+		message.FilterTypes("DeviceAdded", "DeviceRemoved"),
+		message.BufferSize(100),
+	)
+	if err != nil {
+		return err
+	}
+	defer b.client.Unsubscribe(sub)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case m, ok := <-sub.Incoming():
+			if !ok {
+				return nil
+			}
+			var (
+				name string
+				dev  *message.Device
+			)
+			switch {
+			case m.DeviceAdded != nil:
+				name, dev = "DeviceAdded", m.DeviceAdded
+			case m.DeviceRemoved != nil:
+				name, dev = "DeviceRemoved", m.DeviceRemoved
+			default:
+				continue
+			}
+			data, err := json.Marshal(DeviceState{
+				Index:    dev.DeviceIndex,
+				Name:     dev.DeviceName,
+				Messages: dev.DeviceMessages,
+			})
+			if err != nil {
+				return nil
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+				return nil
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return nil
+		case <-b.client.Disconnected():
+			return nil
+		}
+	}
+}
+
+// Lookup returns the device with the given index.
+func (b *Bridge) lookup(index string) (*golibbuttplug.Device, error) {
+	i, err := strconv.ParseUint(index, 10, 32)
+	if err != nil {
+		return nil, errNotFound
+	}
+	for _, d := range b.client.Devices() {
+		if d.Index() == uint32(i) {
+			return d, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (b *Bridge) deviceState(d *golibbuttplug.Device) DeviceState {
+	s := DeviceState{
+		Index:    d.Index(),
+		Name:     d.Name(),
+		Messages: d.Supported(),
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	if lc, ok := b.state[d.Index()]; ok {
+		s.LastCommand = lc.cmd
+		s.LastValues = lc.values
+		t := lc.time
+		s.LastUpdate = &t
+	}
+	return s
+}
+
+// badRequest is an error caused by invalid input.
+type badRequest struct {
+	err error
+}
+
+func (e badRequest) Error() string {
+	return e.err.Error()
+}
+
+// StatusCode returns the HTTP status code for an error.
+func statusCode(err error) int {
+	switch err {
+	case errNotFound:
+		return http.StatusNotFound
+	case errMethod:
+		return http.StatusMethodNotAllowed
+	case golibbuttplug.ErrInvalidCmd, golibbuttplug.ErrInvalidPosition,
+		golibbuttplug.ErrInvalidSpeed, lovense.ErrArgs, lovense.ErrRange:
+		return http.StatusBadRequest
+	case golibbuttplug.ErrUnsupported, lovense.ErrUnsupported:
+		return http.StatusUnprocessableEntity
+	}
+	if _, ok := err.(badRequest); ok {
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := statusCode(err)
+	if code == http.StatusBadGateway {
+		log.Printf("bridge error: %v", err)
+	}
+	writeJSON(w, code, struct{ Error string }{err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	return json.NewEncoder(w).Encode(v)
+}
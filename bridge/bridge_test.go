@@ -0,0 +1,178 @@
+package bridge
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/funjack/golibbuttplug"
+	"github.com/funjack/golibbuttplug/buttplugtest"
+	"github.com/funjack/golibbuttplug/message"
+)
+
+// newBridge starts a strict test server, a client connected to it and a
+// bridge for the client.
+func newBridge(t *testing.T) (*buttplugtest.TestServer, *httptest.Server, func()) {
+	return newBridgeDevices(t, buttplugtest.DefaultTestServer.InitialDevices)
+}
+
+// newBridgeDevices is newBridge with a test server that has the devices.
+func newBridgeDevices(t *testing.T, devices []message.Device) (*buttplugtest.TestServer, *httptest.Server, func()) {
+	s := &buttplugtest.TestServer{
+		InitialDevices: devices,
+		Strict:         true,
+	}
+	ts := httptest.NewServer(s)
+	c, err := golibbuttplug.NewClient(context.Background(),
+		"ws"+strings.TrimPrefix(ts.URL, "http"), "BridgeTest", nil)
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	bs := httptest.NewServer(New(c))
+	return s, bs, func() {
+		bs.Close()
+		c.Close()
+		ts.Close()
+	}
+}
+
+func TestBridge(t *testing.T) {
+	s, bs, done := newBridge(t)
+	defer done()
+
+	cases := []struct {
+		Method string
+		Path   string
+		Body   string
+		Status int
+	}{
+		{"GET", "/devices", "", http.StatusOK},
+		{"GET", "/devices/2", "", http.StatusOK},
+		{"GET", "/devices/9", "", http.StatusNotFound},
+		{"GET", "/devices/x", "", http.StatusNotFound},
+		{"POST", "/devices", "", http.StatusMethodNotAllowed},
+		{"POST", "/devices/0/SingleMotorVibrateCmd", `{"Speed":0.5}`, http.StatusOK},
+		{"POST", "/devices/0/SingleMotorVibrateCmd", `{"Speed":1.5}`, http.StatusBadRequest},
+		{"POST", "/devices/0/SingleMotorVibrateCmd", `{"Speed":"fast"}`, http.StatusBadRequest},
+		{"POST", "/devices/0/SingleMotorVibrateCmd", `{`, http.StatusBadRequest},
+		{"POST", "/devices/0/FleshlightLaunchFW12Cmd", `{"Position":10,"Speed":20}`, http.StatusUnprocessableEntity},
+		{"POST", "/devices/2/FleshlightLaunchFW12Cmd", `{"Position":10,"Speed":20}`, http.StatusOK},
+		{"POST", "/devices/2/FleshlightLaunchFW12Cmd", `{"Position":100,"Speed":20}`, http.StatusBadRequest},
+		{"POST", "/devices/1/LovenseCmd", `{"Command":"Vibrate:10;"}`, http.StatusOK},
+		{"POST", "/devices/2/RawCmd", `{"Command":"ABA="}`, http.StatusOK},
+		{"POST", "/devices/2/StopDeviceCmd", "", http.StatusOK},
+		{"POST", "/devices/2/UnknownCmd", "{}", http.StatusNotFound},
+		{"POST", "/stop", "", http.StatusNoContent},
+		{"GET", "/unknown", "", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		req, err := http.NewRequest(c.Method, bs.URL+c.Path, strings.NewReader(c.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.Status {
+			t.Errorf("%s %s %s: status %d, want %d", c.Method, c.Path,
+				c.Body, resp.StatusCode, c.Status)
+		}
+	}
+
+	conn := s.LastConn()
+	if _, err := conn.WaitFor(time.Second, buttplugtest.MatchMessage(message.OutgoingMessage{
+		FleshlightLaunchFW12Cmd: &message.FleshlightLaunchFW12Cmd{
+			DeviceIndex: 2,
+			Position:    10,
+			Speed:       20,
+		},
+	})); err != nil {
+		t.Errorf("launch command not received: %v", err)
+	}
+
+	resp, err := http.Get(bs.URL + "/devices/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var state DeviceState
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Name != "TestDevice 2" || state.LastCommand != "StopAllDevices" || state.LastUpdate == nil {
+		t.Errorf("unexpected state: %+v", state)
+	}
+}
+
+func TestBridgeLovense(t *testing.T) {
+	_, bs, done := newBridgeDevices(t, []message.Device{{
+		DeviceName:     "Lovense Lush",
+		DeviceIndex:    0,
+		DeviceMessages: []string{"LovenseCmd", "StopDeviceCmd"},
+	}})
+	defer done()
+
+	for _, c := range []struct {
+		Body   string
+		Status int
+	}{
+		{`{"Command":"Vibrate:10;"}`, http.StatusOK},
+		{`{"Command":"Vibrate:21;"}`, http.StatusBadRequest},
+		{`{"Command":"Vibrate:1:2;"}`, http.StatusBadRequest},
+		{`{"Command":"Rotate:10;"}`, http.StatusUnprocessableEntity},
+		{`{"Command":"Vibrate;"}`, http.StatusBadRequest},
+	} {
+		resp, err := http.Post(bs.URL+"/devices/0/LovenseCmd", "application/json",
+			strings.NewReader(c.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.Status {
+			t.Errorf("%s: status %d, want %d", c.Body, resp.StatusCode, c.Status)
+		}
+	}
+}
+
+func TestBridgeEvents(t *testing.T) {
+	s, bs, done := newBridge(t)
+	defer done()
+
+	resp, err := http.Get(bs.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("content type %q", ct)
+	}
+	s.AddDevice(buttplugtest.DefaultAddDeviceMessage)
+	s.RemoveDevice(buttplugtest.DefaultRemoveDeviceMessage)
+
+	want := []string{
+		"event: DeviceAdded",
+		`data: {"Index":3,"Name":"Launch","Messages":["FleshlightLaunchFW12Cmd","KiirooCmd","RawCmd","StopDeviceCmd"]}`,
+		"",
+		"event: DeviceRemoved",
+		`data: {"Index":3,"Name":"","Messages":null}`,
+		"",
+	}
+	r := bufio.NewReader(resp.Body)
+	for _, w := range want {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line = strings.TrimSuffix(line, "\n"); line != w {
+			t.Errorf("got %q, want %q", line, w)
+		}
+	}
+}
@@ -0,0 +1,449 @@
+// Package buttplugfake provides in-memory fakes of golibbuttplug.ClientAPI
+// and golibbuttplug.DeviceAPI for unit testing code that uses a client.
+package buttplugfake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/funjack/golibbuttplug"
+	"github.com/funjack/golibbuttplug/message"
+	"github.com/gorilla/websocket"
+)
+
+var _ golibbuttplug.ClientAPI = (*Client)(nil)
+
+// Call is a recorded method call.
+type Call struct {
+	// Method name, eg: "StartScanning" or "SingleMotorVibrateCmd".
+	Method string
+	// Args are the arguments of the call.
+	Args []interface{}
+}
+
+// recorder records calls and returns scripted errors.
+type recorder struct {
+	m      sync.Mutex // Protects calls and errors.
+	calls  []Call
+	errors map[string]error
+}
+
+// Call records a call and returns the scripted error of the method.
+func (r *recorder) call(method string, args ...interface{}) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.calls = append(r.calls, Call{Method: method, Args: args})
+	return r.errors[method]
+}
+
+func (r *recorder) failWith(method string, err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.errors == nil {
+		r.errors = make(map[string]error)
+	}
+	if err == nil {
+		delete(r.errors, method)
+		return
+	}
+	r.errors[method] = err
+}
+
+func (r *recorder) get() []Call {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// Client is an in-memory fake of golibbuttplug.ClientAPI. Methods that
+// would send a message to the server are recorded and succeed, unless an
+// error is scripted with FailWith. Server events are simulated with
+// AddDevice, RemoveDevice, FinishScanning and Emit.
+type Client struct {
+	// Info is returned by ServerInfo.
+	Info message.ServerInfo
+	// SendFunc replies to Send when it is set, otherwise Send replies Ok.
+	SendFunc func(ctx context.Context, m message.OutgoingMessage) (message.IncomingMessage, error)
+
+	calls    recorder
+	counter  message.IDCounter
+	conn     *pipeConn
+	receiver *message.Receiver
+	once     sync.Once     // Ensure Close() is executed only once.
+	stop     chan struct{} // Closed when the client is closed.
+
+	m        sync.Mutex         // Protects all fields below.
+	devices  map[uint32]*Device // Devices by index.
+	added    chan struct{}      // Closed when a device is added.
+	finished chan struct{}      // Closed when scanning is finished.
+	stats    golibbuttplug.PingStats
+}
+
+// NewClient returns a fake client that knows the given devices.
+func NewClient(devices ...*Device) *Client {
+	c := &Client{
+		Info:     message.ServerInfo{ServerName: "buttplugfake"},
+		conn:     newPipeConn(),
+		stop:     make(chan struct{}),
+		devices:  make(map[uint32]*Device),
+		added:    make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	for _, d := range devices {
+		c.devices[d.Index()] = d
+	}
+	c.receiver = message.NewReceiver(c.conn, c.stop)
+	return c
+}
+
+// FailWith makes every call of method return err, eg:
+// FailWith("StartScanning", err). A nil err removes the error.
+func (c *Client) FailWith(method string, err error) {
+	c.calls.failWith(method, err)
+}
+
+// Calls returns the recorded calls in order. Only methods that send a
+// message to the server are recorded.
+func (c *Client) Calls() []Call {
+	return c.calls.get()
+}
+
+// Emit sends a message to the subscribers as if it was send by the server.
+func (c *Client) Emit(m message.IncomingMessage) {
+	p, err := json.Marshal(message.IncomingMessages{m})
+	if err != nil {
+		panic("buttplugfake: emit: " + err.Error())
+	}
+	c.conn.push(p)
+}
+
+// AddDevice adds a device as if it was found by the server and emits
+// DeviceAdded.
+func (c *Client) AddDevice(d *Device) {
+	c.m.Lock()
+	c.devices[d.Index()] = d
+	close(c.added)
+	c.added = make(chan struct{})
+	c.m.Unlock()
+	c.Emit(message.IncomingMessage{
+		DeviceAdded: &message.Device{
+			DeviceName:     d.Name(),
+			DeviceIndex:    d.Index(),
+			DeviceMessages: d.Supported(),
+		},
+	})
+}
+
+// RemoveDevice removes the device with the given index, disconnects it and
+// emits DeviceRemoved.
+func (c *Client) RemoveDevice(index uint32) {
+	c.m.Lock()
+	d, ok := c.devices[index]
+	delete(c.devices, index)
+	c.m.Unlock()
+	if !ok {
+		return
+	}
+	d.disconnect()
+	c.Emit(message.IncomingMessage{
+		DeviceRemoved: &message.Device{DeviceIndex: index},
+	})
+}
+
+// FinishScanning ends running scans as if the server finished scanning and
+// emits ScanningFinished.
+func (c *Client) FinishScanning() {
+	c.m.Lock()
+	close(c.finished)
+	c.finished = make(chan struct{})
+	c.m.Unlock()
+	c.Emit(message.IncomingMessage{ScanningFinished: &message.Empty{}})
+}
+
+// SetPingStats sets the stats returned by PingStats and Health.
+func (c *Client) SetPingStats(s golibbuttplug.PingStats) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.stats = s
+}
+
+// Close records the call, disconnects all devices and closes the client.
+func (c *Client) Close() {
+	c.calls.call("Close")
+	c.once.Do(func() {
+		c.receiver.Stop()
+		c.conn.Close()
+		<-c.stop
+		c.m.Lock()
+		for _, d := range c.devices {
+			d.disconnect()
+		}
+		c.devices = make(map[uint32]*Device)
+		c.m.Unlock()
+	})
+}
+
+// Send records the call and returns the reply of SendFunc, or Ok. The id of
+// m is overwritten like Client.Send does.
+func (c *Client) Send(ctx context.Context, m message.OutgoingMessage) (message.IncomingMessage, error) {
+	m.SetID(c.counter.Generate())
+	if err := c.calls.call("Send", m); err != nil {
+		return message.IncomingMessage{}, err
+	}
+	if c.SendFunc != nil {
+		return c.SendFunc(ctx, m)
+	}
+	return golibbuttplug.OkReply(m), nil
+}
+
+// StartScanning records the call.
+func (c *Client) StartScanning() error {
+	return c.calls.call("StartScanning")
+}
+
+// StopScanning records the call.
+func (c *Client) StopScanning() error {
+	return c.calls.call("StopScanning")
+}
+
+// WaitOnScanning waits until FinishScanning is called or ctx is done.
+func (c *Client) WaitOnScanning(ctx context.Context) error {
+	c.m.Lock()
+	finished := c.finished
+	c.m.Unlock()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Scan records the call and reports devices added with AddDevice until
+// FinishScanning is called, d has elapsed, fn returns false or ctx is done.
+// StartScanning and StopScanning are not recorded.
+func (c *Client) Scan(ctx context.Context, d time.Duration, fn func(e golibbuttplug.ScanEvent) bool) error {
+	seen := make(map[*Device]bool)
+	for _, dev := range c.sorted() {
+		seen[dev] = true
+	}
+	if err := c.calls.call("Scan", d); err != nil {
+		return err
+	}
+	var timeout <-chan time.Time
+	if d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+	c.m.Lock()
+	finished := c.finished
+	c.m.Unlock()
+	for {
+		c.m.Lock()
+		added := c.added
+		c.m.Unlock()
+		for _, dev := range c.sorted() {
+			if seen[dev] {
+				continue
+			}
+			seen[dev] = true
+			if !fn(golibbuttplug.ScanEvent{Device: dev}) {
+				return nil
+			}
+		}
+		select {
+		case <-added:
+		case <-finished:
+			fn(golibbuttplug.ScanEvent{Finished: true})
+			return nil
+		case <-timeout:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.stop:
+			return errors.New("client stopped")
+		}
+	}
+}
+
+// Devices returns all devices sorted by index.
+func (c *Client) Devices() []golibbuttplug.DeviceAPI {
+	var apis []golibbuttplug.DeviceAPI
+	for _, d := range c.sorted() {
+		apis = append(apis, d)
+	}
+	return apis
+}
+
+// Device returns the device with the given index.
+func (c *Client) Device(index uint32) (golibbuttplug.DeviceAPI, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	d, ok := c.devices[index]
+	if !ok {
+		return nil, false
+	}
+	return d, true
+}
+
+// FindDevices returns the devices that match all filters, sorted by index.
+func (c *Client) FindDevices(filters ...golibbuttplug.DeviceFilter) []golibbuttplug.DeviceAPI {
+	var found []golibbuttplug.DeviceAPI
+	for _, d := range c.sorted() {
+		if match(d, filters) {
+			found = append(found, d)
+		}
+	}
+	return found
+}
+
+// WaitDevice waits until a device that matches all filters is known.
+func (c *Client) WaitDevice(ctx context.Context, filters ...golibbuttplug.DeviceFilter) (golibbuttplug.DeviceAPI, error) {
+	for {
+		c.m.Lock()
+		added := c.added
+		c.m.Unlock()
+		if found := c.FindDevices(filters...); len(found) > 0 {
+			return found[0], nil
+		}
+		select {
+		case <-added:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.stop:
+			return nil, errors.New("client stopped")
+		}
+	}
+}
+
+// StopAllDevices records the call.
+func (c *Client) StopAllDevices() error {
+	return c.calls.call("StopAllDevices")
+}
+
+// ServerInfo returns Info.
+func (c *Client) ServerInfo() message.ServerInfo {
+	return c.Info
+}
+
+// RequestLog records the call.
+func (c *Client) RequestLog(level string) error {
+	return c.calls.call("RequestLog", level)
+}
+
+// Subscribe returns a reader that receives the emitted messages.
+func (c *Client) Subscribe(opts ...message.SubscribeOption) (*message.Reader, error) {
+	return c.receiver.Subscribe(opts...)
+}
+
+// Unsubscribe stops the reader from receiving messages.
+func (c *Client) Unsubscribe(r *message.Reader) {
+	c.receiver.Unsubscribe(r)
+}
+
+// Disconnected returns a channel that is closed when the client is closed.
+func (c *Client) Disconnected() <-chan struct{} {
+	return c.stop
+}
+
+// PingStats returns the stats set with SetPingStats.
+func (c *Client) PingStats() golibbuttplug.PingStats {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.stats
+}
+
+// Health returns the health of the stats set with SetPingStats, or Lost when
+// the client is closed.
+func (c *Client) Health() golibbuttplug.Health {
+	select {
+	case <-c.stop:
+		return golibbuttplug.Lost
+	default:
+	}
+	return c.PingStats().Health
+}
+
+// Sorted returns all devices sorted by index.
+func (c *Client) sorted() []*Device {
+	c.m.Lock()
+	defer c.m.Unlock()
+	d := make([]*Device, 0, len(c.devices))
+	for _, v := range c.devices {
+		d = append(d, v)
+	}
+	sort.Slice(d, func(i, j int) bool {
+		return d[i].Index() < d[j].Index()
+	})
+	return d
+}
+
+// match reports whether a device matches all filters.
+func match(d *Device, filters []golibbuttplug.DeviceFilter) bool {
+	for _, f := range filters {
+		if !f(d) {
+			return false
+		}
+	}
+	return true
+}
+
+// pipeConn is a message.Conn that reads the frames that are pushed.
+type pipeConn struct {
+	frames chan []byte
+	once   sync.Once // Ensure closed is closed only once.
+	closed chan struct{}
+}
+
+func newPipeConn() *pipeConn {
+	return &pipeConn{
+		frames: make(chan []byte),
+		closed: make(chan struct{}),
+	}
+}
+
+// Push sends a frame to the reader, it is dropped when the connection is
+// closed.
+func (p *pipeConn) push(frame []byte) {
+	select {
+	case p.frames <- frame:
+	case <-p.closed:
+	}
+}
+
+func (p *pipeConn) ReadMessage() (int, []byte, error) {
+	select {
+	case frame := <-p.frames:
+		return websocket.TextMessage, frame, nil
+	case <-p.closed:
+		return 0, nil, &websocket.CloseError{Code: websocket.CloseNormalClosure}
+	}
+}
+
+func (p *pipeConn) ReadJSON(v interface{}) error {
+	_, frame, err := p.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(frame, v)
+}
+
+func (p *pipeConn) WriteMessage(t int, data []byte) error {
+	return errors.New("buttplugfake: write not supported")
+}
+
+func (p *pipeConn) WriteJSON(v interface{}) error {
+	return errors.New("buttplugfake: write not supported")
+}
+
+func (p *pipeConn) Close() error {
+	p.once.Do(func() {
+		close(p.closed)
+	})
+	return nil
+}
@@ -0,0 +1,140 @@
+package buttplugfake
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/funjack/golibbuttplug"
+	"github.com/funjack/golibbuttplug/message"
+)
+
+// vibrateAll is an example of code under test.
+func vibrateAll(c golibbuttplug.ClientAPI, spd float64) error {
+	for _, d := range c.FindDevices(golibbuttplug.Can(golibbuttplug.Vibrate)) {
+		if err := d.SingleMotorVibrateCmd(spd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestClient(t *testing.T) {
+	vibe := NewDevice("Vibe", 1, golibbuttplug.CommandSingleMotorVibrate)
+	launch := NewDevice("Launch", 0, golibbuttplug.CommandFleshlightLaunchFW12)
+	c := NewClient(vibe, launch)
+	defer c.Close()
+
+	if err := vibrateAll(c, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	want := []Call{{Method: "SingleMotorVibrateCmd", Args: []interface{}{0.5}}}
+	if got := vibe.Calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got calls %+v, want %+v", got, want)
+	}
+	if got := launch.Calls(); len(got) != 0 {
+		t.Errorf("unexpected calls %+v", got)
+	}
+	if err := launch.SingleMotorVibrateCmd(0.5); err != golibbuttplug.ErrUnsupported {
+		t.Errorf("got %v, want %v", err, golibbuttplug.ErrUnsupported)
+	}
+	errBusy := errors.New("device busy")
+	vibe.FailWith("SingleMotorVibrateCmd", errBusy)
+	if err := vibrateAll(c, 0.5); err != errBusy {
+		t.Errorf("got %v, want %v", err, errBusy)
+	}
+	c.FailWith("StopAllDevices", errBusy)
+	if err := c.StopAllDevices(); err != errBusy {
+		t.Errorf("got %v, want %v", err, errBusy)
+	}
+	c.FailWith("StopAllDevices", nil)
+	if err := c.StopAllDevices(); err != nil {
+		t.Error(err)
+	}
+	r, err := c.Send(context.Background(), message.OutgoingMessage{StopAllDevices: &message.Empty{}})
+	if err != nil || r.Ok == nil || r.Ok.ID == 0 {
+		t.Errorf("unexpected reply %+v: %v", r, err)
+	}
+	var methods []string
+	for _, call := range c.Calls() {
+		methods = append(methods, call.Method)
+	}
+	if want := []string{"StopAllDevices", "StopAllDevices", "Send"}; !reflect.DeepEqual(methods, want) {
+		t.Errorf("got calls %v, want %v", methods, want)
+	}
+
+	c.Close()
+	for _, d := range []*Device{vibe, launch} {
+		select {
+		case <-d.Disconnected():
+		default:
+			t.Errorf("%s not disconnected", d)
+		}
+	}
+	if h := c.Health(); h != golibbuttplug.Lost {
+		t.Errorf("got health %v, want %v", h, golibbuttplug.Lost)
+	}
+}
+
+func TestClientEvents(t *testing.T) {
+	c := NewClient()
+	defer c.Close()
+	r, err := c.Subscribe(message.FilterTypes("DeviceAdded", "DeviceRemoved"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Unsubscribe(r)
+
+	found := make(chan golibbuttplug.DeviceAPI, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		d, err := c.WaitDevice(ctx, golibbuttplug.ByName("launch"))
+		if err != nil {
+			t.Error(err)
+		}
+		found <- d
+	}()
+	var events []golibbuttplug.ScanEvent
+	launch := NewDevice("Launch", 3, golibbuttplug.CommandFleshlightLaunchFW12)
+	go func() {
+		// Wait until the scan has started.
+		for len(c.Calls()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		c.AddDevice(launch)
+		c.FinishScanning()
+	}()
+	err = c.Scan(context.Background(), time.Second, func(e golibbuttplug.ScanEvent) bool {
+		events = append(events, e)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Device != launch || !events[1].Finished {
+		t.Errorf("unexpected events %+v", events)
+	}
+	if d := <-found; d != launch {
+		t.Errorf("got device %v, want %v", d, launch)
+	}
+
+	c.RemoveDevice(3)
+	for _, typ := range []string{"DeviceAdded", "DeviceRemoved"} {
+		select {
+		case m := <-r.Incoming():
+			if m.Type() != typ {
+				t.Errorf("got %s, want %s", m.Type(), typ)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s not received", typ)
+		}
+	}
+	select {
+	case <-launch.Disconnected():
+	default:
+		t.Error("removed device not disconnected")
+	}
+}
@@ -0,0 +1,134 @@
+package buttplugfake
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/funjack/golibbuttplug"
+)
+
+var _ golibbuttplug.DeviceAPI = (*Device)(nil)
+
+// Device is an in-memory fake of golibbuttplug.DeviceAPI. Commands are
+// recorded and return ErrUnsupported when the device does not support them,
+// arguments are not validated.
+type Device struct {
+	name     string
+	index    uint32
+	msgtypes []string
+
+	calls recorder
+	once  sync.Once // Ensure done is closed only once.
+	done  chan struct{}
+}
+
+// NewDevice returns a device that supports the given message types, eg:
+// golibbuttplug.CommandSingleMotorVibrate.
+func NewDevice(name string, index uint32, msgtypes ...string) *Device {
+	return &Device{
+		name:     name,
+		index:    index,
+		msgtypes: msgtypes,
+		done:     make(chan struct{}),
+	}
+}
+
+func (d *Device) String() string {
+	return fmt.Sprintf("%s(%d)", d.name, d.index)
+}
+
+// Name returns the device name.
+func (d *Device) Name() string {
+	return d.name
+}
+
+// Index returns the device index.
+func (d *Device) Index() uint32 {
+	return d.index
+}
+
+// IsSupported reports if the device supports the message type.
+func (d *Device) IsSupported(msgtype string) bool {
+	for _, t := range d.msgtypes {
+		if t == msgtype {
+			return true
+		}
+	}
+	return false
+}
+
+// Supported returns all supported message types.
+func (d *Device) Supported() []string {
+	return d.msgtypes
+}
+
+// StopDeviceCmd records the call.
+func (d *Device) StopDeviceCmd() error {
+	return d.command(golibbuttplug.CommandStopDevice)
+}
+
+// RawCmd records the call.
+func (d *Device) RawCmd(cmd []byte) error {
+	return d.command(golibbuttplug.CommandRaw, cmd)
+}
+
+// SingleMotorVibrateCmd records the call.
+func (d *Device) SingleMotorVibrateCmd(spd float64) error {
+	return d.command(golibbuttplug.CommandSingleMotorVibrate, spd)
+}
+
+// KiirooCmd records the call.
+func (d *Device) KiirooCmd(cmd int) error {
+	return d.command(golibbuttplug.CommandKiiroo, cmd)
+}
+
+// FleshlightLaunchFW12Cmd records the call.
+func (d *Device) FleshlightLaunchFW12Cmd(pos, spd int) error {
+	return d.command(golibbuttplug.CommandFleshlightLaunchFW12, pos, spd)
+}
+
+// LovenseCmd records the call.
+func (d *Device) LovenseCmd(cmd string) error {
+	return d.command(golibbuttplug.CommandLovense, cmd)
+}
+
+// VorzeA10CycloneCmd records the call.
+func (d *Device) VorzeA10CycloneCmd(spd int, clockwise bool) error {
+	return d.command(golibbuttplug.CommandVorzeA10Cyclone, spd, clockwise)
+}
+
+// Disconnected returns a channel that is closed when the device is removed
+// from the client, or the client is closed.
+func (d *Device) Disconnected() <-chan struct{} {
+	return d.done
+}
+
+// FailWith makes every call of method return err, eg:
+// FailWith("RawCmd", err). A nil err removes the error.
+func (d *Device) FailWith(method string, err error) {
+	d.calls.failWith(method, err)
+}
+
+// Calls returns the recorded command calls in order.
+func (d *Device) Calls() []Call {
+	return d.calls.get()
+}
+
+// Command records the call of a command and returns the scripted error, or
+// ErrUnsupported when the device does not support the command.
+func (d *Device) command(msgtype string, args ...interface{}) error {
+	if err := d.calls.call(msgtype, args...); err != nil {
+		return err
+	}
+	if !d.IsSupported(msgtype) {
+		return golibbuttplug.ErrUnsupported
+	}
+	return nil
+}
+
+// Disconnect closes the disconnected channel.
+func (d *Device) disconnect() {
+	d.once.Do(func() {
+		close(d.done)
+	})
+}
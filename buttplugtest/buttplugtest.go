@@ -2,9 +2,12 @@
 package buttplugtest
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/funjack/golibbuttplug/message"
 	"github.com/gorilla/websocket"
@@ -15,7 +18,30 @@ var upgrader = websocket.Upgrader{}
 // TestServer is a mock of a Buttplug server.
 type TestServer struct {
 	InitialDevices []message.Device
-	Conn           *Conn
+	// Faults are injected into every new connection.
+	Faults []*Fault
+	// Strict makes the server enforce the protocol: RequestServerInfo must
+	// be the first message, pings must be send within MaxPingTime and
+	// device commands must be supported by the device and have valid
+	// values. Violations are answered with an Error message, the
+	// connection is closed when pings stop.
+	Strict bool
+	// MaxPingTime send to the client in milliseconds. DefaultMaxPingTime is
+	// used when zero.
+	MaxPingTime uint32
+	// Handlers reply to messages by type name. They take precedence over
+	// the built-in handlers and can be used for vendor messages, see
+	// message.Register.
+	Handlers map[string]Handler
+	// Conn is the most recent connection.
+	//
+	// Deprecated: Conn is not safe for concurrent use, use LastConn,
+	// WaitConn or ConnByName instead.
+	Conn *Conn
+
+	m      sync.Mutex    // Protects conns and notify.
+	conns  []*Conn       // All connections in order.
+	notify chan struct{} // Closed when a connection is added.
 }
 
 var (
@@ -61,11 +87,13 @@ func (t *TestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer conn.Close()
-	t.Conn = &Conn{
-		conn:    conn,
-		devices: t.InitialDevices,
+	c := newConn(conn, t)
+	defer close(c.closed)
+	for _, f := range t.Faults {
+		c.InjectFault(f)
 	}
-	err = t.Conn.ReadMessages()
+	t.addConn(c)
+	err = c.ReadMessages()
 	if err != nil {
 		log.Printf("error: %v", err)
 		return
@@ -73,11 +101,194 @@ func (t *TestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// AddConn registers a new connection.
+func (t *TestServer) addConn(c *Conn) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.conns = append(t.conns, c)
+	t.Conn = c
+	if t.notify != nil {
+		close(t.notify)
+		t.notify = nil
+	}
+}
+
+// Conns returns all connections made to the server in order, including the
+// connections that are closed.
+func (t *TestServer) Conns() []*Conn {
+	t.m.Lock()
+	defer t.m.Unlock()
+	conns := make([]*Conn, len(t.conns))
+	copy(conns, t.conns)
+	return conns
+}
+
+// LastConn returns the most recent connection, or nil when there is none.
+func (t *TestServer) LastConn() *Conn {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if len(t.conns) == 0 {
+		return nil
+	}
+	return t.conns[len(t.conns)-1]
+}
+
+// ConnByName returns the most recent connection of the client that
+// identified itself with the given name in RequestServerInfo, or nil when
+// there is none.
+func (t *TestServer) ConnByName(name string) *Conn {
+	conns := t.Conns()
+	for i := len(conns) - 1; i >= 0; i-- {
+		if conns[i].ClientName() == name {
+			return conns[i]
+		}
+	}
+	return nil
+}
+
+// WaitConn waits until the server has accepted the nth (starting at 1)
+// connection and returns it.
+func (t *TestServer) WaitConn(n int, timeout time.Duration) (*Conn, error) {
+	deadline := time.After(timeout)
+	for {
+		t.m.Lock()
+		if len(t.conns) >= n {
+			c := t.conns[n-1]
+			t.m.Unlock()
+			return c, nil
+		}
+		if t.notify == nil {
+			t.notify = make(chan struct{})
+		}
+		notify := t.notify
+		t.m.Unlock()
+		select {
+		case <-notify:
+		case <-deadline:
+			return nil, fmt.Errorf("timeout waiting for connection %d", n)
+		}
+	}
+}
+
+// openConns returns all connections that are not closed.
+func (t *TestServer) openConns() []*Conn {
+	var open []*Conn
+	for _, c := range t.Conns() {
+		select {
+		case <-c.Closed():
+		default:
+			open = append(open, c)
+		}
+	}
+	return open
+}
+
+// SendScanningFinished will send a message to all connected clients that
+// scanning is finished.
+func (t *TestServer) SendScanningFinished() {
+	for _, c := range t.openConns() {
+		c.SendScanningFinished()
+	}
+}
+
+// AddDevice will send a message to all connected clients that the given
+// device has been added.
+func (t *TestServer) AddDevice(d *message.Device) {
+	for _, c := range t.openConns() {
+		c.AddDevice(d)
+	}
+}
+
+// RemoveDevice will send a message to all connected clients that the given
+// device has been removed.
+func (t *TestServer) RemoveDevice(d *message.Device) {
+	for _, c := range t.openConns() {
+		c.RemoveDevice(d)
+	}
+}
+
 // Conn is an established websocket connection with the testserver.
 type Conn struct {
-	sync.Mutex
-	conn    *websocket.Conn
-	devices []message.Device
+	sync.Mutex                           // Protects writing to conn and held.
+	conn       *websocket.Conn           // Websocket with the client.
+	held       []message.IncomingMessage // Responses held back for reordering.
+
+	strict      bool
+	maxPingTime time.Duration
+	handlers    map[string]Handler
+
+	sm        sync.Mutex       // Protects devices, handshake and lastPing.
+	devices   []message.Device // Devices known by the client.
+	handshake bool             // RequestServerInfo received.
+	lastPing  time.Time        // Time of the last ping.
+
+	fm     sync.Mutex // Protects faults.
+	faults []*Fault   // Injected faults.
+
+	hm      sync.Mutex    // Protects name, history, cursor and notify.
+	name    string        // Client name from RequestServerInfo.
+	history []Received    // All received messages.
+	cursor  int           // Next message in history to expect.
+	notify  chan struct{} // Closed when a message is received.
+	closed  chan struct{} // Closed when the connection is closed.
+}
+
+func newConn(conn *websocket.Conn, t *TestServer) *Conn {
+	maxPingTime := t.MaxPingTime
+	if maxPingTime == 0 {
+		maxPingTime = DefaultMaxPingTime
+	}
+	return &Conn{
+		conn:        conn,
+		strict:      t.Strict,
+		maxPingTime: time.Duration(maxPingTime) * time.Millisecond,
+		handlers:    t.Handlers,
+		devices:     append([]message.Device(nil), t.InitialDevices...),
+		notify:      make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+}
+
+// ClientName returns the name the client identified itself with, or an empty
+// string if it has not (yet) done so.
+func (c *Conn) ClientName() string {
+	c.hm.Lock()
+	defer c.hm.Unlock()
+	return c.name
+}
+
+// Closed returns a channel that is closed when the connection is closed.
+func (c *Conn) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// InjectFault adds a fault to the connection. Faults are evaluated in the
+// order they are added.
+func (c *Conn) InjectFault(f *Fault) {
+	c.fm.Lock()
+	defer c.fm.Unlock()
+	cf := *f
+	c.faults = append(c.faults, &cf)
+}
+
+// ClearFaults removes all injected faults from the connection.
+func (c *Conn) ClearFaults() {
+	c.fm.Lock()
+	defer c.fm.Unlock()
+	c.faults = nil
+}
+
+// Fault returns the first fault that must be applied to the message, or nil
+// when there is none.
+func (c *Conn) fault(m message.OutgoingMessage) *Fault {
+	c.fm.Lock()
+	defer c.fm.Unlock()
+	for _, f := range c.faults {
+		if f.apply(m) {
+			return f
+		}
+	}
+	return nil
 }
 
 // ReadMessages will read the messages from the websocket to be read and handled.
@@ -85,91 +296,151 @@ func (c *Conn) ReadMessages() error {
 	for {
 		var msgs message.OutgoingMessages
 		err := c.conn.ReadJSON(&msgs)
-		if _, ok := err.(*websocket.CloseError); ok {
-			return err
-		} else if err != nil {
+		switch err.(type) {
+		case nil:
+		case *json.SyntaxError, *json.UnmarshalTypeError:
 			log.Printf("error reading message: %v", err)
 			continue
+		default:
+			return err
 		}
 		for _, msg := range msgs {
+			c.receive(msg)
 			c.handleMessage(msg)
 		}
 	}
 }
 
 func (c *Conn) handleMessage(m message.OutgoingMessage) {
-	switch true {
-	case m.RequestServerInfo != nil:
-		id := m.RequestServerInfo.ID
-		log.Printf("<-RequestServerInfo (%d)", id)
-		c.sendServerInfo(id)
-	case m.RequestDeviceList != nil:
-		id := m.RequestDeviceList.ID
-		log.Printf("<-RequestDeviceList (%d)", id)
-		c.sendDeviceList(id)
-	case m.StopScanning != nil:
-		id := m.StopScanning.ID
-		log.Printf("<-StopScanning (%d)", id)
-		c.sendOk(id)
-	case m.Ping != nil:
-		id := m.Ping.ID
-		log.Printf("<-Ping (%d)", id)
-		c.sendOk(id)
-	case m.FleshlightLaunchFW12Cmd != nil:
-		id := m.FleshlightLaunchFW12Cmd.ID
-		pos, spd := m.FleshlightLaunchFW12Cmd.Position, m.FleshlightLaunchFW12Cmd.Speed
-		log.Printf("<-FleshlightLaunchFW12Cmd (%d) Postion = %d, Speed = %d", id, pos, spd)
-		c.sendOk(id)
-	case m.KiirooCmd != nil:
-		id := m.KiirooCmd.ID
-		log.Printf("<-KiirooCmd (%d)", id)
-		c.sendOk(id)
-	case m.LovenseCmd != nil:
-		id := m.LovenseCmd.ID
-		log.Printf("<-LovenseCmd (%d)", id)
-		c.sendOk(id)
-	case m.VorzeA10CycloneCmd != nil:
-		id := m.VorzeA10CycloneCmd.ID
-		spd := m.VorzeA10CycloneCmd.Speed
-		clockwise := m.VorzeA10CycloneCmd.Clockwise
-		log.Printf("<-VorzeA10CycloneCmd (%d) Speed = %d, Clockwise: = %t", id, spd, clockwise)
-		c.sendOk(id)
-	case m.RawCmd != nil:
-		id := m.RawCmd.ID
-		log.Printf("<-RawCmd (%d)", id)
-		c.sendOk(id)
-	case m.StartScanning != nil:
-		id := m.StartScanning.ID
-		log.Printf("<-StartScanning, (%d)", id)
-		c.sendOk(id)
-	case m.StopAllDevices != nil:
-		id := m.StopAllDevices.ID
-		log.Printf("<-StopAllDevices (%d)", id)
-		c.sendOk(id)
-	case m.StopDeviceCmd != nil:
-		id := m.StopDeviceCmd.ID
-		log.Printf("<-StopDeviceCmd (%d)", id)
-		c.sendOk(id)
-	}
-}
-
-func (c *Conn) sendOk(id uint32) {
-	msg := message.IncomingMessage{
-		Ok: &message.Empty{
-			ID: id,
-		},
+	resp := c.response(m)
+	f := c.fault(m)
+	if f == nil {
+		c.send(resp)
+		return
+	}
+	if d := f.delay(); d > 0 {
+		// Other messages are handled while waiting, so delayed
+		// responses can be send out of order.
+		go func() {
+			time.Sleep(d)
+			c.applyFault(f, m, resp)
+		}()
+		return
+	}
+	c.applyFault(f, m, resp)
+}
+
+// ApplyFault sends the response to message m as changed by fault f.
+func (c *Conn) applyFault(f *Fault, m message.OutgoingMessage, resp *message.IncomingMessage) {
+	id, _ := m.Message()
+	switch {
+	case f.Close:
+		log.Printf("->Close (%d)", id)
+		c.conn.Close()
+		return
+	case f.Malformed:
+		c.Lock()
+		defer c.Unlock()
+		err := c.conn.WriteMessage(websocket.TextMessage,
+			[]byte(fmt.Sprintf(`[{"Ok":{"Id":%d`, id)))
+		if err != nil {
+			log.Printf("error writing: %v", err)
+		}
+		log.Printf("->Malformed (%d)", id)
+		return
+	case f.Drop:
+		log.Printf("->Dropped (%d)", id)
+		return
+	case f.Error != "":
+		resp = &message.IncomingMessage{
+			Error: &message.Error{
+				ID:           id,
+				ErrorMessage: f.Error,
+			},
+		}
+	}
+	if f.Reorder && resp != nil {
+		c.Lock()
+		c.held = append(c.held, *resp)
+		c.Unlock()
+		log.Printf("->Held (%d)", id)
+		return
+	}
+	c.send(resp)
+}
+
+// Response returns the normal reply to a message received from the client.
+func (c *Conn) response(m message.OutgoingMessage) *message.IncomingMessage {
+	if c.strict {
+		if err := c.validate(m); err != nil {
+			id, _ := m.Message()
+			log.Printf("<-%s (%d) rejected: %v", m.Type(), id, err)
+			return &message.IncomingMessage{
+				Error: &message.Error{
+					ID:           id,
+					ErrorMessage: err.Error(),
+				},
+			}
+		}
+	}
+	typ := m.Type()
+	if typ == "" {
+		return nil
+	}
+	h, ok := c.handlers[typ]
+	if !ok {
+		h, ok = handlers[typ]
+	}
+	if !ok && m.Custom == nil {
+		h, ok = handleOk, true
+	}
+	if !ok {
+		id, _ := m.Message()
+		log.Printf("<-%s (%d) not handled", typ, id)
+		return &message.IncomingMessage{
+			Error: &message.Error{
+				ID:           id,
+				ErrorMessage: fmt.Sprintf("%s not supported", typ),
+			},
+		}
+	}
+	return h(c, m)
+}
+
+// Send writes a response, followed by all responses that were held back.
+func (c *Conn) send(msg *message.IncomingMessage) {
+	if msg == nil {
+		return
 	}
 	c.Lock()
 	defer c.Unlock()
+	msgs := append(message.IncomingMessages{*msg}, c.held...)
+	c.held = nil
+	for _, m := range msgs {
+		c.write(m)
+	}
+}
+
+// Write sends a single message to the client. Caller must hold the lock.
+func (c *Conn) write(msg message.IncomingMessage) {
 	err := c.conn.WriteJSON(message.IncomingMessages{msg})
 	if err != nil {
 		log.Printf("error writing: %v", err)
 	}
-	log.Printf("->Ok (%d)", id)
+	id, _ := msg.Message()
+	log.Printf("->%s (%d)", msg.Type(), id)
+}
+
+func ok(id uint32) *message.IncomingMessage {
+	return &message.IncomingMessage{
+		Ok: &message.Empty{
+			ID: id,
+		},
+	}
 }
 
-func (c *Conn) sendServerInfo(id uint32) {
-	msg := message.IncomingMessage{
+func (c *Conn) serverInfo(id uint32) *message.IncomingMessage {
+	return &message.IncomingMessage{
 		ServerInfo: &message.ServerInfo{
 			ID:             id,
 			ServerName:     "TestButtplug",
@@ -177,77 +448,66 @@ func (c *Conn) sendServerInfo(id uint32) {
 			MajorVersion:   1,
 			MinorVersion:   0,
 			BuildVersion:   0,
-			MaxPingTime:    100,
+			MaxPingTime:    uint32(c.maxPingTime / time.Millisecond),
 		},
 	}
-	c.Lock()
-	defer c.Unlock()
-	err := c.conn.WriteJSON(message.IncomingMessages{msg})
-	if err != nil {
-		log.Printf("error writing: %v", err)
-	}
-	log.Printf("->ServerInfo (%d)", id)
 }
 
-func (c *Conn) sendDeviceList(id uint32) {
-	msg := message.IncomingMessage{
+func (c *Conn) deviceList(id uint32) *message.IncomingMessage {
+	c.sm.Lock()
+	defer c.sm.Unlock()
+	return &message.IncomingMessage{
 		DeviceList: &message.DeviceList{
 			ID:      id,
-			Devices: c.devices,
+			Devices: append([]message.Device(nil), c.devices...),
 		},
 	}
-	c.Lock()
-	defer c.Unlock()
-	err := c.conn.WriteJSON(message.IncomingMessages{msg})
-	if err != nil {
-		log.Printf("error writing: %v", err)
-	}
-	log.Printf("->DeviceList (%d)", id)
 }
 
 // SendScanningFinished will send a message to the client that scanning is
 // finished.
 func (c *Conn) SendScanningFinished() {
-	msg := message.IncomingMessage{
+	c.send(&message.IncomingMessage{
 		ScanningFinished: &message.Empty{
 			ID: 0,
 		},
-	}
-	c.Lock()
-	defer c.Unlock()
-	err := c.conn.WriteJSON(message.IncomingMessages{msg})
-	if err != nil {
-		log.Printf("error writing: %v", err)
-	}
-	log.Println("->ScanningFinished (0)")
+	})
+}
+
+// SendLog will send a log message to the client.
+func (c *Conn) SendLog(level, msg string) {
+	c.send(&message.IncomingMessage{
+		Log: &message.Log{
+			ID:         0,
+			LogLevel:   level,
+			LogMessage: msg,
+		},
+	})
 }
 
 // AddDevice will send the a message to the client that the given device has
 // been added.
 func (c *Conn) AddDevice(d *message.Device) {
-	msg := message.IncomingMessage{
+	c.sm.Lock()
+	c.devices = append(c.devices, *d)
+	c.sm.Unlock()
+	c.send(&message.IncomingMessage{
 		DeviceAdded: d,
-	}
-	c.Lock()
-	defer c.Unlock()
-	err := c.conn.WriteJSON(message.IncomingMessages{msg})
-	if err != nil {
-		log.Printf("error writing: %v", err)
-	}
-	log.Println("->DeviceAdded (0)")
+	})
 }
 
 // RemoveDevice will send the a message to the client that the given device has
 // been removed.
 func (c *Conn) RemoveDevice(d *message.Device) {
-	msg := message.IncomingMessage{
-		DeviceRemoved: d,
-	}
-	c.Lock()
-	defer c.Unlock()
-	err := c.conn.WriteJSON(message.IncomingMessages{msg})
-	if err != nil {
-		log.Printf("error writing: %v", err)
+	c.sm.Lock()
+	for i, v := range c.devices {
+		if v.DeviceIndex == d.DeviceIndex {
+			c.devices = append(c.devices[:i], c.devices[i+1:]...)
+			break
+		}
 	}
-	log.Println("->DeviceRemoved (0)")
+	c.sm.Unlock()
+	c.send(&message.IncomingMessage{
+		DeviceRemoved: d,
+	})
 }
@@ -0,0 +1,166 @@
+package buttplugtest
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/funjack/golibbuttplug/message"
+	"github.com/gorilla/websocket"
+)
+
+func dial(t *testing.T, s *TestServer) (*websocket.Conn, func()) {
+	ts := httptest.NewServer(s)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return conn, func() {
+		conn.Close()
+		ts.Close()
+	}
+}
+
+// roundTrip sends a message and returns the reply.
+func roundTrip(t *testing.T, conn *websocket.Conn, m message.OutgoingMessage) message.IncomingMessage {
+	if err := conn.WriteJSON(message.OutgoingMessages{m}); err != nil {
+		t.Fatal(err)
+	}
+	var msgs message.IncomingMessages
+	if err := conn.ReadJSON(&msgs); err != nil {
+		t.Fatal(err)
+	}
+	return msgs[0]
+}
+
+func TestStrict(t *testing.T) {
+	s := &TestServer{
+		InitialDevices: DefaultTestServer.InitialDevices,
+		Strict:         true,
+		MaxPingTime:    1000,
+	}
+	conn, done := dial(t, s)
+	defer done()
+
+	if r := roundTrip(t, conn, message.OutgoingMessage{
+		StartScanning: &message.Empty{ID: 1},
+	}); r.Error == nil {
+		t.Errorf("message before handshake accepted")
+	}
+	if r := roundTrip(t, conn, message.OutgoingMessage{
+		RequestServerInfo: &message.RequestServerInfo{ID: 2, ClientName: "Strict"},
+	}); r.ServerInfo == nil || r.ServerInfo.MaxPingTime != 1000 {
+		t.Errorf("unexpected handshake reply: %+v", r)
+	}
+
+	cases := []struct {
+		Name  string
+		Msg   message.OutgoingMessage
+		Valid bool
+	}{
+		{
+			Name: "Valid",
+			Msg: message.OutgoingMessage{
+				SingleMotorVibrateCmd: &message.SingleMotorVibrateCmd{ID: 3, DeviceIndex: 0, Speed: 0.5},
+			},
+			Valid: true,
+		},
+		{
+			Name: "UnknownDevice",
+			Msg: message.OutgoingMessage{
+				StopDeviceCmd: &message.Device{ID: 4, DeviceIndex: 9},
+			},
+		},
+		{
+			Name: "Unsupported",
+			Msg: message.OutgoingMessage{
+				LovenseCmd: &message.LovenseCmd{ID: 5, DeviceIndex: 0, Command: "Vibrate:1;"},
+			},
+		},
+		{
+			Name: "Range",
+			Msg: message.OutgoingMessage{
+				FleshlightLaunchFW12Cmd: &message.FleshlightLaunchFW12Cmd{ID: 6, DeviceIndex: 2, Position: 100},
+			},
+		},
+	}
+	for _, c := range cases {
+		r := roundTrip(t, conn, c.Msg)
+		if c.Valid && r.Ok == nil {
+			t.Errorf("%s: expected ok, got %+v", c.Name, r)
+		} else if !c.Valid && r.Error == nil {
+			t.Errorf("%s: expected error, got %+v", c.Name, r)
+		}
+	}
+}
+
+func TestStrictPingTimeout(t *testing.T) {
+	s := &TestServer{
+		Strict:      true,
+		MaxPingTime: 50,
+	}
+	conn, done := dial(t, s)
+	defer done()
+
+	roundTrip(t, conn, message.OutgoingMessage{
+		RequestServerInfo: &message.RequestServerInfo{ID: 1, ClientName: "Strict"},
+	})
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var msgs message.IncomingMessages
+	if err := conn.ReadJSON(&msgs); err != nil {
+		t.Fatal(err)
+	}
+	if msgs[0].Error == nil {
+		t.Errorf("expected ping timeout error, got %+v", msgs[0])
+	}
+	if err := conn.ReadJSON(&msgs); err == nil {
+		t.Errorf("connection not closed")
+	}
+}
+
+// VendorCmd is a message that is not part of the spec.
+type VendorCmd struct {
+	ID      uint32 `json:"Id"`
+	Pattern string
+}
+
+func init() {
+	message.Register("VendorCmd", VendorCmd{})
+	message.Register("OtherVendorCmd", VendorCmd{})
+}
+
+func TestHandlers(t *testing.T) {
+	s := &TestServer{
+		Handlers: map[string]Handler{
+			"VendorCmd": func(c *Conn, m message.OutgoingMessage) *message.IncomingMessage {
+				id, v := m.Message()
+				return &message.IncomingMessage{
+					Test: &message.Test{ID: id, TestString: v.(VendorCmd).Pattern},
+				}
+			},
+			"Ping": func(c *Conn, m message.OutgoingMessage) *message.IncomingMessage {
+				return nil
+			},
+		},
+	}
+	conn, done := dial(t, s)
+	defer done()
+
+	r := roundTrip(t, conn, message.OutgoingMessage{
+		Custom: &message.Typed{Name: "VendorCmd", Value: &VendorCmd{ID: 1, Pattern: "wave"}},
+	})
+	if r.Test == nil || r.Test.ID != 1 || r.Test.TestString != "wave" {
+		t.Errorf("unexpected vendor reply: %+v", r)
+	}
+	// Overridden built-in handler does not reply.
+	if err := conn.WriteJSON(message.OutgoingMessages{{Ping: &message.Empty{ID: 2}}}); err != nil {
+		t.Fatal(err)
+	}
+	if r := roundTrip(t, conn, message.OutgoingMessage{
+		Custom: &message.Typed{Name: "OtherVendorCmd", Value: &VendorCmd{ID: 3}},
+	}); r.Error == nil || r.Error.ID != 3 {
+		t.Errorf("expected error for unhandled message, got %+v", r)
+	}
+}
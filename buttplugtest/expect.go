@@ -0,0 +1,121 @@
+package buttplugtest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/funjack/golibbuttplug/message"
+)
+
+// ErrTimeout is returned when an expected message is not received in time.
+var ErrTimeout = errors.New("timeout waiting for message")
+
+// Received is a message received by the test server.
+type Received struct {
+	// Time the message was received.
+	Time time.Time
+	// Message as it was send by the client.
+	Message message.OutgoingMessage
+}
+
+func (r Received) String() string {
+	return toGeneric(r.Message).String()
+}
+
+// MatchMessage matches messages equal to want, not comparing the message ids.
+func MatchMessage(want message.OutgoingMessage) Matcher {
+	w := toGeneric(want)
+	return func(m message.OutgoingMessage) bool {
+		return toGeneric(m).equalIgnoringID(w)
+	}
+}
+
+// History returns all messages received on the connection in order.
+func (c *Conn) History() []Received {
+	c.hm.Lock()
+	defer c.hm.Unlock()
+	h := make([]Received, len(c.history))
+	copy(h, c.history)
+	return h
+}
+
+// WaitFor waits until a message matching match is received and returns it.
+// Only messages that were not yet returned by WaitFor or Next are considered,
+// all messages up to the match are consumed.
+func (c *Conn) WaitFor(timeout time.Duration, match Matcher) (Received, error) {
+	deadline := time.After(timeout)
+	for {
+		c.hm.Lock()
+		for c.cursor < len(c.history) {
+			r := c.history[c.cursor]
+			c.cursor++
+			if match == nil || match(r.Message) {
+				c.hm.Unlock()
+				return r, nil
+			}
+		}
+		notify := c.notify
+		c.hm.Unlock()
+		select {
+		case <-notify:
+		case <-deadline:
+			return Received{}, ErrTimeout
+		}
+	}
+}
+
+// Next waits for the next message that is not a ping.
+func (c *Conn) Next(timeout time.Duration) (Received, error) {
+	ping := MatchType("Ping")
+	return c.WaitFor(timeout, func(m message.OutgoingMessage) bool {
+		return !ping(m)
+	})
+}
+
+// ExpectNext waits for the next message that is not a ping and returns an
+// error if it is not equal to want. Message ids are not compared. Eg:
+//
+//	err := conn.ExpectNext(time.Second, message.OutgoingMessage{
+//		FleshlightLaunchFW12Cmd: &message.FleshlightLaunchFW12Cmd{
+//			DeviceIndex: 2,
+//			Position:    50,
+//			Speed:       20,
+//		},
+//	})
+func (c *Conn) ExpectNext(timeout time.Duration, want message.OutgoingMessage) error {
+	r, err := c.Next(timeout)
+	if err != nil {
+		return fmt.Errorf("expected %s: %v", toGeneric(want), err)
+	}
+	if !MatchMessage(want)(r.Message) {
+		return fmt.Errorf("expected %s, got %s", toGeneric(want), r)
+	}
+	return nil
+}
+
+// Receive adds a message to the history.
+func (c *Conn) receive(m message.OutgoingMessage) {
+	c.hm.Lock()
+	defer c.hm.Unlock()
+	c.history = append(c.history, Received{
+		Time:    time.Now(),
+		Message: m,
+	})
+	close(c.notify)
+	c.notify = make(chan struct{})
+}
+
+// ToGeneric converts a message into its generic form.
+func toGeneric(m message.OutgoingMessage) genericMessage {
+	var g []genericMessage
+	b, err := json.Marshal(message.OutgoingMessages{m})
+	if err == nil {
+		err = json.Unmarshal(b, &g)
+	}
+	if err != nil || len(g) != 1 {
+		return genericMessage{}
+	}
+	return g[0]
+}
@@ -0,0 +1,113 @@
+package buttplugtest
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/funjack/golibbuttplug/message"
+)
+
+// Matcher reports whether a message received from the client matches.
+type Matcher func(m message.OutgoingMessage) bool
+
+// MatchType matches messages of any of the given types, eg: "Ping" or
+// "FleshlightLaunchFW12Cmd".
+func MatchType(types ...string) Matcher {
+	return func(m message.OutgoingMessage) bool {
+		t := m.Type()
+		for _, v := range types {
+			if v == t {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchDevice matches device messages for the device with the given index.
+func MatchDevice(index uint32) Matcher {
+	return func(m message.OutgoingMessage) bool {
+		i, ok := m.DeviceIndex()
+		return ok && i == index
+	}
+}
+
+// Fault describes a misbehaviour the test server injects when it handles a
+// message from the client. When multiple faults match a message only the
+// first active one is applied.
+type Fault struct {
+	// Match selects the messages the fault applies to. Nil matches all
+	// messages.
+	Match Matcher
+	// After is the number of matching messages that are handled normally
+	// before the fault becomes active.
+	After int
+	// Count is the number of times the fault is applied once active. Zero
+	// means the fault is applied forever.
+	Count int
+
+	// Delay before the response is send. Other messages are handled
+	// while waiting.
+	Delay time.Duration
+	// Jitter adds a random delay of up to this duration, responses can
+	// be send in a different order than the messages were received.
+	Jitter time.Duration
+
+	// Error responds with an Error message with this text instead of the
+	// normal reply.
+	Error string
+	// Drop the response.
+	Drop bool
+	// Reorder holds the response back and sends it after the next one.
+	Reorder bool
+	// Malformed sends invalid JSON instead of the response.
+	Malformed bool
+	// Close the websocket connection instead of responding.
+	Close bool
+
+	seen    int // Number of matching messages seen.
+	applied int // Number of times the fault is applied.
+}
+
+// CloseAfter returns a fault that closes the connection after n messages
+// have been handled.
+func CloseAfter(n int) *Fault {
+	return &Fault{
+		After: n,
+		Close: true,
+	}
+}
+
+// DropPings returns a fault that makes the server stop answering pings.
+func DropPings() *Fault {
+	return &Fault{
+		Match: MatchType("Ping"),
+		Drop:  true,
+	}
+}
+
+// apply returns true when the fault must be applied to the message and
+// updates the counters.
+func (f *Fault) apply(m message.OutgoingMessage) bool {
+	if f.Match != nil && !f.Match(m) {
+		return false
+	}
+	f.seen++
+	if f.seen <= f.After {
+		return false
+	}
+	if f.Count != 0 && f.applied >= f.Count {
+		return false
+	}
+	f.applied++
+	return true
+}
+
+// Delay returns the delay of the response including a random jitter.
+func (f *Fault) delay() time.Duration {
+	d := f.Delay
+	if f.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(f.Jitter)))
+	}
+	return d
+}
@@ -0,0 +1,110 @@
+package buttplugtest
+
+import (
+	"log"
+	"time"
+
+	"github.com/funjack/golibbuttplug/message"
+)
+
+// Handler returns the reply to a message received from the client, or nil
+// when there is no reply.
+type Handler func(c *Conn, m message.OutgoingMessage) *message.IncomingMessage
+
+// Handlers are the built-in handlers by message type. Other built-in
+// messages are handled by handleOk.
+var handlers = map[string]Handler{
+	"RequestServerInfo":       handleRequestServerInfo,
+	"RequestDeviceList":       handleRequestDeviceList,
+	"Ping":                    handlePing,
+	"Test":                    handleTest,
+	"RequestLog":              handleRequestLog,
+	"SingleMotorVibrateCmd":   handleSingleMotorVibrateCmd,
+	"FleshlightLaunchFW12Cmd": handleFleshlightLaunchFW12Cmd,
+	"VorzeA10CycloneCmd":      handleVorzeA10CycloneCmd,
+}
+
+// HandleOk replies Ok to any message.
+func handleOk(c *Conn, m message.OutgoingMessage) *message.IncomingMessage {
+	id, _ := m.Message()
+	log.Printf("<-%s (%d)", m.Type(), id)
+	return ok(id)
+}
+
+func handleRequestServerInfo(c *Conn, m message.OutgoingMessage) *message.IncomingMessage {
+	id := m.RequestServerInfo.ID
+	log.Printf("<-RequestServerInfo (%d)", id)
+	c.hm.Lock()
+	c.name = m.RequestServerInfo.ClientName
+	c.hm.Unlock()
+	c.sm.Lock()
+	start := !c.handshake && c.strict
+	c.handshake = true
+	c.lastPing = time.Now()
+	c.sm.Unlock()
+	if start {
+		go c.pingWatchdog()
+	}
+	return c.serverInfo(id)
+}
+
+func handleRequestDeviceList(c *Conn, m message.OutgoingMessage) *message.IncomingMessage {
+	id := m.RequestDeviceList.ID
+	log.Printf("<-RequestDeviceList (%d)", id)
+	return c.deviceList(id)
+}
+
+func handlePing(c *Conn, m message.OutgoingMessage) *message.IncomingMessage {
+	id := m.Ping.ID
+	log.Printf("<-Ping (%d)", id)
+	c.sm.Lock()
+	c.lastPing = time.Now()
+	c.sm.Unlock()
+	return ok(id)
+}
+
+func handleTest(c *Conn, m message.OutgoingMessage) *message.IncomingMessage {
+	id := m.Test.ID
+	log.Printf("<-Test (%d)", id)
+	if m.Test.TestString == "Error" {
+		return &message.IncomingMessage{
+			Error: &message.Error{
+				ID:           id,
+				ErrorMessage: "Error",
+			},
+		}
+	}
+	return &message.IncomingMessage{
+		Test: &message.Test{
+			ID:         id,
+			TestString: m.Test.TestString,
+		},
+	}
+}
+
+func handleRequestLog(c *Conn, m message.OutgoingMessage) *message.IncomingMessage {
+	id := m.RequestLog.ID
+	log.Printf("<-RequestLog (%d) LogLevel = %s", id, m.RequestLog.LogLevel)
+	return ok(id)
+}
+
+func handleSingleMotorVibrateCmd(c *Conn, m message.OutgoingMessage) *message.IncomingMessage {
+	id := m.SingleMotorVibrateCmd.ID
+	log.Printf("<-SingleMotorVibrateCmd (%d) Speed = %g", id, m.SingleMotorVibrateCmd.Speed)
+	return ok(id)
+}
+
+func handleFleshlightLaunchFW12Cmd(c *Conn, m message.OutgoingMessage) *message.IncomingMessage {
+	id := m.FleshlightLaunchFW12Cmd.ID
+	pos, spd := m.FleshlightLaunchFW12Cmd.Position, m.FleshlightLaunchFW12Cmd.Speed
+	log.Printf("<-FleshlightLaunchFW12Cmd (%d) Postion = %d, Speed = %d", id, pos, spd)
+	return ok(id)
+}
+
+func handleVorzeA10CycloneCmd(c *Conn, m message.OutgoingMessage) *message.IncomingMessage {
+	id := m.VorzeA10CycloneCmd.ID
+	spd := m.VorzeA10CycloneCmd.Speed
+	clockwise := m.VorzeA10CycloneCmd.Clockwise
+	log.Printf("<-VorzeA10CycloneCmd (%d) Speed = %d, Clockwise: = %t", id, spd, clockwise)
+	return ok(id)
+}
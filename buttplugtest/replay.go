@@ -0,0 +1,250 @@
+package buttplugtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/funjack/golibbuttplug/message"
+	"github.com/gorilla/websocket"
+)
+
+// ReplayServer plays back a recorded session to a client and verifies the
+// client sends the expected messages.
+//
+// Pings and their replies are left out of the replay, the server answers
+// pings whenever they arrive. Message ids are not compared, replies are send
+// with the ids the client used. Messages from the server are send with the
+// recorded delay between frames.
+type ReplayServer struct {
+	records []message.Record
+
+	m    sync.Mutex // Protects errs.
+	errs []error
+	once sync.Once
+	done chan struct{}
+}
+
+// NewReplayServer creates a server that replays the given session.
+func NewReplayServer(records []message.Record) *ReplayServer {
+	return &ReplayServer{
+		records: records,
+		done:    make(chan struct{}),
+	}
+}
+
+// Done returns a channel that is closed when the replayed session has ended.
+func (s *ReplayServer) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the first mismatch found during the replay, or nil when the
+// client behaved like the recorded session.
+func (s *ReplayServer) Err() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if len(s.errs) == 0 {
+		return nil
+	}
+	return s.errs[0]
+}
+
+func (s *ReplayServer) errorf(format string, v ...interface{}) {
+	err := fmt.Errorf(format, v...)
+	log.Printf("replay: %v", err)
+	s.m.Lock()
+	s.errs = append(s.errs, err)
+	s.m.Unlock()
+}
+
+func (s *ReplayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+	defer s.once.Do(func() { close(s.done) })
+	steps, err := replaySteps(s.records)
+	if err != nil {
+		s.errorf("invalid recording: %v", err)
+		return
+	}
+	rc := &replayConn{
+		conn:     conn,
+		incoming: make(chan genericMessage),
+		closed:   make(chan struct{}),
+		quit:     make(chan struct{}),
+	}
+	defer close(rc.quit)
+	go rc.readLoop()
+	s.replay(rc, steps)
+}
+
+func (s *ReplayServer) replay(rc *replayConn, steps []replayStep) {
+	ids := make(map[uint32]uint32) // Recorded ids to actual ids.
+	var last, at time.Time         // Time of last step in recording and replay.
+	for i, step := range steps {
+		if step.dir == message.DirIncoming {
+			if !last.IsZero() {
+				time.Sleep(step.time.Sub(last) - time.Since(at))
+			}
+			m := step.msg.withID(ids)
+			if err := rc.write(m); err != nil {
+				s.errorf("step %d: write error: %v", i, err)
+				return
+			}
+			last, at = step.time, time.Now()
+			continue
+		}
+		select {
+		case m := <-rc.incoming:
+			if !m.equalIgnoringID(step.msg) {
+				s.errorf("step %d: expected %s, got %s", i, step.msg, m)
+				rc.write(genericMessage{"Error": {
+					"Id":           float64(m.id()),
+					"ErrorMessage": "unexpected message in replay",
+				}})
+				return
+			}
+			ids[step.msg.id()] = m.id()
+			last, at = step.time, time.Now()
+		case <-rc.closed:
+			s.errorf("step %d: client disconnected, expected %s", i, step.msg)
+			return
+		}
+	}
+	// Session is over, report anything else the client sends.
+	for {
+		select {
+		case m := <-rc.incoming:
+			s.errorf("unexpected message after replay: %s", m)
+		case <-rc.closed:
+			return
+		}
+	}
+}
+
+// ReplayStep is a single message to be send or received.
+type replayStep struct {
+	time time.Time
+	dir  message.Direction
+	msg  genericMessage
+}
+
+// ReplaySteps flattens the recorded frames into steps, leaving out pings and
+// the replies to them.
+func replaySteps(records []message.Record) ([]replayStep, error) {
+	var steps []replayStep
+	pings := make(map[uint32]bool)
+	for _, rec := range records {
+		var msgs []genericMessage
+		if err := json.Unmarshal(rec.Frame, &msgs); err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			if rec.Direction == message.DirOutgoing && m.typ() == "Ping" {
+				pings[m.id()] = true
+				continue
+			}
+			if rec.Direction == message.DirIncoming && m.typ() == "Ok" && pings[m.id()] {
+				continue
+			}
+			steps = append(steps, replayStep{
+				time: rec.Time,
+				dir:  rec.Direction,
+				msg:  m,
+			})
+		}
+	}
+	return steps, nil
+}
+
+// ReplayConn answers pings and passes on all other received messages.
+type replayConn struct {
+	m        sync.Mutex // Protects writes on conn.
+	conn     *websocket.Conn
+	incoming chan genericMessage
+	closed   chan struct{} // Closed when the client disconnects.
+	quit     chan struct{} // Closed when the replay has ended.
+}
+
+func (rc *replayConn) readLoop() {
+	defer close(rc.closed)
+	for {
+		var msgs []genericMessage
+		err := rc.conn.ReadJSON(&msgs)
+		if _, ok := err.(*json.SyntaxError); ok {
+			log.Printf("error reading message: %v", err)
+			continue
+		} else if err != nil {
+			return
+		}
+		for _, m := range msgs {
+			if m.typ() == "Ping" {
+				rc.write(genericMessage{"Ok": {"Id": float64(m.id())}})
+				continue
+			}
+			select {
+			case rc.incoming <- m:
+			case <-rc.quit:
+				return
+			}
+		}
+	}
+}
+
+func (rc *replayConn) write(m genericMessage) error {
+	rc.m.Lock()
+	defer rc.m.Unlock()
+	return rc.conn.WriteJSON([]genericMessage{m})
+}
+
+// GenericMessage is a decoded message of any type.
+type genericMessage map[string]map[string]interface{}
+
+func (m genericMessage) typ() string {
+	for k := range m {
+		return k
+	}
+	return ""
+}
+
+func (m genericMessage) id() uint32 {
+	for _, v := range m {
+		id, _ := v["Id"].(float64)
+		return uint32(id)
+	}
+	return 0
+}
+
+// WithID returns a copy of the message with the id replaced by the mapped
+// id.
+func (m genericMessage) withID(ids map[uint32]uint32) genericMessage {
+	c := make(genericMessage, len(m))
+	for k, v := range m {
+		fields := make(map[string]interface{}, len(v))
+		for f, fv := range v {
+			fields[f] = fv
+		}
+		if id, ok := ids[m.id()]; ok {
+			fields["Id"] = float64(id)
+		}
+		c[k] = fields
+	}
+	return c
+}
+
+func (m genericMessage) equalIgnoringID(v genericMessage) bool {
+	return reflect.DeepEqual(m.withID(map[uint32]uint32{m.id(): 0}),
+		v.withID(map[uint32]uint32{v.id(): 0}))
+}
+
+func (m genericMessage) String() string {
+	b, _ := json.Marshal(m)
+	return string(b)
+}
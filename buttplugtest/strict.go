@@ -0,0 +1,83 @@
+package buttplugtest
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/funjack/golibbuttplug/message"
+)
+
+// DefaultMaxPingTime is the ping time send to clients when the TestServer
+// has none configured.
+const DefaultMaxPingTime = 100
+
+// Validate checks if the message is valid for the current state of the
+// connection. Only used in strict mode.
+func (c *Conn) validate(m message.OutgoingMessage) error {
+	c.sm.Lock()
+	defer c.sm.Unlock()
+	typ := m.Type()
+	if !c.handshake {
+		if typ != "RequestServerInfo" {
+			return fmt.Errorf("%s received before RequestServerInfo", typ)
+		}
+		return nil
+	} else if typ == "RequestServerInfo" {
+		return fmt.Errorf("RequestServerInfo already received")
+	}
+	index, ok := m.DeviceIndex()
+	if !ok {
+		return m.Validate()
+	}
+	var dev *message.Device
+	for i := range c.devices {
+		if c.devices[i].DeviceIndex == index {
+			dev = &c.devices[i]
+		}
+	}
+	if dev == nil {
+		return fmt.Errorf("unknown device index %d", index)
+	}
+	if !supports(dev, typ) {
+		return fmt.Errorf("%s not supported by device %d", typ, index)
+	}
+	return m.Validate()
+}
+
+func supports(d *message.Device, typ string) bool {
+	for _, v := range d.DeviceMessages {
+		if v == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// PingWatchdog closes the connection with an error when the client stops
+// sending pings within the max ping time.
+func (c *Conn) pingWatchdog() {
+	ticker := time.NewTicker(c.maxPingTime / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+		}
+		c.sm.Lock()
+		last := c.lastPing
+		c.sm.Unlock()
+		if time.Since(last) > c.maxPingTime {
+			log.Printf("ping timeout")
+			c.send(&message.IncomingMessage{
+				Error: &message.Error{
+					ID:           0,
+					ErrorMessage: "ping timeout",
+				},
+			})
+			c.conn.Close()
+			return
+		}
+	}
+}
@@ -12,8 +12,10 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/url"
+	"strconv"
 	"sync"
 	"time"
 
@@ -30,26 +32,88 @@ var defaultTimeout = time.Second * 30
 // server.
 type Client struct {
 	ctx     context.Context
-	conn    *websocket.Conn    // Websocket connection with Buttplug server.
-	counter *message.IDCounter // Message ID counter
+	conn    message.Conn        // Websocket connection with Buttplug server.
+	counter *message.IDCounter  // Message ID counter
+	record  io.Writer           // Record session to writer when not nil.
+	decoder message.Decoder     // Decoder for server messages.
+	policy  message.QueuePolicy // Policy of the send queue.
+	metrics message.Metrics     // Receives traffic measurements.
+	tracers tracers             // Trace requests.
+
+	interceptors []Interceptor                 // Intercept outgoing messages.
+	incoming     []message.IncomingInterceptor // Intercept incoming messages.
+	roundTripper RoundTripFunc                 // Transport with interceptors.
 
 	once     sync.Once         // Ensure Close() is executed only once.
 	stop     chan struct{}     // Halts pingLoop and eventLoop goroutines.
 	sender   *message.Sender   // Sending messages.
 	receiver *message.Receiver // Receiving messages.
 
-	m       sync.RWMutex       // Protects devices map.
-	devices map[uint32]*Device // Devices by their DeviceIndex
+	info  message.ServerInfo // Server info received during handshake.
+	pings pingMonitor        // Ping round-trip times and health.
+
+	m        sync.RWMutex       // Protects devices map, added and finished.
+	devices  map[uint32]*Device // Devices by their DeviceIndex
+	added    chan struct{}      // Closed when a device is added.
+	finished chan struct{}      // Closed when scanning has finished.
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// Record writes every message frame exchanged with the server to w. See
+// message.Recorder for the format.
+func Record(w io.Writer) Option {
+	return func(c *Client) {
+		c.record = w
+	}
+}
+
+// StrictDecoding drops frames from the server that contain messages which
+// are not valid according to the message spec, or that do not contain exactly
+// one message type per message. The reason is logged, which helps diagnosing
+// server compatibility problems.
+func StrictDecoding() Option {
+	return func(c *Client) {
+		c.decoder = message.Decoder{Strict: true, Validate: true}
+	}
+}
+
+// SendPolicy sets how messages are handled when the send queue is full, see
+// message.QueuePolicy. With message.QueueBlock commands wait until there is
+// room in the queue.
+func SendPolicy(p message.QueuePolicy) Option {
+	return func(c *Client) {
+		c.policy = p
+	}
+}
+
+// ReportMetrics reports the messages send and received, command latencies,
+// errors, the send queue depth, the amount of subscribers and connects to m.
+// Use message.NewExpvarMetrics to publish them with the expvar package.
+func ReportMetrics(m message.Metrics) Option {
+	return func(c *Client) {
+		c.metrics = m
+	}
 }
 
 // NewClient returns a new client with a connection to a Buttplug server.
-func NewClient(ctx context.Context, addr, name string, tlscfg *tls.Config) (c *Client, err error) {
+func NewClient(ctx context.Context, addr, name string, tlscfg *tls.Config, opts ...Option) (c *Client, err error) {
 	c = &Client{
-		ctx:     ctx,
-		counter: new(message.IDCounter),
-		stop:    make(chan struct{}),
-		devices: make(map[uint32]*Device),
+		ctx:      ctx,
+		counter:  new(message.IDCounter),
+		stop:     make(chan struct{}),
+		devices:  make(map[uint32]*Device),
+		added:    make(chan struct{}),
+		finished: make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.metrics == nil {
+		c.metrics = message.NopMetrics{}
+	}
+	c.roundTripper = chain(c.interceptors, c.transport)
 	// Create websocket connection
 	u, err := url.ParseRequestURI(addr)
 	if err != nil {
@@ -58,20 +122,25 @@ func NewClient(ctx context.Context, addr, name string, tlscfg *tls.Config) (c *C
 	dailer := &websocket.Dialer{
 		TLSClientConfig: tlscfg,
 	}
-	c.conn, _, err = dailer.Dial(u.String(), nil)
+	ws, _, err := dailer.Dial(u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
+	c.conn = ws
+	if c.record != nil {
+		c.conn = message.NewRecorder(ws, c.record)
+	}
 	// Start the reader and writer.
-	c.receiver = message.NewReceiver(c.conn, c.stop)
-	c.sender = message.NewSender(c.conn)
-	go func() {
-		select {
-		case <-ctx.Done():
-		case <-c.stop:
-		}
-		c.Close()
-	}()
+	c.receiver = message.NewReceiver(c.conn, c.stop,
+		message.DecodeWith(c.decoder),
+		message.ReceiverMetrics(c.metrics),
+		message.Intercept(c.incoming...),
+	)
+	c.sender = message.NewSender(c.conn,
+		message.SendPolicy(c.policy),
+		message.SenderMetrics(c.metrics),
+	)
+	go c.closeOnDone()
 	// Initialize a session with the server.
 	if name == "" {
 		name = DefaultName
@@ -87,13 +156,25 @@ func NewClient(ctx context.Context, addr, name string, tlscfg *tls.Config) (c *C
 		c.Close()
 		return nil, err
 	}
+	c.metrics.Add(message.MetricConnects, c.info.ServerName, 1)
 	return c, nil
 }
 
+// CloseOnDone closes the client when the context is done or the connection
+// is stopped.
+func (c *Client) closeOnDone() {
+	select {
+	case <-c.ctx.Done():
+	case <-c.stop:
+	}
+	c.Close()
+}
+
 // Close the connection.
 func (c *Client) Close() {
 	c.once.Do(func() {
 		log.Printf("Closing connection to Buttplug")
+		c.pings.setHealth(Lost)
 		c.sender.Stop()
 		c.receiver.Stop()
 		<-c.stop
@@ -114,13 +195,9 @@ func (c *Client) initSession(name string) error {
 			ClientName: name,
 		},
 	}
-	if err := c.sender.Send(r); err != nil {
-		return err
-	}
-	// Read reply
 	ctx, cancel := context.WithTimeout(c.ctx, defaultTimeout)
 	defer cancel()
-	m, err := c.receiveMessage(ctx, id)
+	m, err := c.roundTrip(ctx, r)
 	if err != nil {
 		return err
 	}
@@ -128,44 +205,23 @@ func (c *Client) initSession(name string) error {
 		return errors.New("no serverinfo received")
 	}
 	si := *m.ServerInfo
+	c.info = si
 	log.Printf("Connected to Buttplug %s (%d.%d.%d)", si.ServerName,
 		si.BuildVersion, si.MajorVersion, si.MinorVersion)
 	// Start ping goroutine
 	interval := 500 * time.Millisecond
-	if si.MaxPingTime != 0 && si.MaxPingTime < 1000 {
-		interval = time.Duration(si.MaxPingTime/2) * time.Millisecond
-	}
-	go c.pingLoop(interval)
-	return nil
-}
-
-// PingLoop sends out pings.
-func (c *Client) pingLoop(d time.Duration) {
-	c.ping()
-	for {
-		select {
-		case <-c.ctx.Done():
-			return
-		case <-c.stop:
-			return
-		case <-time.After(d):
-			c.ping()
+	timeout := defaultTimeout
+	if si.MaxPingTime != 0 {
+		// The server ends the session when it receives no ping within
+		// MaxPingTime. A ping is missed after half of it, so the next
+		// ping is still send in time.
+		timeout = time.Duration(si.MaxPingTime) * time.Millisecond / 2
+		if si.MaxPingTime < 1000 {
+			interval = timeout
 		}
 	}
-}
-
-// Ping to server and close when an error comes back.
-func (c *Client) ping() {
-	id := c.counter.Generate()
-	m := message.OutgoingMessage{
-		Ping: &message.Empty{
-			ID: id,
-		},
-	}
-	if err := c.sendMessage(id, m); err != nil {
-		log.Printf("ping error: %v", err)
-		c.Close()
-	}
+	go c.pingLoop(interval, timeout)
+	return nil
 }
 
 // InitDeviceList syncs up client device list with server.
@@ -177,23 +233,28 @@ func (c *Client) initDeviceList() error {
 			ID: id,
 		},
 	}
-	if err := c.sender.Send(r); err != nil {
-		return err
-	}
-	// Recreive response
 	ctx, cancel := context.WithTimeout(c.ctx, defaultTimeout)
 	defer cancel()
-	m, err := c.receiveMessage(ctx, id)
+	m, err := c.roundTrip(ctx, r)
 	if err != nil {
 		return err
 	}
+	if m.Error != nil {
+		return fmt.Errorf("server error: %s", m.Error.ErrorMessage)
+	}
+	if m.DeviceList == nil {
+		return errors.New("no devicelist received")
+	}
 	// Update DeviceList
 	dl := *m.DeviceList
 	for _, d := range dl.Devices {
 		c.addDevice(d)
 	}
 	// Start event watcher goroutine.
-	s, err := c.receiver.Subscribe()
+	s, err := c.receiver.Subscribe(
+		message.FilterTypes("DeviceAdded", "DeviceRemoved", "ScanningFinished"),
+		message.OnOverflow(message.OverflowUnbounded),
+	)
 	if err != nil {
 		return err
 	}
@@ -201,14 +262,29 @@ func (c *Client) initDeviceList() error {
 	return nil
 }
 
+// Events handles server events by message type.
+var events = map[string]func(c *Client, m message.IncomingMessage){
+	"DeviceAdded": func(c *Client, m message.IncomingMessage) {
+		c.addDevice(*m.DeviceAdded)
+	},
+	"DeviceRemoved": func(c *Client, m message.IncomingMessage) {
+		c.removeDevice(*m.DeviceRemoved)
+	},
+	// Handled here, so devices added before scanning finished are known
+	// when Scan is notified.
+	"ScanningFinished": func(c *Client, m message.IncomingMessage) {
+		c.m.Lock()
+		defer c.m.Unlock()
+		close(c.finished)
+		c.finished = make(chan struct{})
+	},
+}
+
 // EventLoop watches for (device) events.
 func (c *Client) eventLoop(in *message.Reader) {
 	for m := range in.Incoming() {
-		if m.DeviceAdded != nil {
-			c.addDevice(*m.DeviceAdded)
-		}
-		if m.DeviceRemoved != nil {
-			c.removeDevice(*m.DeviceRemoved)
+		if h, ok := events[m.Type()]; ok {
+			h(c, m)
 		}
 	}
 }
@@ -223,6 +299,8 @@ func (c *Client) addDevice(d message.Device) {
 		device: d,
 		done:   make(chan struct{}),
 	}
+	close(c.added)
+	c.added = make(chan struct{})
 }
 
 // RemoveDevice from the device list.
@@ -252,22 +330,68 @@ func (c *Client) removeAllDevices() {
 	}
 }
 
-// ReceiveMessage waits for and reads a message with a given id.
-func (c *Client) receiveMessage(ctx context.Context, id uint32) (message.IncomingMessage, error) {
-	r, err := c.receiver.Subscribe()
+// RoundTrip sends a message through the interceptors and waits for the reply
+// with the same id. An error is returned when the message could not be
+// written.
+func (c *Client) roundTrip(ctx context.Context, m message.OutgoingMessage) (message.IncomingMessage, error) {
+	return c.roundTripper(ctx, m)
+}
+
+// Transport sends a message to the server and waits for the reply, the
+// exchange is traced and measured.
+func (c *Client) transport(ctx context.Context, m message.OutgoingMessage) (message.IncomingMessage, error) {
+	start := time.Now()
+	span := c.tracers.Start(ctx, newSpanInfo(m))
+	r, err := c.exchange(ctx, m, span)
+	span.End(r, err)
+	switch {
+	case err == context.DeadlineExceeded:
+		c.metrics.Add(message.MetricErrors, "timeout", 1)
+	case err == nil && r.Error != nil:
+		c.metrics.Add(message.MetricErrors, "server", 1)
+	}
+	if index, ok := m.DeviceIndex(); ok && err == nil {
+		c.metrics.Observe(message.MetricCommandLatency,
+			strconv.FormatUint(uint64(index), 10), time.Since(start))
+	}
+	return r, err
+}
+
+// Exchange writes a message and reads the reply for transport.
+func (c *Client) exchange(ctx context.Context, m message.OutgoingMessage, span Span) (message.IncomingMessage, error) {
+	r, err := c.receiver.Subscribe(message.FilterID(m.ID()))
 	if err != nil {
 		return message.IncomingMessage{}, err
 	}
 	defer c.receiver.Unsubscribe(r)
+	written, err := c.sender.Queue(ctx, m)
+	if err != nil {
+		return message.IncomingMessage{}, err
+	}
+	span.Event(EventQueued)
 	for {
 		select {
+		case err := <-written:
+			if err != nil {
+				return message.IncomingMessage{}, err
+			}
+			span.Event(EventWritten)
+			written = nil
 		case msg, ok := <-r.Incoming():
 			if !ok {
-				return msg, errors.New("reader stopped")
+				return msg, fmt.Errorf("reader stopped: %v", r.Err())
 			}
-			if msgid, _ := msg.Message(); msgid == id {
-				return msg, nil
+			if written != nil {
+				// Reply arrived before the write result was seen.
+				select {
+				case err := <-written:
+					if err == nil {
+						span.Event(EventWritten)
+					}
+				default:
+				}
 			}
+			return msg, nil
 		case <-ctx.Done():
 			return message.IncomingMessage{}, ctx.Err()
 		}
@@ -277,12 +401,9 @@ func (c *Client) receiveMessage(ctx context.Context, id uint32) (message.Incomin
 // SendMessage is a generic send and read Ok/Error message with the default
 // timeout.
 func (c *Client) sendMessage(id uint32, m message.OutgoingMessage) error {
-	if err := c.sender.Send(m); err != nil {
-		return err
-	}
 	ctx, cancel := context.WithTimeout(c.ctx, defaultTimeout)
 	defer cancel()
-	r, err := c.receiveMessage(ctx, id)
+	r, err := c.roundTrip(ctx, m)
 	if err != nil {
 		return err
 	}
@@ -295,6 +416,22 @@ func (c *Client) sendMessage(id uint32, m message.OutgoingMessage) error {
 	return nil
 }
 
+// Send sends a message to the server with a newly generated id and waits for
+// the reply. The id of m is overwritten. An error is returned together with
+// the reply when the server responds with an Error message.
+func (c *Client) Send(ctx context.Context, m message.OutgoingMessage) (message.IncomingMessage, error) {
+	id := c.counter.Generate()
+	m.SetID(id)
+	r, err := c.roundTrip(ctx, m)
+	if err != nil {
+		return r, err
+	}
+	if r.Error != nil {
+		return r, fmt.Errorf("server error: %s", r.Error.ErrorMessage)
+	}
+	return r, nil
+}
+
 // StartScanning requests to have the server start scanning for devices on all
 // busses that it knows about. Useful for protocols like Bluetooth, which
 // require an explicit discovery phase.
@@ -325,7 +462,7 @@ func (c *Client) StopScanning() error {
 
 // WaitOnScanning waits until the server has stopped scanning on all busses.
 func (c *Client) WaitOnScanning(ctx context.Context) error {
-	r, err := c.receiver.Subscribe()
+	r, err := c.receiver.Subscribe(message.FilterTypes("ScanningFinished"))
 	if err != nil {
 		return err
 	}
@@ -345,7 +482,7 @@ func (c *Client) WaitOnScanning(ctx context.Context) error {
 	}
 }
 
-// Devices returns all devices currently known by the client.
+// Devices returns all devices currently known by the client, sorted by index.
 func (c *Client) Devices() []*Device {
 	c.m.RLock()
 	defer c.m.RUnlock()
@@ -353,6 +490,7 @@ func (c *Client) Devices() []*Device {
 	for _, v := range c.devices {
 		d = append(d, v)
 	}
+	sortDevices(d)
 	return d
 }
 
@@ -368,6 +506,37 @@ func (c *Client) StopAllDevices() error {
 	return c.sendMessage(id, m)
 }
 
+// ServerInfo returns the information the server send when the session was
+// created.
+func (c *Client) ServerInfo() message.ServerInfo {
+	return c.info
+}
+
+// RequestLog requests the server to send log messages up to the given level.
+// Use LogLevelOff to stop receiving logs. Log messages can be read using
+// Subscribe.
+func (c *Client) RequestLog(level string) error {
+	id := c.counter.Generate()
+	m := message.OutgoingMessage{
+		RequestLog: &message.RequestLog{
+			ID:       id,
+			LogLevel: level,
+		},
+	}
+	return c.sendMessage(id, m)
+}
+
+// Subscribe returns a reader that receives the messages send by the server,
+// all messages when there are no options. Call Unsubscribe when done reading.
+func (c *Client) Subscribe(opts ...message.SubscribeOption) (*message.Reader, error) {
+	return c.receiver.Subscribe(opts...)
+}
+
+// Unsubscribe stops the reader from receiving messages.
+func (c *Client) Unsubscribe(r *message.Reader) {
+	c.receiver.Unsubscribe(r)
+}
+
 // Disconnected returns a receiver channel that is closed when the client has
 // stopped.
 func (c *Client) Disconnected() <-chan struct{} {
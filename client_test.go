@@ -1,15 +1,21 @@
 package golibbuttplug
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/funjack/golibbuttplug/buttplugtest"
+	"github.com/funjack/golibbuttplug/lovense"
+	"github.com/funjack/golibbuttplug/message"
 )
 
 func makeWsProto(s string) string {
@@ -87,3 +93,604 @@ func HandleDisconnect(d *Device) {
 	<-d.Disconnected()
 	log.Printf("Lost device: %s", d.Name())
 }
+
+// newTestServer returns a TestServer with the default devices and the given
+// faults.
+func newTestServer(faults ...*buttplugtest.Fault) *buttplugtest.TestServer {
+	return &buttplugtest.TestServer{
+		InitialDevices: buttplugtest.DefaultTestServer.InitialDevices,
+		Faults:         faults,
+	}
+}
+
+// connect starts a http server for s and creates a client connected to it.
+func connect(t *testing.T, s http.Handler, opts ...Option) (*Client, func()) {
+	ts := httptest.NewServer(s)
+	c, err := NewClient(context.Background(), makeWsProto(ts.URL), "TestClient", nil, opts...)
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return c, func() {
+		c.Close()
+		ts.Close()
+	}
+}
+
+func TestServerErrorFault(t *testing.T) {
+	s := newTestServer(&buttplugtest.Fault{
+		Match: buttplugtest.MatchType("StopAllDevices"),
+		Error: "device busy",
+	})
+	c, done := connect(t, s)
+	defer done()
+
+	err := c.StopAllDevices()
+	if err == nil || !strings.Contains(err.Error(), "device busy") {
+		t.Errorf("expected server error, got: %v", err)
+	}
+}
+
+func TestDelayFault(t *testing.T) {
+	s := newTestServer(&buttplugtest.Fault{
+		Match: buttplugtest.MatchType("StopAllDevices"),
+		Delay: 50 * time.Millisecond,
+	})
+	c, done := connect(t, s)
+	defer done()
+
+	start := time.Now()
+	if err := c.StopAllDevices(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("response not delayed: %s", d)
+	}
+}
+
+func TestDisconnectFaults(t *testing.T) {
+	cases := map[string]*buttplugtest.Fault{
+		"Close": {
+			Match: buttplugtest.MatchType("StopAllDevices"),
+			Close: true,
+		},
+		"Malformed": {
+			Match:     buttplugtest.MatchType("StopAllDevices"),
+			Malformed: true,
+		},
+		"PingError": {
+			Match: buttplugtest.MatchType("Ping"),
+			After: 2,
+			Error: "ping rejected",
+		},
+	}
+	for name, f := range cases {
+		c, done := connect(t, newTestServer(f))
+		go c.StopAllDevices()
+		select {
+		case <-c.Disconnected():
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: client not disconnected", name)
+		}
+		done()
+	}
+}
+
+// noPings answers pings in the client, so they do not reach the server.
+func noPings(ctx context.Context, m message.OutgoingMessage, next RoundTripFunc) (message.IncomingMessage, error) {
+	if m.Ping != nil {
+		return OkReply(m), nil
+	}
+	return next(ctx, m)
+}
+
+func TestDropFault(t *testing.T) {
+	s := newTestServer(&buttplugtest.Fault{
+		Match: buttplugtest.MatchType("StopAllDevices"),
+		Count: 1,
+		Drop:  true,
+	})
+	c, done := connect(t, s)
+	defer done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	stop := message.OutgoingMessage{StopAllDevices: &message.Empty{}}
+	if _, err := c.Send(ctx, stop); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want deadline exceeded", err)
+	}
+	if err := c.StopAllDevices(); err != nil {
+		t.Errorf("second message: %v", err)
+	}
+}
+
+func TestReorderFault(t *testing.T) {
+	s := newTestServer(&buttplugtest.Fault{
+		Match:   buttplugtest.MatchType("StopAllDevices"),
+		Count:   1,
+		Reorder: true,
+	})
+	var m sync.Mutex
+	var replies []uint32
+	record := func(r *message.IncomingMessage) bool {
+		m.Lock()
+		defer m.Unlock()
+		replies = append(replies, r.ID())
+		return true
+	}
+	c, done := connect(t, s, Intercept(noPings), InterceptIncoming(record))
+	defer done()
+	conn := s.LastConn()
+
+	held := make(chan error)
+	go func() {
+		held <- c.StopAllDevices()
+	}()
+	first, err := conn.WaitFor(time.Second, buttplugtest.MatchType("StopAllDevices"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-held:
+		t.Fatalf("held response received: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// The next response flushes the held one.
+	if err := c.StopAllDevices(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-held; err != nil {
+		t.Errorf("held message: %v", err)
+	}
+	second, err := conn.WaitFor(time.Second, buttplugtest.MatchType("StopAllDevices"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Lock()
+	defer m.Unlock()
+	n := len(replies)
+	if n < 2 || replies[n-2] != second.Message.ID() || replies[n-1] != first.Message.ID() {
+		t.Errorf("got replies %v, want %d followed by %d", replies,
+			second.Message.ID(), first.Message.ID())
+	}
+}
+
+func TestJitterFault(t *testing.T) {
+	s := newTestServer(&buttplugtest.Fault{
+		Match:  buttplugtest.MatchType("StopAllDevices"),
+		Delay:  50 * time.Millisecond,
+		Jitter: 50 * time.Millisecond,
+	})
+	c, done := connect(t, s)
+	defer done()
+
+	const n = 5
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			begin := time.Now()
+			if err := c.StopAllDevices(); err != nil {
+				t.Error(err)
+			}
+			if d := time.Since(begin); d < 50*time.Millisecond {
+				t.Errorf("response not delayed: %s", d)
+			}
+		}()
+	}
+	wg.Wait()
+	// Delayed responses do not hold up each other.
+	if d := time.Since(start); d > n*50*time.Millisecond {
+		t.Errorf("responses took %s, delays are not concurrent", d)
+	}
+}
+
+func TestCloseAfter(t *testing.T) {
+	s := newTestServer(buttplugtest.CloseAfter(2))
+	// RequestServerInfo and RequestDeviceList are handled.
+	c, done := connect(t, s, Intercept(noPings))
+	defer done()
+
+	if err := c.StopAllDevices(); err == nil {
+		t.Errorf("message after close did not fail")
+	}
+	select {
+	case <-c.Disconnected():
+	case <-time.After(time.Second):
+		t.Fatal("client not disconnected")
+	}
+	if h := s.LastConn().History(); len(h) != 3 {
+		t.Errorf("server received %d messages, want 3", len(h))
+	}
+}
+
+// session runs a fixed set of operations against a server.
+func session(t *testing.T, c *Client, events func()) {
+	if err := c.StartScanning(); err != nil {
+		t.Fatal(err)
+	}
+	if events != nil {
+		go events()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.WaitOnScanning(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range c.Devices() {
+		if d.Name() == "Launch" {
+			if err := d.FleshlightLaunchFW12Cmd(50, 20); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := c.StopAllDevices(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	var buf bytes.Buffer
+	s := newTestServer()
+	ts := httptest.NewServer(s)
+	c, err := NewClient(context.Background(), makeWsProto(ts.URL), "TestClient", nil, Record(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session(t, c, func() {
+		time.Sleep(50 * time.Millisecond)
+		s.SendScanningFinished()
+	})
+	c.Close()
+	ts.Close()
+
+	records, err := message.ReadRecords(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 {
+		t.Fatal("nothing recorded")
+	}
+
+	rs := buttplugtest.NewReplayServer(records)
+	c, done := connect(t, rs)
+	session(t, c, nil)
+	done()
+	select {
+	case <-rs.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay not done")
+	}
+	if err := rs.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	records := []message.Record{
+		{Direction: message.DirOutgoing, Frame: []byte(`[{"RequestServerInfo":{"Id":1,"ClientName":"TestClient"}}]`)},
+		{Direction: message.DirIncoming, Frame: []byte(`[{"ServerInfo":{"Id":1,"ServerName":"Replay","MaxPingTime":0}}]`)},
+		{Direction: message.DirOutgoing, Frame: []byte(`[{"StartScanning":{"Id":2}}]`)},
+	}
+	rs := buttplugtest.NewReplayServer(records)
+	ts := httptest.NewServer(rs)
+	defer ts.Close()
+	_, err := NewClient(context.Background(), makeWsProto(ts.URL), "TestClient", nil)
+	if err == nil {
+		t.Error("expected error")
+	}
+	<-rs.Done()
+	if rs.Err() == nil {
+		t.Error("expected replay mismatch")
+	}
+}
+
+func TestExpectCommands(t *testing.T) {
+	s := newTestServer()
+	c, done := connect(t, s)
+	defer done()
+
+	var launch *Device
+	for _, d := range c.Devices() {
+		if d.Name() == "Launch" {
+			launch = d
+		}
+	}
+	if launch == nil {
+		t.Fatal("launch not found")
+	}
+	for _, pos := range []int{10, 90, 10} {
+		if err := launch.FleshlightLaunchFW12Cmd(pos, 50); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn := s.ConnByName("TestClient")
+	for _, want := range []message.OutgoingMessage{
+		{RequestServerInfo: &message.RequestServerInfo{ClientName: "TestClient"}},
+		{RequestDeviceList: &message.Empty{}},
+	} {
+		if err := conn.ExpectNext(time.Second, want); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, pos := range []int{10, 90, 10} {
+		err := conn.ExpectNext(time.Second, message.OutgoingMessage{
+			FleshlightLaunchFW12Cmd: &message.FleshlightLaunchFW12Cmd{
+				DeviceIndex: 2,
+				Position:    pos,
+				Speed:       50,
+			},
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := conn.Next(10 * time.Millisecond); err != buttplugtest.ErrTimeout {
+		t.Errorf("expected timeout, got: %v", err)
+	}
+
+	go c.StopAllDevices()
+	r, err := conn.WaitFor(time.Second, buttplugtest.MatchType("StopAllDevices"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := conn.History()
+	if h[len(h)-1].Time.Before(r.Time) {
+		t.Errorf("history not in order")
+	}
+}
+
+func TestMultipleConnections(t *testing.T) {
+	s := newTestServer()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	names := []string{"Player", "ChatBot"}
+	clients := make([]*Client, len(names))
+	for i, name := range names {
+		c, err := NewClient(context.Background(), makeWsProto(ts.URL), name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		clients[i] = c
+	}
+	if _, err := s.WaitConn(len(names), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range names {
+		conn := s.ConnByName(name)
+		if conn == nil {
+			t.Fatalf("no connection for %s", name)
+		}
+		if conn != s.Conns()[i] {
+			t.Errorf("connection %s not in order", name)
+		}
+	}
+
+	s.AddDevice(buttplugtest.DefaultAddDeviceMessage)
+	for i, c := range clients {
+		if err := waitDevices(c, 4); err != nil {
+			t.Errorf("%s: %v", names[i], err)
+		}
+	}
+
+	// Scanning finished is send to all clients.
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		wg.Add(1)
+		go func(name string, c *Client) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := c.Scan(ctx, 0, func(ScanEvent) bool { return true }); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		}(names[i], c)
+	}
+	for _, name := range names {
+		if _, err := s.ConnByName(name).WaitFor(time.Second, buttplugtest.MatchType("StartScanning")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.SendScanningFinished()
+	wg.Wait()
+
+	clients[0].Close()
+	select {
+	case <-s.ConnByName(names[0]).Closed():
+	case <-time.After(time.Second):
+		t.Errorf("connection not closed")
+	}
+	s.RemoveDevice(buttplugtest.DefaultRemoveDeviceMessage)
+	if err := waitDevices(clients[1], 3); err != nil {
+		t.Error(err)
+	}
+}
+
+// waitDevices waits until the client knows n devices.
+func waitDevices(c *Client, n int) error {
+	for i := 0; i < 100; i++ {
+		if len(c.Devices()) == n {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("expected %d devices, got %d", n, len(c.Devices()))
+}
+
+func TestStrictServer(t *testing.T) {
+	s := newTestServer()
+	s.Strict = true
+	c, done := connect(t, s)
+	defer done()
+
+	for _, d := range c.Devices() {
+		for _, cmd := range d.Supported() {
+			var err error
+			switch cmd {
+			case CommandStopDevice:
+				err = d.StopDeviceCmd()
+			case CommandSingleMotorVibrate:
+				err = d.SingleMotorVibrateCmd(1)
+			case CommandKiiroo:
+				err = d.KiirooCmd(4)
+			case CommandFleshlightLaunchFW12:
+				err = d.FleshlightLaunchFW12Cmd(99, 99)
+			case CommandLovense:
+				err = d.LovenseCmd("Vibrate:20;")
+			case CommandRaw:
+				err = d.RawCmd([]byte{0x01})
+			}
+			if err != nil {
+				t.Errorf("%s %s: %v", d, cmd, err)
+			}
+		}
+	}
+	select {
+	case <-c.Disconnected():
+		t.Errorf("client disconnected by strict server")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestStrictDecoding(t *testing.T) {
+	s := newTestServer()
+	c, done := connect(t, s, StrictDecoding())
+	defer done()
+
+	r, err := c.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Unsubscribe(r)
+	s.LastConn().SendLog("Verbose", "invalid level")
+	s.LastConn().SendLog(message.LogLevelInfo, "valid")
+	for {
+		select {
+		case m := <-r.Incoming():
+			if m.Log == nil {
+				continue
+			}
+			if m.Log.LogMessage != "valid" {
+				t.Errorf("invalid message received: %+v", m.Log)
+			}
+			return
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+}
+
+// countMetrics counts measurements by name and label.
+type countMetrics struct {
+	m      sync.Mutex
+	counts map[string]int64
+}
+
+func (c *countMetrics) Add(name, label string, delta int64) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.counts[name+"/"+label] += delta
+}
+
+func (c *countMetrics) Set(name string, value int64) {}
+
+func (c *countMetrics) Observe(name, label string, d time.Duration) {
+	c.Add(name, label, 1)
+}
+
+func (c *countMetrics) get(name, label string) int64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.counts[name+"/"+label]
+}
+
+func TestReportMetrics(t *testing.T) {
+	s := newTestServer(&buttplugtest.Fault{
+		Match: buttplugtest.MatchType("SingleMotorVibrateCmd"),
+		Error: "device busy",
+	})
+	m := &countMetrics{counts: make(map[string]int64)}
+	c, done := connect(t, s, ReportMetrics(m))
+	defer done()
+
+	var launch, vibe *Device
+	for _, d := range c.Devices() {
+		switch d.Name() {
+		case "Launch":
+			launch = d
+		case "TestDevice 1":
+			vibe = d
+		}
+	}
+	if launch == nil || vibe == nil {
+		t.Fatal("devices not found")
+	}
+	if err := launch.FleshlightLaunchFW12Cmd(10, 50); err != nil {
+		t.Fatal(err)
+	}
+	if err := vibe.SingleMotorVibrateCmd(0.5); err == nil {
+		t.Fatal("expected server error")
+	}
+	for _, tc := range []struct {
+		Name, Label string
+		Want        int64
+	}{
+		{message.MetricConnects, "TestButtplug", 1},
+		{message.MetricSent, "RequestServerInfo", 1},
+		{message.MetricSent, "FleshlightLaunchFW12Cmd", 1},
+		{message.MetricReceived, "ServerInfo", 1},
+		{message.MetricReceived, "DeviceList", 1},
+		{message.MetricCommandLatency, fmt.Sprint(launch.Index()), 1},
+		{message.MetricCommandLatency, fmt.Sprint(vibe.Index()), 1},
+		{message.MetricErrors, "server", 1},
+	} {
+		if got := m.get(tc.Name, tc.Label); got != tc.Want {
+			t.Errorf("%s %s: got %d, want %d", tc.Name, tc.Label, got, tc.Want)
+		}
+	}
+}
+
+func TestLovenseCmd(t *testing.T) {
+	s := newTestServer()
+	s.InitialDevices = append(s.InitialDevices, message.Device{
+		DeviceName:     "Lovense Max",
+		DeviceIndex:    4,
+		DeviceMessages: []string{CommandLovense, CommandStopDevice},
+	})
+	c, done := connect(t, s)
+	defer done()
+	dev, _ := c.Device(4)
+
+	for _, tc := range []struct {
+		Cmd string
+		Err error
+	}{
+		{lovense.AirAuto(2).String(), nil},
+		{"Vibrate:20;", nil},
+		{"Vibrate:20", ErrInvalidCmd},
+		{"Vibrate:21;", lovense.ErrRange},
+		{"Rotate:5;", lovense.ErrUnsupported},
+		{"Preset:1;", nil},
+		{"RotateAntiClockwise:10;", nil},
+	} {
+		if err := dev.LovenseCmd(tc.Cmd); err != tc.Err {
+			t.Errorf("%s: got %v, want %v", tc.Cmd, err, tc.Err)
+		}
+	}
+	// Unknown commands are send to the server.
+	var sent []string
+	for _, r := range s.LastConn().History() {
+		if r.Message.LovenseCmd != nil {
+			sent = append(sent, r.Message.LovenseCmd.Command)
+		}
+	}
+	want := []string{"AirAuto:2;", "Vibrate:20;", "Preset:1;", "RotateAntiClockwise:10;"}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("got commands %q, want %q", sent, want)
+	}
+}
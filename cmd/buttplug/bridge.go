@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/funjack/golibbuttplug/bridge"
+)
+
+func bridgeCmd(ctx context.Context, c *cli, args []string) error {
+	fs := flag.NewFlagSet("bridge", flag.ContinueOnError)
+	listen := fs.String("listen", "127.0.0.1:8080", "address of the HTTP API")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	return c.serve(ctx, *listen, "http", bridge.New(c.client))
+}
+
+// serve serves h on the listen address until the context is done or the
+// client, if any, disconnects.
+func (c *cli) serve(ctx context.Context, listen, scheme string, h http.Handler) error {
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: h}
+	fmt.Fprintf(c.errw, "listening on %s://%s\n", scheme, l.Addr())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(l)
+	}()
+	var disconnected <-chan struct{}
+	if c.client != nil {
+		disconnected = c.client.Disconnected()
+	}
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	case <-disconnected:
+		err = errors.New("disconnected")
+	}
+	srv.Close()
+	return err
+}
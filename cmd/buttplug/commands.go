@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/funjack/golibbuttplug"
+	"github.com/funjack/golibbuttplug/message"
+)
+
+// command executes a subcommand with the remaining arguments.
+type command func(ctx context.Context, c *cli, args []string) error
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"info":    infoCmd,
+		"devices": devicesCmd,
+		"scan":    scanCmd,
+		"vibrate": vibrateCmd,
+		"launch":  launchCmd,
+		"kiiroo":  kiirooCmd,
+		"lovense": lovenseCmd,
+		"vorze":   vorzeCmd,
+		"raw":     rawCmd,
+		"stop":    stopCmd,
+		"stopall": stopAllCmd,
+		"events":  eventsCmd,
+		"shell":   shellCmd,
+		"bridge":  bridgeCmd,
+		"proxy":   proxyCmd,
+		"inspect": inspectCmd,
+	}
+}
+
+// offline are commands that do not use a client connection.
+var offline = map[string]bool{
+	"inspect": true,
+}
+
+// deviceInfo is the output format of a device.
+type deviceInfo struct {
+	Index    uint32
+	Name     string
+	Messages []string
+}
+
+func newDeviceInfo(d *golibbuttplug.Device) deviceInfo {
+	return deviceInfo{
+		Index:    d.Index(),
+		Name:     d.Name(),
+		Messages: d.Supported(),
+	}
+}
+
+func (d deviceInfo) String() string {
+	return fmt.Sprintf("%d\t%s\t%s", d.Index, d.Name, strings.Join(d.Messages, ","))
+}
+
+// result is the output format of a command that has no other output.
+type result struct {
+	Command string
+	Device  *uint32 `json:",omitempty"`
+	Ok      bool
+}
+
+// print writes v as JSON or text, depending on the output mode.
+func (c *cli) print(v fmt.Stringer) error {
+	if c.json {
+		return json.NewEncoder(c.out).Encode(v)
+	}
+	_, err := fmt.Fprintln(c.out, v.String())
+	return err
+}
+
+func (r result) String() string {
+	if r.Device != nil {
+		return fmt.Sprintf("%s %d: ok", r.Command, *r.Device)
+	}
+	return fmt.Sprintf("%s: ok", r.Command)
+}
+
+// ok prints a successful command result.
+func (c *cli) ok(cmd string, d *golibbuttplug.Device) error {
+	r := result{Command: cmd, Ok: true}
+	if d != nil {
+		i := d.Index()
+		r.Device = &i
+	}
+	return c.print(r)
+}
+
+// device returns the device with the given index or name.
+func (c *cli) device(arg string) (*golibbuttplug.Device, error) {
+	index, err := strconv.ParseUint(arg, 10, 32)
+	for _, d := range c.client.Devices() {
+		if err == nil && d.Index() == uint32(index) {
+			return d, nil
+		} else if err != nil && strings.EqualFold(d.Name(), arg) {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("device not found: %s", arg)
+}
+
+// args checks the number of arguments and returns the device of the first
+// argument.
+func (c *cli) deviceArgs(args []string, min, max int, usage string) (*golibbuttplug.Device, error) {
+	if len(args) < min || len(args) > max {
+		fmt.Fprintf(c.errw, "usage: %s\n", usage)
+		return nil, errUsage
+	}
+	return c.device(args[0])
+}
+
+type serverInfo message.ServerInfo
+
+func (s serverInfo) String() string {
+	return fmt.Sprintf("%s (%d.%d.%d) message version %d, max ping time %dms",
+		s.ServerName, s.MajorVersion, s.MinorVersion, s.BuildVersion,
+		s.MessageVersion, s.MaxPingTime)
+}
+
+func infoCmd(ctx context.Context, c *cli, args []string) error {
+	return c.print(serverInfo(c.client.ServerInfo()))
+}
+
+func devicesCmd(ctx context.Context, c *cli, args []string) error {
+	for _, d := range c.client.Devices() {
+		if err := c.print(newDeviceInfo(d)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanCmd(ctx context.Context, c *cli, args []string) error {
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	timeout := fs.Duration("timeout", 10*time.Second, "maximum time to scan")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	// Devices are listed when scanning is done.
+	err := c.client.Scan(ctx, *timeout, func(golibbuttplug.ScanEvent) bool {
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return devicesCmd(ctx, c, nil)
+}
+
+func vibrateCmd(ctx context.Context, c *cli, args []string) error {
+	d, err := c.deviceArgs(args, 2, 2, "vibrate <device> <speed>")
+	if err != nil {
+		return err
+	}
+	spd, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return err
+	}
+	if err := d.SingleMotorVibrateCmd(spd); err != nil {
+		return err
+	}
+	return c.ok(golibbuttplug.CommandSingleMotorVibrate, d)
+}
+
+func launchCmd(ctx context.Context, c *cli, args []string) error {
+	d, err := c.deviceArgs(args, 3, 3, "launch <device> <position> <speed>")
+	if err != nil {
+		return err
+	}
+	pos, err := strconv.Atoi(args[1])
+	if err != nil {
+		return err
+	}
+	spd, err := strconv.Atoi(args[2])
+	if err != nil {
+		return err
+	}
+	if err := d.FleshlightLaunchFW12Cmd(pos, spd); err != nil {
+		return err
+	}
+	return c.ok(golibbuttplug.CommandFleshlightLaunchFW12, d)
+}
+
+func kiirooCmd(ctx context.Context, c *cli, args []string) error {
+	d, err := c.deviceArgs(args, 2, 2, "kiiroo <device> <command>")
+	if err != nil {
+		return err
+	}
+	cmd, err := strconv.Atoi(args[1])
+	if err != nil {
+		return err
+	}
+	if err := d.KiirooCmd(cmd); err != nil {
+		return err
+	}
+	return c.ok(golibbuttplug.CommandKiiroo, d)
+}
+
+func lovenseCmd(ctx context.Context, c *cli, args []string) error {
+	d, err := c.deviceArgs(args, 2, 2, "lovense <device> <command>")
+	if err != nil {
+		return err
+	}
+	if err := d.LovenseCmd(args[1]); err != nil {
+		return err
+	}
+	return c.ok(golibbuttplug.CommandLovense, d)
+}
+
+func vorzeCmd(ctx context.Context, c *cli, args []string) error {
+	d, err := c.deviceArgs(args, 2, 3, "vorze <device> <speed> [cw|ccw]")
+	if err != nil {
+		return err
+	}
+	spd, err := strconv.Atoi(args[1])
+	if err != nil {
+		return err
+	}
+	clockwise := true
+	if len(args) > 2 {
+		switch args[2] {
+		case "cw":
+		case "ccw":
+			clockwise = false
+		default:
+			return fmt.Errorf("invalid direction: %s", args[2])
+		}
+	}
+	if err := d.VorzeA10CycloneCmd(spd, clockwise); err != nil {
+		return err
+	}
+	return c.ok(golibbuttplug.CommandVorzeA10Cyclone, d)
+}
+
+func rawCmd(ctx context.Context, c *cli, args []string) error {
+	d, err := c.deviceArgs(args, 2, 2, "raw <device> <hex>")
+	if err != nil {
+		return err
+	}
+	b, err := hex.DecodeString(args[1])
+	if err != nil {
+		return err
+	}
+	if err := d.RawCmd(b); err != nil {
+		return err
+	}
+	return c.ok(golibbuttplug.CommandRaw, d)
+}
+
+func stopCmd(ctx context.Context, c *cli, args []string) error {
+	d, err := c.deviceArgs(args, 1, 1, "stop <device>")
+	if err != nil {
+		return err
+	}
+	if err := d.StopDeviceCmd(); err != nil {
+		return err
+	}
+	return c.ok(golibbuttplug.CommandStopDevice, d)
+}
+
+func stopAllCmd(ctx context.Context, c *cli, args []string) error {
+	if err := c.client.StopAllDevices(); err != nil {
+		return err
+	}
+	return c.ok("StopAllDevices", nil)
+}
+
+// eventBufferSize is the amount of server events buffered while they are
+// printed.
+const eventBufferSize = 100
+
+// subscribeEvents subscribes to the server events. When the output can not
+// keep up the oldest buffered events are dropped.
+func (c *cli) subscribeEvents() (*message.Reader, error) {
+	// Replies to client messages are not events.
+	return c.client.Subscribe(
+		message.FilterID(0),
+		message.BufferSize(eventBufferSize),
+		message.OnOverflow(message.OverflowDropOldest),
+	)
+}
+
+// event is the output format of a server event.
+type event message.IncomingMessage
+
+func (e event) String() string {
+	m := message.IncomingMessage(e)
+	switch {
+	case m.DeviceAdded != nil:
+		return fmt.Sprintf("DeviceAdded\t%s", deviceInfo{
+			Index:    m.DeviceAdded.DeviceIndex,
+			Name:     m.DeviceAdded.DeviceName,
+			Messages: m.DeviceAdded.DeviceMessages,
+		})
+	case m.DeviceRemoved != nil:
+		return fmt.Sprintf("DeviceRemoved\t%d", m.DeviceRemoved.DeviceIndex)
+	case m.ScanningFinished != nil:
+		return "ScanningFinished"
+	case m.Log != nil:
+		return fmt.Sprintf("Log\t%s\t%s", m.Log.LogLevel, m.Log.LogMessage)
+	case m.Error != nil:
+		return fmt.Sprintf("Error\t%s", m.Error.ErrorMessage)
+	}
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+func eventsCmd(ctx context.Context, c *cli, args []string) error {
+	fs := flag.NewFlagSet("events", flag.ContinueOnError)
+	level := fs.String("log", "", "request server logs up to this level (eg: Info, Debug)")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	r, err := c.subscribeEvents()
+	if err != nil {
+		return err
+	}
+	defer c.client.Unsubscribe(r)
+	if *level != "" {
+		if err := c.client.RequestLog(*level); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case m, ok := <-r.Incoming():
+			if !ok {
+				if err := r.Err(); err == message.ErrOverflow {
+					return err
+				}
+				return nil
+			}
+			if err := c.print(event(m)); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		case <-c.client.Disconnected():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/funjack/golibbuttplug/message"
+	"github.com/gorilla/websocket"
+)
+
+// ANSI colors used to highlight messages.
+const (
+	colorRed    = "\x1b[31m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+func inspectCmd(ctx context.Context, c *cli, args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	listen := fs.String("listen", "127.0.0.1:12346", "address clients connect to")
+	output := fs.String("o", "", "export the session to a file, see message.Recorder")
+	pings := fs.Bool("pings", false, "show Ping messages")
+	color := fs.Bool("color", isTerminalWriter(c.out), "highlight errors")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	in := newInspector(c, c.addr)
+	in.pings = *pings
+	in.color = *color
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in.record = f
+	}
+	return c.serve(ctx, *listen, "ws", in)
+}
+
+// isTerminalWriter returns true if w is a terminal.
+func isTerminalWriter(w interface{}) bool {
+	f, ok := w.(*os.File)
+	return ok && isTerminal(f.Fd())
+}
+
+// inspector is a man-in-the-middle that forwards websocket frames between
+// clients and a server and prints the messages.
+type inspector struct {
+	c      *cli
+	addr   string // Server address.
+	dialer *websocket.Dialer
+	pings  bool      // Show Ping messages and their replies.
+	color  bool      // Highlight errors.
+	record io.Writer // Export frames when not nil.
+
+	m     sync.Mutex // Protects conns and printing.
+	conns int        // Number of connections accepted.
+}
+
+func newInspector(c *cli, addr string) *inspector {
+	d := &websocket.Dialer{}
+	if c.insecure {
+		d.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &inspector{
+		c:      c,
+		addr:   addr,
+		dialer: d,
+	}
+}
+
+// ServeHTTP connects a client to the server and inspects the traffic until
+// either side closes the connection.
+func (in *inspector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	down, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer down.Close()
+	in.m.Lock()
+	in.conns++
+	s := &inspectSession{
+		inspector: in,
+		conn:      in.conns,
+		pending:   make(map[uint32]pending),
+	}
+	in.m.Unlock()
+	up, _, err := in.dialer.Dial(in.addr, nil)
+	if err != nil {
+		s.note(colorRed, fmt.Sprintf("error connecting to %s: %v", in.addr, err))
+		return
+	}
+	defer up.Close()
+	var server message.Conn = up
+	if in.record != nil {
+		server = message.NewRecorder(up, in.record)
+	}
+	s.note("", "connected")
+	errc := make(chan error, 2)
+	go func() {
+		errc <- s.pump(down, server, message.DirOutgoing)
+	}()
+	go func() {
+		errc <- s.pump(server, down, message.DirIncoming)
+	}()
+	err = <-errc
+	down.Close()
+	up.Close()
+	<-errc
+	s.note("", fmt.Sprintf("disconnected: %v", err))
+}
+
+// pending is a message send to the server that is waiting on a reply.
+type pending struct {
+	typ  string
+	time time.Time
+}
+
+// inspectSession is a single inspected connection.
+type inspectSession struct {
+	*inspector
+	conn int // Connection number.
+
+	pm      sync.Mutex // Protects pending.
+	pending map[uint32]pending
+}
+
+// pump forwards frames from one connection to the other.
+func (s *inspectSession) pump(from, to message.Conn, dir message.Direction) error {
+	for {
+		t, p, err := from.ReadMessage()
+		if err != nil {
+			return err
+		}
+		s.inspect(dir, p)
+		if err := to.WriteMessage(t, p); err != nil {
+			return err
+		}
+	}
+}
+
+// inspect decodes and prints the messages in a frame.
+func (s *inspectSession) inspect(dir message.Direction, p []byte) {
+	now := time.Now()
+	var msgs []message.Message
+	if dir == message.DirOutgoing {
+		var out message.OutgoingMessages
+		if err := json.Unmarshal(p, &out); err != nil {
+			s.malformed(now, dir, p)
+			return
+		}
+		for i := range out {
+			msgs = append(msgs, &out[i])
+		}
+	} else {
+		var in message.IncomingMessages
+		if err := json.Unmarshal(p, &in); err != nil {
+			s.malformed(now, dir, p)
+			return
+		}
+		for i := range in {
+			msgs = append(msgs, &in[i])
+		}
+	}
+	for _, m := range msgs {
+		e := inspectEntry{
+			Time:      now,
+			Conn:      s.conn,
+			Direction: dir,
+			Type:      m.Type(),
+		}
+		var v interface{}
+		e.ID, v = m.Message()
+		switch m := m.(type) {
+		case *message.OutgoingMessage:
+			if e.ID != 0 {
+				s.pm.Lock()
+				s.pending[e.ID] = pending{typ: e.Type, time: now}
+				s.pm.Unlock()
+			}
+			if m.Ping != nil && !s.pings {
+				continue
+			}
+		case *message.IncomingMessage:
+			s.pm.Lock()
+			req, ok := s.pending[e.ID]
+			delete(s.pending, e.ID)
+			s.pm.Unlock()
+			if ok {
+				latency := now.Sub(req.time)
+				e.Latency = &latency
+				if req.typ == "Ping" && !s.pings {
+					continue
+				}
+			}
+			e.Error = m.Error != nil
+			e.Unknown = m.Unknown != nil
+		}
+		switch v := v.(type) {
+		case nil:
+			e.Type, e.Unknown = "Unknown", true
+		case message.Unknown:
+			e.Message = v.Data
+		default:
+			e.Message, _ = json.Marshal(v)
+		}
+		s.print(e)
+	}
+}
+
+// malformed prints a frame that could not be decoded.
+func (s *inspectSession) malformed(t time.Time, dir message.Direction, p []byte) {
+	raw, _ := json.Marshal(string(p))
+	s.print(inspectEntry{
+		Time:      t,
+		Conn:      s.conn,
+		Direction: dir,
+		Type:      "Malformed",
+		Message:   raw,
+		Error:     true,
+	})
+}
+
+// note prints a connection event.
+func (s *inspectSession) note(color, msg string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.c.json {
+		return
+	}
+	line := fmt.Sprintf("%s [%d] %s", time.Now().Format(timeFormat), s.conn, msg)
+	if s.color && color != "" {
+		line = color + line + colorReset
+	}
+	fmt.Fprintln(s.c.out, line)
+}
+
+func (s *inspectSession) print(e inspectEntry) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.c.json {
+		s.c.print(e)
+		return
+	}
+	line := e.String()
+	if s.color {
+		switch {
+		case e.Type == "Malformed" || e.Unknown:
+			line = colorYellow + line + colorReset
+		case e.Error:
+			line = colorRed + line + colorReset
+		}
+	}
+	fmt.Fprintln(s.c.out, line)
+}
+
+// timeFormat is the time format of printed messages.
+const timeFormat = "15:04:05.000"
+
+// inspectEntry is the output format of an inspected message.
+type inspectEntry struct {
+	Time      time.Time
+	Conn      int
+	Direction message.Direction
+	Type      string
+	ID        uint32          `json:"Id"`
+	Message   json.RawMessage `json:",omitempty"`
+	Latency   *time.Duration  `json:",omitempty"`
+	Error     bool            `json:",omitempty"`
+	Unknown   bool            `json:",omitempty"`
+}
+
+func (e inspectEntry) String() string {
+	arrow := "->"
+	if e.Direction == message.DirIncoming {
+		arrow = "<-"
+	}
+	s := fmt.Sprintf("%s [%d] %s %s %s", e.Time.Format(timeFormat), e.Conn,
+		arrow, e.Type, e.Message)
+	if e.Latency != nil {
+		s += fmt.Sprintf(" (%s)", e.Latency.Round(10*time.Microsecond))
+	}
+	return s
+}
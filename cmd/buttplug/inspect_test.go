@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/funjack/golibbuttplug"
+	"github.com/funjack/golibbuttplug/message"
+)
+
+func TestInspect(t *testing.T) {
+	_, addr, done := newServer()
+	defer done()
+
+	var out, rec bytes.Buffer
+	in := newInspector(&cli{out: &out}, addr)
+	in.record = &rec
+	ts := httptest.NewServer(in)
+	defer ts.Close()
+
+	c, err := golibbuttplug.NewClient(context.Background(),
+		"ws"+strings.TrimPrefix(ts.URL, "http"), "InspectTest", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StopAllDevices(); err != nil {
+		t.Fatal(err)
+	}
+	c.Send(context.Background(), message.OutgoingMessage{
+		Test: &message.Test{TestString: "Error"},
+	})
+	c.Close()
+
+	var got string
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		in.m.Lock()
+		got = out.String()
+		in.m.Unlock()
+		if strings.Contains(got, "disconnected") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, want := range []string{
+		"[1] connected\n",
+		`[1] -> RequestServerInfo {"Id":1,"ClientName":"InspectTest"`,
+		`[1] <- ServerInfo {"Id":1,`,
+		`[1] -> StopAllDevices {"Id":`,
+		`[1] -> Test {"Id":`,
+		`[1] <- Error {"Id":`,
+		"[1] disconnected",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+	if !strings.Contains(got, "<- Ok") || !strings.Contains(got, "s)\n") {
+		t.Errorf("reply without latency:\n%s", got)
+	}
+	if strings.Contains(got, "-> Ping") {
+		t.Errorf("pings are shown:\n%s", got)
+	}
+	recs, err := message.ReadRecords(&rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) < 6 || recs[0].Direction != message.DirOutgoing {
+		t.Errorf("unexpected records: %v", recs)
+	}
+}
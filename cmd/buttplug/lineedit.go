@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Key codes handled by the line editor.
+const (
+	keyCtrlA     = 1
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyBackspace = 8
+	keyTab       = 9
+	keyLF        = 10
+	keyCR        = 13
+	keyCtrlU     = 21
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+// lineEditor reads lines from a terminal with history and tab completion.
+// When the input is not a terminal lines are read as is. Output written to
+// the editor is printed above the line being edited.
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	prompt   string
+	raw      bool                          // Input is a terminal in raw mode.
+	complete func(words []string) []string // Completes the last word.
+
+	m       sync.Mutex // Protects everything below and writes to out.
+	editing bool       // Prompt is displayed.
+	line    []rune
+	pos     int
+	history []string
+	hpos    int // Position in history while browsing.
+}
+
+func newLineEditor(in io.Reader, out io.Writer, prompt string, raw bool) *lineEditor {
+	return &lineEditor{
+		in:     bufio.NewReader(in),
+		out:    out,
+		prompt: prompt,
+		raw:    raw,
+	}
+}
+
+// Write prints p above the line that is being edited.
+func (e *lineEditor) Write(p []byte) (int, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if !e.editing || !e.raw {
+		return e.out.Write(p)
+	}
+	fmt.Fprint(e.out, "\r\x1b[K")
+	n, err := e.out.Write(p)
+	e.redraw()
+	return n, err
+}
+
+// AddHistory adds a line to the history.
+func (e *lineEditor) addHistory(line string) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if line == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+}
+
+// ReadLine reads the next line. io.EOF is returned when input is closed or
+// Ctrl-D is pressed on an empty line.
+func (e *lineEditor) ReadLine() (string, error) {
+	if !e.raw {
+		fmt.Fprint(e, e.prompt)
+		line, err := e.in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	e.m.Lock()
+	e.editing = true
+	e.line, e.pos, e.hpos = nil, 0, len(e.history)
+	e.redraw()
+	e.m.Unlock()
+	defer func() {
+		e.m.Lock()
+		e.editing = false
+		fmt.Fprint(e.out, "\r\n")
+		e.m.Unlock()
+	}()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		if done, err := e.key(r); done {
+			e.m.Lock()
+			line := string(e.line)
+			e.m.Unlock()
+			return line, err
+		}
+	}
+}
+
+// Key handles a single key press and returns true when the line is done.
+func (e *lineEditor) key(r rune) (bool, error) {
+	if r == keyTab {
+		e.completeLine()
+		return false, nil
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+	switch r {
+	case keyCR, keyLF:
+		return true, nil
+	case keyCtrlD:
+		if len(e.line) == 0 {
+			return true, io.EOF
+		}
+	case keyCtrlC, keyCtrlU:
+		e.line, e.pos = nil, 0
+	case keyCtrlA:
+		e.pos = 0
+	case keyCtrlE:
+		e.pos = len(e.line)
+	case keyBackspace, keyDelete:
+		if e.pos > 0 {
+			e.line = append(e.line[:e.pos-1], e.line[e.pos:]...)
+			e.pos--
+		}
+	case keyEscape:
+		e.escape()
+	default:
+		if unicode.IsPrint(r) {
+			e.line = append(e.line, 0)
+			copy(e.line[e.pos+1:], e.line[e.pos:])
+			e.line[e.pos] = r
+			e.pos++
+		}
+	}
+	e.redraw()
+	return false, nil
+}
+
+// Escape handles arrow key escape sequences. Caller must hold the lock.
+func (e *lineEditor) escape() {
+	if b, err := e.in.ReadByte(); err != nil || b != '[' {
+		return
+	}
+	b, err := e.in.ReadByte()
+	if err != nil {
+		return
+	}
+	switch b {
+	case 'A': // Up
+		if e.hpos > 0 {
+			e.hpos--
+			e.line = []rune(e.history[e.hpos])
+			e.pos = len(e.line)
+		}
+	case 'B': // Down
+		if e.hpos < len(e.history) {
+			e.hpos++
+			e.line = nil
+			if e.hpos < len(e.history) {
+				e.line = []rune(e.history[e.hpos])
+			}
+			e.pos = len(e.line)
+		}
+	case 'C': // Right
+		if e.pos < len(e.line) {
+			e.pos++
+		}
+	case 'D': // Left
+		if e.pos > 0 {
+			e.pos--
+		}
+	}
+}
+
+// CompleteLine completes the word before the cursor. When there are
+// multiple candidates the common prefix is inserted, or the candidates are
+// printed when there is no common prefix to add.
+func (e *lineEditor) completeLine() {
+	if e.complete == nil {
+		return
+	}
+	e.m.Lock()
+	before := e.line[:e.pos]
+	start := lastWordStart(before)
+	words := splitWords(string(before))
+	e.m.Unlock()
+	if start == len(before) {
+		words = append(words, "")
+	}
+	last := words[len(words)-1]
+	var candidates []string
+	for _, c := range e.complete(words) {
+		if strings.HasPrefix(c, last) {
+			candidates = append(candidates, quote(c))
+		}
+	}
+	var insert string
+	switch len(candidates) {
+	case 0:
+		return
+	case 1:
+		insert = candidates[0] + " "
+	default:
+		insert = commonPrefix(candidates)
+		if len([]rune(insert)) <= len(before)-start {
+			fmt.Fprintln(e, strings.Join(candidates, "  "))
+			return
+		}
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+	rest := append([]rune(insert), e.line[e.pos:]...)
+	e.line = append(e.line[:start], rest...)
+	e.pos = start + len([]rune(insert))
+	e.redraw()
+}
+
+// lastWordStart returns the index where the last word of the line starts, or
+// the length of the line when it ends with a space.
+func lastWordStart(line []rune) int {
+	var (
+		start int
+		inw   bool
+		q     rune
+	)
+	for i, r := range line {
+		switch {
+		case q != 0:
+			if r == q {
+				q = 0
+			}
+		case unicode.IsSpace(r):
+			inw = false
+		default:
+			if !inw {
+				start, inw = i, true
+			}
+			if r == '"' || r == '\'' {
+				q = r
+			}
+		}
+	}
+	if !inw {
+		return len(line)
+	}
+	return start
+}
+
+// Redraw the prompt and line. Caller must hold the lock.
+func (e *lineEditor) redraw() {
+	fmt.Fprintf(e.out, "\r\x1b[K%s%s", e.prompt, string(e.line))
+	if n := len(e.line) - e.pos; n > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", n)
+	}
+}
+
+// commonPrefix returns the longest common prefix of all strings.
+func commonPrefix(s []string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	prefix := s[0]
+	for _, v := range s[1:] {
+		for !strings.HasPrefix(v, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// quote returns s quoted if it contains spaces.
+func quote(s string) string {
+	if strings.ContainsAny(s, " \t") {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// splitWords splits a line into words. Words can be quoted with single or
+// double quotes to include spaces. An unterminated quote ends at the end of
+// the line.
+func splitWords(line string) []string {
+	var (
+		words []string
+		word  []rune
+		inw   bool
+		q     rune
+	)
+	for _, r := range line {
+		switch {
+		case q != 0 && r == q:
+			q = 0
+		case q != 0:
+			word = append(word, r)
+		case r == '"' || r == '\'':
+			q, inw = r, true
+		case unicode.IsSpace(r):
+			if inw {
+				words = append(words, string(word))
+				word, inw = nil, false
+			}
+		default:
+			word, inw = append(word, r), true
+		}
+	}
+	if inw {
+		words = append(words, string(word))
+	}
+	return words
+}
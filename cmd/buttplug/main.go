@@ -0,0 +1,161 @@
+/*
+Command buttplug is a command-line client for Buttplug servers.
+
+Usage:
+
+	buttplug [flags] <command> [arguments]
+
+The flags are:
+
+	-addr string
+		websocket address of the Buttplug server (default "ws://127.0.0.1:12345/buttplug")
+	-name string
+		client name send to the server (default "buttplug-cli")
+	-insecure
+		skip TLS certificate verification for wss addresses
+	-json
+		output results as JSON, one object per line
+	-v
+		log protocol messages to stderr
+
+The commands are:
+
+	info                              show server information
+	devices                           list devices and their supported messages
+	scan [-timeout 10s]               scan for devices and list them
+	vibrate <device> <speed>          SingleMotorVibrateCmd, speed 0.0-1.0
+	launch <device> <position> <speed> FleshlightLaunchFW12Cmd, 0-99
+	kiiroo <device> <command>         KiirooCmd, command 0-4
+	lovense <device> <command>        LovenseCmd, eg: "Vibrate:10;"
+	vorze <device> <speed> [cw|ccw]   VorzeA10CycloneCmd, speed 0-100
+	raw <device> <hex>                RawCmd, eg: "0010ff"
+	stop <device>                     StopDeviceCmd
+	stopall                           StopAllDevices
+	events [-log level]               print server events until interrupted
+	shell [-history file]             start an interactive shell
+	bridge [-listen addr]             serve devices over a HTTP JSON API, see package bridge
+	proxy [-listen addr] [-priority name=n,...] [-hold 2s]
+	                                  share the server with multiple clients, see package proxy
+	inspect [-listen addr] [-o file] [-pings] [-color]
+	                                  print the traffic between a client connecting to the
+	                                  listen address and the server
+
+Devices can be given by index or by name.
+*/
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/funjack/golibbuttplug"
+)
+
+// DefaultAddr is the default Buttplug server address.
+const DefaultAddr = "ws://127.0.0.1:12345/buttplug"
+
+// errUsage is returned when the command line arguments are invalid.
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	log.SetFlags(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		cancel()
+	}()
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout)
+	cancel()
+	if err == errUsage {
+		os.Exit(2)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "buttplug: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// cli holds the global options of a command line invocation.
+type cli struct {
+	addr     string
+	name     string
+	insecure bool
+	json     bool
+	verbose  bool
+
+	in     io.Reader
+	out    io.Writer
+	errw   io.Writer
+	client *golibbuttplug.Client
+}
+
+// run parses the arguments and executes the command.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	c := &cli{in: stdin, out: stdout, errw: os.Stderr}
+	fs := flag.NewFlagSet("buttplug", flag.ContinueOnError)
+	fs.StringVar(&c.addr, "addr", DefaultAddr, "websocket address of the Buttplug server")
+	fs.StringVar(&c.name, "name", "buttplug-cli", "client name send to the server")
+	fs.BoolVar(&c.insecure, "insecure", false, "skip TLS certificate verification")
+	fs.BoolVar(&c.json, "json", false, "output results as JSON")
+	fs.BoolVar(&c.verbose, "v", false, "log protocol messages to stderr")
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: buttplug [flags] <command> [arguments]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return errUsage
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", fs.Arg(0))
+		fs.Usage()
+		return errUsage
+	}
+	if !c.verbose {
+		log.SetOutput(ioutil.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+	if !offline[fs.Arg(0)] {
+		if err := c.connect(ctx); err != nil {
+			return err
+		}
+		defer c.client.Close()
+	}
+	return cmd(ctx, c, fs.Args()[1:])
+}
+
+// connect creates a client connected to the server. Ctx only cancels
+// connecting, the client stays connected after ctx is done so a command can
+// clean up, eg: stop scanning.
+func (c *cli) connect(ctx context.Context) (err error) {
+	var tlscfg *tls.Config
+	if c.insecure {
+		tlscfg = &tls.Config{InsecureSkipVerify: true}
+	}
+	cctx, cancel := context.WithCancel(context.Background())
+	connected := make(chan struct{})
+	defer close(connected)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-connected:
+		}
+	}()
+	c.client, err = golibbuttplug.NewClient(cctx, c.addr, c.name, tlscfg)
+	return err
+}
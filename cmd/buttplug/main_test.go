@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/funjack/golibbuttplug/buttplugtest"
+	"github.com/funjack/golibbuttplug/message"
+)
+
+func newServer() (*buttplugtest.TestServer, string, func()) {
+	s := &buttplugtest.TestServer{
+		InitialDevices: buttplugtest.DefaultTestServer.InitialDevices,
+		Strict:         true,
+	}
+	ts := httptest.NewServer(s)
+	return s, "ws" + strings.TrimPrefix(ts.URL, "http"), ts.Close
+}
+
+func TestCommands(t *testing.T) {
+	_, addr, done := newServer()
+	defer done()
+
+	cases := []struct {
+		Args []string
+		Want string
+	}{
+		{[]string{"info"}, "TestButtplug (1.0.0) message version 1, max ping time 100ms\n"},
+		{[]string{"vibrate", "0", "0.5"}, "SingleMotorVibrateCmd 0: ok\n"},
+		{[]string{"launch", "Launch", "10", "80"}, "FleshlightLaunchFW12Cmd 2: ok\n"},
+		{[]string{"kiiroo", "2", "4"}, "KiirooCmd 2: ok\n"},
+		{[]string{"lovense", "1", "Vibrate:10;"}, "LovenseCmd 1: ok\n"},
+		{[]string{"raw", "0", "00ff"}, "RawCmd 0: ok\n"},
+		{[]string{"stop", "testdevice 2"}, "StopDeviceCmd 1: ok\n"},
+		{[]string{"stopall"}, "StopAllDevices: ok\n"},
+		{[]string{"-json", "stopall"}, `{"Command":"StopAllDevices","Ok":true}` + "\n"},
+	}
+	for _, c := range cases {
+		var out bytes.Buffer
+		args := append([]string{"-addr", addr}, c.Args...)
+		if err := run(context.Background(), args, nil, &out); err != nil {
+			t.Errorf("%v: %v", c.Args, err)
+			continue
+		}
+		if out.String() != c.Want {
+			t.Errorf("%v: got %q, want %q", c.Args, out.String(), c.Want)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"launch", "2", "100", "10"},
+		{"vibrate", "9", "0.5"},
+		{"vorze", "0", "10"},
+	} {
+		var out bytes.Buffer
+		err := run(context.Background(), append([]string{"-addr", addr}, args...), nil, &out)
+		if err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+	if err := run(context.Background(), []string{"-addr", addr, "unknown"}, nil, nil); err != errUsage {
+		t.Errorf("expected usage error, got %v", err)
+	}
+}
+
+func TestDevicesJSON(t *testing.T) {
+	_, addr, done := newServer()
+	defer done()
+
+	var out bytes.Buffer
+	if err := run(context.Background(), []string{"-addr", addr, "-json", "devices"}, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(&out)
+	var devs []deviceInfo
+	for dec.More() {
+		var d deviceInfo
+		if err := dec.Decode(&d); err != nil {
+			t.Fatal(err)
+		}
+		devs = append(devs, d)
+	}
+	if len(devs) != len(buttplugtest.DefaultTestServer.InitialDevices) {
+		t.Errorf("unexpected devices: %+v", devs)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	s, addr, done := newServer()
+	defer done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var out bytes.Buffer
+	errc := make(chan error)
+	go func() {
+		errc <- run(ctx, []string{"-addr", addr, "events", "-log", message.LogLevelInfo}, nil, &out)
+	}()
+	conn, err := s.WaitConn(1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.WaitFor(time.Second, buttplugtest.MatchType("RequestLog")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SendLog(message.LogLevelInfo, "hello")
+	conn.AddDevice(buttplugtest.DefaultAddDeviceMessage)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	want := "Log\tInfo\thello\nDeviceAdded\t3\tLaunch\tFleshlightLaunchFW12Cmd,KiirooCmd,RawCmd,StopDeviceCmd\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+// blockedWriter blocks writes until unblock is closed.
+type blockedWriter struct {
+	unblock chan struct{}
+	bytes.Buffer
+}
+
+func (w *blockedWriter) Write(p []byte) (int, error) {
+	<-w.unblock
+	return w.Buffer.Write(p)
+}
+
+func TestEventsOverflow(t *testing.T) {
+	s, addr, done := newServer()
+	defer done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := &blockedWriter{unblock: make(chan struct{})}
+	errc := make(chan error)
+	go func() {
+		errc <- run(ctx, []string{"-addr", addr, "events", "-log", message.LogLevelInfo}, nil, out)
+	}()
+	conn, err := s.WaitConn(1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.WaitFor(time.Second, buttplugtest.MatchType("RequestLog")); err != nil {
+		t.Fatal(err)
+	}
+	n := 2 * eventBufferSize
+	for i := 1; i <= n; i++ {
+		conn.SendLog(message.LogLevelInfo, fmt.Sprint(i))
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(out.unblock)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) >= n {
+		t.Errorf("got %d events, want less than %d", len(lines), n)
+	}
+	if want := fmt.Sprintf("Log\tInfo\t%d", n); lines[len(lines)-1] != want {
+		t.Errorf("got last event %q, want %q", lines[len(lines)-1], want)
+	}
+}
+
+func TestScanCancel(t *testing.T) {
+	s, addr, done := newServer()
+	defer done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		errc <- run(ctx, []string{"-addr", addr, "scan", "-timeout", "10s"}, nil, nil)
+	}()
+	conn, err := s.WaitConn(1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.WaitFor(time.Second, buttplugtest.MatchType("StartScanning")); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := conn.WaitFor(time.Second, buttplugtest.MatchType("StopScanning")); err != nil {
+		t.Errorf("scanning not stopped: %v", err)
+	}
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+}
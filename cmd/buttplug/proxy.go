@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/funjack/golibbuttplug/proxy"
+)
+
+func proxyCmd(ctx context.Context, c *cli, args []string) error {
+	fs := flag.NewFlagSet("proxy", flag.ContinueOnError)
+	listen := fs.String("listen", "127.0.0.1:12346", "address of the proxy")
+	priorities := fs.String("priority", "", "comma separated client priorities, eg: player=10,bot=1")
+	hold := fs.Duration("hold", proxy.DefaultHold, "time a client stays in control of a device")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	p := proxy.New(c.client)
+	if *priorities != "" {
+		prio, err := parsePriorities(*priorities)
+		if err != nil {
+			return err
+		}
+		p.Arbitration = proxy.Priority
+		p.Priorities = prio
+		p.Hold = *hold
+	}
+	return c.serve(ctx, *listen, "ws", p)
+}
+
+// parsePriorities parses a list of name=priority pairs.
+func parsePriorities(s string) (map[string]int, error) {
+	prio := make(map[string]int)
+	for _, kv := range strings.Split(s, ",") {
+		i := strings.LastIndex(kv, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid priority: %s", kv)
+		}
+		n, err := strconv.Atoi(kv[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority: %s", kv)
+		}
+		prio[kv[:i]] = n
+	}
+	return prio, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/funjack/golibbuttplug"
+	"github.com/funjack/golibbuttplug/message"
+)
+
+// maxHistory is the number of lines kept in the history file.
+const maxHistory = 1000
+
+// errExit is returned by a shell command to end the shell.
+var errExit = errors.New("exit")
+
+const shellHelp = `Commands:
+  info                               show server information
+  devices                            list devices and their supported messages
+  scan [-timeout 10s]                scan for devices and list them
+  vibrate <device> <speed>           SingleMotorVibrateCmd, speed 0.0-1.0
+  launch <device> <position> <speed> FleshlightLaunchFW12Cmd, 0-99
+  kiiroo <device> <command>          KiirooCmd, command 0-4
+  lovense <device> <command>         LovenseCmd, eg: "Vibrate:10;"
+  vorze <device> <speed> [cw|ccw]    VorzeA10CycloneCmd, speed 0-100
+  raw <device> <hex>                 RawCmd, eg: "0010ff"
+  stop <device>                      StopDeviceCmd
+  stopall                            StopAllDevices
+  log <level>                        request server logs (Off, Error, Info, Debug, ...)
+  history                            show command history
+  help                               show this help
+  exit                               leave the shell
+
+Messages can be send as raw JSON, eg: [{"Test":{"Id":1,"TestString":"Hi"}}]
+Ids of raw messages are replaced. Tab completes commands and device names.
+`
+
+// deviceCommands maps commands to the message type they send.
+var deviceCommands = map[string]string{
+	"vibrate": golibbuttplug.CommandSingleMotorVibrate,
+	"launch":  golibbuttplug.CommandFleshlightLaunchFW12,
+	"kiiroo":  golibbuttplug.CommandKiiroo,
+	"lovense": golibbuttplug.CommandLovense,
+	"vorze":   golibbuttplug.CommandVorzeA10Cyclone,
+	"raw":     golibbuttplug.CommandRaw,
+	"stop":    golibbuttplug.CommandStopDevice,
+}
+
+// notInShell are commands that run until interrupted and can't be used in
+// the shell.
+var notInShell = map[string]bool{
+	"shell":   true,
+	"events":  true,
+	"bridge":  true,
+	"proxy":   true,
+	"inspect": true,
+}
+
+// shellBuiltins are commands that are only available in the shell.
+var shellBuiltins = []string{"log", "history", "help", "exit"}
+
+func shellCmd(ctx context.Context, c *cli, args []string) error {
+	fs := flag.NewFlagSet("shell", flag.ContinueOnError)
+	histfile := fs.String("history", defaultHistoryFile(), "history file, empty to disable")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	raw := false
+	if f, ok := c.in.(*os.File); ok && isTerminal(f.Fd()) {
+		if st, err := makeRaw(f.Fd()); err == nil {
+			raw = true
+			defer restore(f.Fd(), st)
+		}
+	}
+	e := newLineEditor(c.in, c.out, "buttplug> ", raw)
+	e.complete = c.complete
+	out, errw := c.out, c.errw
+	c.out, c.errw = e, e
+	defer func() {
+		c.out, c.errw = out, errw
+	}()
+	for _, line := range readHistory(*histfile) {
+		e.addHistory(line)
+	}
+
+	r, err := c.subscribeEvents()
+	if err != nil {
+		return err
+	}
+	printed := make(chan struct{})
+	go func() {
+		defer close(printed)
+		c.printEvents(r)
+	}()
+	// Stop printing before c.out is restored.
+	defer func() {
+		c.client.Unsubscribe(r)
+		<-printed
+	}()
+
+	si := c.client.ServerInfo()
+	fmt.Fprintf(e, "Connected to %s. Type help for a list of commands.\n", si.ServerName)
+	for {
+		line, err := e.ReadLine()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		e.addHistory(line)
+		appendHistory(*histfile, line)
+		err = c.exec(ctx, line, e)
+		if err == errExit {
+			return nil
+		} else if err == errUsage {
+			continue
+		} else if err != nil {
+			fmt.Fprintf(e, "error: %v\n", err)
+		}
+		select {
+		case <-c.client.Disconnected():
+			return errors.New("disconnected")
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+	}
+}
+
+// exec executes a single line in the shell.
+func (c *cli) exec(ctx context.Context, line string, e *lineEditor) error {
+	if strings.HasPrefix(line, "[") || strings.HasPrefix(line, "{") {
+		return c.sendRaw(ctx, line)
+	}
+	words := splitWords(line)
+	switch words[0] {
+	case "exit", "quit":
+		return errExit
+	case "help":
+		fmt.Fprint(c.out, shellHelp)
+		return nil
+	case "history":
+		e.m.Lock()
+		history := append([]string(nil), e.history...)
+		e.m.Unlock()
+		for i, h := range history {
+			fmt.Fprintf(c.out, "%4d  %s\n", i+1, h)
+		}
+		return nil
+	case "log":
+		if len(words) != 2 {
+			fmt.Fprintln(c.errw, "usage: log <level>")
+			return errUsage
+		}
+		return c.client.RequestLog(words[1])
+	}
+	if notInShell[words[0]] {
+		return fmt.Errorf("%s is not available in the shell", words[0])
+	}
+	cmd, ok := commands[words[0]]
+	if !ok {
+		return fmt.Errorf("unknown command: %s", words[0])
+	}
+	return cmd(ctx, c, words[1:])
+}
+
+// sendRaw sends one or more JSON encoded messages and prints the replies.
+func (c *cli) sendRaw(ctx context.Context, line string) error {
+	var msgs message.OutgoingMessages
+	if strings.HasPrefix(line, "{") {
+		line = "[" + line + "]"
+	}
+	if err := json.Unmarshal([]byte(line), &msgs); err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		if _, v := m.Message(); v == nil {
+			return errors.New("unknown message")
+		}
+		sctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		r, err := c.client.Send(sctx, m)
+		cancel()
+		if _, v := r.Message(); v == nil {
+			return err
+		}
+		b, err := json.Marshal(message.IncomingMessages{r})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(c.out, string(b))
+	}
+	return nil
+}
+
+// printEvents prints all server events read from r.
+func (c *cli) printEvents(r *message.Reader) {
+	for m := range r.Incoming() {
+		c.print(event(m))
+	}
+	if err := r.Err(); err == message.ErrOverflow {
+		fmt.Fprintf(c.errw, "events: %v\n", err)
+	}
+}
+
+// complete returns the candidates for the last word.
+func (c *cli) complete(words []string) []string {
+	var candidates []string
+	switch len(words) {
+	case 1:
+		for name := range commands {
+			if !notInShell[name] {
+				candidates = append(candidates, name)
+			}
+		}
+		candidates = append(candidates, shellBuiltins...)
+	case 2:
+		if words[0] == "log" {
+			return []string{
+				message.LogLevelOff, message.LogLevelFatal,
+				message.LogLevelError, message.LogLevelWarn,
+				message.LogLevelInfo, message.LogLevelDebug,
+				message.LogLevelTrace,
+			}
+		}
+		msgtype, ok := deviceCommands[words[0]]
+		if !ok {
+			return nil
+		}
+		for _, d := range c.client.Devices() {
+			if d.IsSupported(msgtype) {
+				candidates = append(candidates, d.Name(),
+					strconv.FormatUint(uint64(d.Index()), 10))
+			}
+		}
+	case 4:
+		if words[0] == "vorze" {
+			candidates = []string{"cw", "ccw"}
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+func defaultHistoryFile() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".buttplug_history")
+}
+
+// readHistory returns the last lines of the history file.
+func readHistory(path string) []string {
+	if path == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var lines []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	if len(lines) > maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+	}
+	return lines
+}
+
+// appendHistory adds a line to the history file.
+func appendHistory(path, line string) {
+	if path == "" {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
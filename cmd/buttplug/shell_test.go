@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShell(t *testing.T) {
+	_, addr, done := newServer()
+	defer done()
+
+	in := strings.NewReader(`vibrate 0 0.5
+stop "TestDevice 2"
+[{"Test":{"Id":0,"TestString":"Hi"}}]
+{"Test":{"Id":0,"TestString":"Error"}}
+unknown
+exit
+stopall
+`)
+	var out bytes.Buffer
+	if err := run(context.Background(), []string{"-addr", addr, "shell", "-history", ""}, in, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"SingleMotorVibrateCmd 0: ok\n",
+		"StopDeviceCmd 1: ok\n",
+		`"Test":{"Id":`,
+		`"TestString":"Hi"`,
+		`"Error":{"Id":`,
+		"error: unknown command: unknown\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "StopAllDevices") {
+		t.Errorf("command executed after exit")
+	}
+}
+
+func TestLineEditor(t *testing.T) {
+	cases := []struct {
+		Name  string
+		Input string
+		Want  string
+	}{
+		{"Typing", "stopall\r", "stopall"},
+		{"Backspace", "stopx\x7fall\r", "stopall"},
+		{"CursorMove", "stpall\x1b[D\x1b[D\x1b[D\x1b[Do\r", "stopall"},
+		{"ClearLine", "junk\x03stopall\r", "stopall"},
+		{"CompleteCommand", "vib\t0 1\r", "vibrate 0 1"},
+		{"CompleteDevice", "stop \"Test\t2\" \r", `stop "TestDevice 2" `},
+		{"CompleteUnique", "launch L\t\r", "launch Launch "},
+		{"History", "\x1b[A\x1b[A\r", "devices"},
+	}
+	devices := []string{"TestDevice 1", "TestDevice 2", "Launch"}
+	for _, c := range cases {
+		var out bytes.Buffer
+		e := newLineEditor(strings.NewReader(c.Input), &out, "> ", true)
+		e.complete = func(words []string) []string {
+			if len(words) == 1 {
+				return []string{"stop", "stopall", "vibrate"}
+			}
+			return devices
+		}
+		e.addHistory("devices")
+		e.addHistory("info")
+		line, err := e.ReadLine()
+		if err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+		}
+		if line != c.Want {
+			t.Errorf("%s: got %q, want %q", c.Name, line, c.Want)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	cases := map[string][]string{
+		`stop 1`:                  {"stop", "1"},
+		`  stop   "Test Device" `: {"stop", "Test Device"},
+		`lovense 1 'Vibrate:1;'`:  {"lovense", "1", "Vibrate:1;"},
+		`stop "Test`:              {"stop", "Test"},
+	}
+	for in, want := range cases {
+		if got := splitWords(in); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %q, want %q", in, got, want)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/funjack/golibbuttplug/lovense"
 	"github.com/funjack/golibbuttplug/message"
 )
 
@@ -56,6 +57,11 @@ func (d *Device) Name() string {
 	return d.device.DeviceName
 }
 
+// Index returns the device index used by the server.
+func (d *Device) Index() uint32 {
+	return d.device.DeviceIndex
+}
+
 // IsSupported returns true if the message type is supported.
 func (d *Device) IsSupported(msgtype string) bool {
 	for _, dm := range d.device.DeviceMessages {
@@ -141,6 +147,8 @@ func (d *Device) KiirooCmd(cmd int) error {
 
 // FleshlightLaunchFW12Cmd causes a toy that supports Fleshlight Launch
 // (Firmware Version 1.2) style commands to run whatever event may be related.
+// Use package launch to compute the speed of a move, and package kiiroo to play
+// timed Kiiroo events on a Launch.
 func (d *Device) FleshlightLaunchFW12Cmd(pos, spd int) error {
 	if !d.IsSupported(CommandFleshlightLaunchFW12) {
 		return ErrUnsupported
@@ -163,11 +171,22 @@ func (d *Device) FleshlightLaunchFW12Cmd(pos, spd int) error {
 }
 
 // LovenseCmd causes a toy that supports Lovense style commands to run whatever
-// event may be related.
+// event may be related. ErrInvalidCmd is returned for malformed commands, and
+// an error of package lovense when a known command is not supported by the
+// model in the device name, or out of range. Commands that are not known by
+// package lovense are send as is. Use package lovense to build commands.
 func (d *Device) LovenseCmd(cmd string) error {
 	if !d.IsSupported(CommandLovense) {
 		return ErrUnsupported
 	}
+	c, err := lovense.Parse(cmd)
+	if err != nil {
+		return ErrInvalidCmd
+	}
+	err = c.Validate(lovense.ModelFromName(d.Name()))
+	if err != nil && err != lovense.ErrUnknownCommand {
+		return err
+	}
 	id := d.client.counter.Generate()
 	return d.client.sendMessage(id, message.OutgoingMessage{
 		LovenseCmd: &message.LovenseCmd{
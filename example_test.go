@@ -16,20 +16,15 @@ func ExampleClient() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	// Scan for devices.
-	if err := c.StartScanning(); err != nil {
-		log.Fatal(err)
-	}
-	// Wait for scanning to finish.
-	ctx, cancel := context.WithTimeout(rootctx, 30*time.Second)
-	err = c.WaitOnScanning(ctx)
-	cancel()
-	if err == context.DeadlineExceeded {
-		// Stop scanning.
-		if err := c.StopScanning(); err != nil {
-			log.Fatal(err)
+	// Scan for devices for at most 30 seconds, scanning is stopped when
+	// Scan returns.
+	err = c.Scan(rootctx, 30*time.Second, func(e ScanEvent) bool {
+		if e.Device != nil {
+			log.Printf("Found device: %s", e.Device.Name())
 		}
-	} else if err != nil {
+		return true
+	})
+	if err != nil {
 		log.Fatal(err)
 	}
 	// Get all known devices.
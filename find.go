@@ -0,0 +1,163 @@
+package golibbuttplug
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Capability is a kind of action a device can perform.
+type Capability int
+
+const (
+	// Vibrate devices support SingleMotorVibrateCmd or LovenseCmd.
+	Vibrate Capability = iota
+	// Stroke devices support FleshlightLaunchFW12Cmd or KiirooCmd.
+	Stroke
+	// Rotate devices support VorzeA10CycloneCmd.
+	Rotate
+)
+
+// capabilities are the commands that provide a capability.
+var capabilities = map[Capability][]string{
+	Vibrate: {CommandSingleMotorVibrate, CommandLovense},
+	Stroke:  {CommandFleshlightLaunchFW12, CommandKiiroo},
+	Rotate:  {CommandVorzeA10Cyclone},
+}
+
+// DeviceFilter reports whether a device is selected.
+type DeviceFilter func(d DeviceAPI) bool
+
+// ByName selects devices with a name that matches the pattern, ignoring
+// case. The pattern syntax is the same as path.Match, eg: "*launch*".
+func ByName(pattern string) DeviceFilter {
+	pattern = strings.ToLower(pattern)
+	return func(d DeviceAPI) bool {
+		ok, _ := path.Match(pattern, strings.ToLower(d.Name()))
+		return ok
+	}
+}
+
+// Supports selects devices that support all the message types.
+func Supports(msgtypes ...string) DeviceFilter {
+	return func(d DeviceAPI) bool {
+		for _, t := range msgtypes {
+			if !d.IsSupported(t) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Can selects devices that have the capability.
+func Can(c Capability) DeviceFilter {
+	return func(d DeviceAPI) bool {
+		for _, t := range capabilities[c] {
+			if d.IsSupported(t) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Device returns the device with the given index. False is returned when
+// the device is not known.
+func (c *Client) Device(index uint32) (*Device, bool) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	d, ok := c.devices[index]
+	return d, ok
+}
+
+// FindDevices returns the devices that match all filters, sorted by index.
+func (c *Client) FindDevices(filters ...DeviceFilter) []*Device {
+	var found []*Device
+	for _, d := range c.Devices() {
+		if match(d, filters) {
+			found = append(found, d)
+		}
+	}
+	return found
+}
+
+// Delays between scans of WaitDevice when the server finished scanning
+// without finding a matching device.
+const (
+	minRescanDelay = time.Second
+	maxRescanDelay = 30 * time.Second
+)
+
+// WaitDevice returns the first device that matches all filters. When there
+// is no such device the server is asked to scan until one is found or ctx
+// is done, see Scan. When the server finishes scanning without finding a
+// match, WaitDevice waits for a device to be added, or scans again after a
+// delay that grows up to 30 seconds.
+func (c *Client) WaitDevice(ctx context.Context, filters ...DeviceFilter) (*Device, error) {
+	delay := minRescanDelay
+	for {
+		c.m.RLock()
+		added := c.added
+		c.m.RUnlock()
+		if found := c.FindDevices(filters...); len(found) > 0 {
+			return found[0], nil
+		}
+		var found *Device
+		err := c.Scan(ctx, 0, func(e ScanEvent) bool {
+			if d, ok := e.Device.(*Device); ok && match(d, filters) {
+				found = d
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if found != nil {
+			return found, nil
+		}
+		if err := c.waitAdded(ctx, added, delay); err != nil {
+			return nil, err
+		}
+		if delay *= 2; delay > maxRescanDelay {
+			delay = maxRescanDelay
+		}
+	}
+}
+
+// WaitAdded waits until added is closed or d has elapsed. An error is
+// returned when ctx is done or the client is stopped.
+func (c *Client) waitAdded(ctx context.Context, added <-chan struct{}, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-added:
+	case <-t.C:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.stop:
+		return errors.New("client stopped")
+	}
+	return nil
+}
+
+// Match reports whether a device matches all filters.
+func match(d DeviceAPI, filters []DeviceFilter) bool {
+	for _, f := range filters {
+		if !f(d) {
+			return false
+		}
+	}
+	return true
+}
+
+// SortDevices sorts devices by index.
+func sortDevices(d []*Device) {
+	sort.Slice(d, func(i, j int) bool {
+		return d[i].Index() < d[j].Index()
+	})
+}
@@ -0,0 +1,150 @@
+package golibbuttplug
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/funjack/golibbuttplug/buttplugtest"
+)
+
+func indexes(devices []*Device) []uint32 {
+	var idx []uint32
+	for _, d := range devices {
+		idx = append(idx, d.Index())
+	}
+	return idx
+}
+
+func TestFindDevices(t *testing.T) {
+	c, done := connect(t, newTestServer())
+	defer done()
+
+	if d, ok := c.Device(2); !ok || d.Name() != "Launch" {
+		t.Errorf("Device(2) = %v, %t", d, ok)
+	}
+	if _, ok := c.Device(9); ok {
+		t.Errorf("unknown device found")
+	}
+	cases := []struct {
+		Name    string
+		Filters []DeviceFilter
+		Want    []uint32
+	}{
+		{"All", nil, []uint32{0, 1, 2}},
+		{"Name", []DeviceFilter{ByName("TESTDEVICE*")}, []uint32{0, 1}},
+		{"Supports", []DeviceFilter{Supports(CommandLovense, CommandStopDevice)}, []uint32{1}},
+		{"Can", []DeviceFilter{Can(Stroke)}, []uint32{0, 2}},
+		{"Combined", []DeviceFilter{Can(Vibrate), ByName("*2")}, []uint32{1}},
+		{"None", []DeviceFilter{Can(Rotate)}, nil},
+	}
+	for _, tc := range cases {
+		if got := indexes(c.FindDevices(tc.Filters...)); !reflect.DeepEqual(got, tc.Want) {
+			t.Errorf("%s: got %v, want %v", tc.Name, got, tc.Want)
+		}
+	}
+	if got := indexes(c.Devices()); !reflect.DeepEqual(got, []uint32{0, 1, 2}) {
+		t.Errorf("devices not sorted: %v", got)
+	}
+}
+
+func TestWaitDevice(t *testing.T) {
+	s := newTestServer()
+	c, done := connect(t, s)
+	defer done()
+
+	if d, err := c.WaitDevice(context.Background(), ByName("Launch")); err != nil || d.Index() != 2 {
+		t.Errorf("WaitDevice = %v, %v", d, err)
+	}
+	conn := s.LastConn()
+	go func() {
+		if _, err := conn.WaitFor(time.Second, buttplugtest.MatchType("StartScanning")); err != nil {
+			t.Error(err)
+			return
+		}
+		conn.AddDevice(vorze)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	d, err := c.WaitDevice(ctx, Can(Rotate))
+	if err != nil || d.Index() != 5 {
+		t.Fatalf("WaitDevice = %v, %v", d, err)
+	}
+	if _, err := conn.WaitFor(time.Second, buttplugtest.MatchType("StopScanning")); err != nil {
+		t.Errorf("scanning not stopped: %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.WaitDevice(ctx, ByName("Hush")); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want deadline exceeded", err)
+	}
+}
+
+func TestWaitDeviceRescan(t *testing.T) {
+	s := newTestServer()
+	c, done := connect(t, s)
+	defer done()
+	conn := s.LastConn()
+	// The server finishes scanning right away.
+	go func() {
+		for {
+			if _, err := conn.WaitFor(time.Second, buttplugtest.MatchType("StartScanning")); err != nil {
+				return
+			}
+			conn.SendScanningFinished()
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := c.WaitDevice(ctx, ByName("Hush")); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want deadline exceeded", err)
+	}
+	scans := 0
+	for _, r := range conn.History() {
+		if r.Message.StartScanning != nil {
+			scans++
+		}
+	}
+	if scans != 1 {
+		t.Errorf("scanned %d times, want 1", scans)
+	}
+
+	// A device that is added wakes up WaitDevice.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		conn.AddDevice(vorze)
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if d, err := c.WaitDevice(ctx, Can(Rotate)); err != nil || d.Index() != 5 {
+		t.Errorf("WaitDevice = %v, %v", d, err)
+	}
+}
+
+func TestClientAPI(t *testing.T) {
+	c, done := connect(t, newTestServer())
+	defer done()
+	api := c.API()
+
+	if got, want := len(api.Devices()), len(c.Devices()); got != want {
+		t.Errorf("got %d devices, want %d", got, want)
+	}
+	launch, _ := c.Device(2)
+	if d, ok := api.Device(2); !ok || d != DeviceAPI(launch) {
+		t.Errorf("Device(2) = %v, %t", d, ok)
+	}
+	if d, ok := api.Device(9); ok || d != nil {
+		t.Errorf("Device(9) = %v, %t", d, ok)
+	}
+	found := api.FindDevices(Can(Stroke))
+	if len(found) != 2 || found[0].Index() != 0 || found[1].Index() != 2 {
+		t.Errorf("unexpected devices %v", found)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if d, err := api.WaitDevice(ctx, ByName("launch")); err != nil || d.Index() != 2 {
+		t.Errorf("WaitDevice = %v, %v", d, err)
+	}
+}
@@ -0,0 +1,52 @@
+package golibbuttplug
+
+import (
+	"context"
+
+	"github.com/funjack/golibbuttplug/message"
+)
+
+// RoundTripFunc sends a message to the server and returns the reply.
+type RoundTripFunc func(ctx context.Context, m message.OutgoingMessage) (message.IncomingMessage, error)
+
+// Interceptor is called for every message the client sends to the server,
+// including pings and the handshake. It can modify the message before passing
+// it to next, return a synthetic reply (eg: OkReply) without calling next, or
+// return an error to drop it. The id of the message must not be changed.
+type Interceptor func(ctx context.Context, m message.OutgoingMessage, next RoundTripFunc) (message.IncomingMessage, error)
+
+// Intercept adds an interceptor for outgoing messages. Intercept can be used
+// multiple times, the first interceptor is called first.
+func Intercept(i Interceptor) Option {
+	return func(c *Client) {
+		c.interceptors = append(c.interceptors, i)
+	}
+}
+
+// InterceptIncoming adds an interceptor for messages received from the
+// server. It is called before the message is delivered to the client and
+// subscribers, dropping a DeviceAdded message hides the device.
+// InterceptIncoming can be used multiple times, the first interceptor is
+// called first.
+func InterceptIncoming(i message.IncomingInterceptor) Option {
+	return func(c *Client) {
+		c.incoming = append(c.incoming, i)
+	}
+}
+
+// OkReply returns the Ok reply for message m.
+func OkReply(m message.OutgoingMessage) message.IncomingMessage {
+	return message.IncomingMessage{Ok: &message.Empty{ID: m.ID()}}
+}
+
+// Chain returns a RoundTripFunc that passes messages through the
+// interceptors before calling rt.
+func chain(interceptors []Interceptor, rt RoundTripFunc) RoundTripFunc {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		next, intercept := rt, interceptors[i]
+		rt = func(ctx context.Context, m message.OutgoingMessage) (message.IncomingMessage, error) {
+			return intercept(ctx, m, next)
+		}
+	}
+	return rt
+}
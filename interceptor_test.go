@@ -0,0 +1,113 @@
+package golibbuttplug
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funjack/golibbuttplug/buttplugtest"
+	"github.com/funjack/golibbuttplug/message"
+)
+
+func TestIntercept(t *testing.T) {
+	errBlocked := errors.New("device blocked")
+	var m sync.Mutex
+	var order []string
+	called := func(name string) {
+		m.Lock()
+		defer m.Unlock()
+		order = append(order, name)
+	}
+	// Scale vibration to half the speed.
+	scale := func(ctx context.Context, m message.OutgoingMessage, next RoundTripFunc) (message.IncomingMessage, error) {
+		if m.SingleMotorVibrateCmd != nil {
+			called("scale")
+			cmd := *m.SingleMotorVibrateCmd
+			cmd.Speed /= 2
+			m.SingleMotorVibrateCmd = &cmd
+		}
+		return next(ctx, m)
+	}
+	// Block device 1 and do not send stop commands.
+	block := func(ctx context.Context, m message.OutgoingMessage, next RoundTripFunc) (message.IncomingMessage, error) {
+		if m.SingleMotorVibrateCmd != nil {
+			called("block")
+		}
+		if m.StopDeviceCmd != nil {
+			return OkReply(m), nil
+		}
+		if index, ok := m.DeviceIndex(); ok && index == 1 {
+			return message.IncomingMessage{}, errBlocked
+		}
+		return next(ctx, m)
+	}
+	s := newTestServer()
+	c, done := connect(t, s, Intercept(scale), Intercept(block))
+	defer done()
+	conn := s.LastConn()
+
+	devices := c.Devices()
+	if err := devices[0].SingleMotorVibrateCmd(0.5); err != nil {
+		t.Fatal(err)
+	}
+	r, err := conn.WaitFor(time.Second, buttplugtest.MatchType("SingleMotorVibrateCmd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spd := r.Message.SingleMotorVibrateCmd.Speed; spd != 0.25 {
+		t.Errorf("got speed %v, want 0.25", spd)
+	}
+	if err := devices[1].SingleMotorVibrateCmd(0.5); err != errBlocked {
+		t.Errorf("got %v, want %v", err, errBlocked)
+	}
+	if err := devices[0].StopDeviceCmd(); err != nil {
+		t.Errorf("stop: %v", err)
+	}
+	if _, err := conn.WaitFor(50*time.Millisecond, buttplugtest.MatchType("SingleMotorVibrateCmd", "StopDeviceCmd")); err != buttplugtest.ErrTimeout {
+		t.Errorf("intercepted message sent to server: %v", err)
+	}
+	m.Lock()
+	defer m.Unlock()
+	want := []string{"scale", "block", "scale", "block"}
+	if len(order) != len(want) {
+		t.Fatalf("got calls %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got calls %v, want %v", order, want)
+		}
+	}
+}
+
+func TestInterceptIncoming(t *testing.T) {
+	hide := func(m *message.IncomingMessage) bool {
+		return m.DeviceAdded == nil || m.DeviceAdded.DeviceName != "Hidden"
+	}
+	rename := func(m *message.IncomingMessage) bool {
+		if m.DeviceAdded != nil {
+			m.DeviceAdded.DeviceName += " (renamed)"
+		}
+		return true
+	}
+	s := newTestServer()
+	c, done := connect(t, s, InterceptIncoming(hide), InterceptIncoming(rename))
+	defer done()
+	conn := s.LastConn()
+
+	conn.AddDevice(&message.Device{DeviceName: "Hidden", DeviceIndex: 7})
+	conn.AddDevice(vorze)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	d, err := c.WaitDevice(ctx, ByName("Vorze*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Name() != "Vorze A10 Cyclone (renamed)" {
+		t.Errorf("got name %q", d.Name())
+	}
+	if _, ok := c.Device(7); ok {
+		t.Error("hidden device added")
+	}
+}
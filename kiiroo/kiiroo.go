@@ -0,0 +1,90 @@
+// Package kiiroo plays Kiiroo position events on a Fleshlight Launch.
+//
+// Kiiroo toys use discrete positions 0-4, the Launch moves to a position at
+// a speed. The speed of every move is computed from the time until the next
+// event, so a Player needs to know an event before it is due.
+//
+// This is not the conversion of the Kiiroo to Launch reference players. Those
+// ignore the Kiiroo position: they alternate between the top and the bottom
+// of the Launch and pick the speed from a table of time deltas between
+// events. This package keeps the position, 0 maps to 0 and 4 to 99, and
+// picks the speed that arrives at the position on time using the speed model
+// of package launch. Moves between nearby positions are therefore shorter
+// and slower than with the reference players, and the output can not be
+// compared command for command.
+package kiiroo
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/funjack/golibbuttplug/launch"
+)
+
+// ErrInvalidScript is returned when a Kiiroo script can not be parsed.
+var ErrInvalidScript = errors.New("kiiroo: invalid script")
+
+// Event is a Kiiroo position at a point in time.
+type Event struct {
+	// Time of the event since the start of the stream or script.
+	Time time.Duration
+	// Position 0-4.
+	Position int
+}
+
+// ParseScript parses a legacy Kiiroo script of the form "{1.23:4,2.34:1}",
+// with times in seconds. Events are returned sorted by time.
+func ParseScript(s string) ([]Event, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+		return nil, ErrInvalidScript
+	}
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	if s == "" {
+		return nil, nil
+	}
+	var events []Event
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.Split(pair, ":")
+		if len(kv) != 2 {
+			return nil, ErrInvalidScript
+		}
+		secs, err := strconv.ParseFloat(strings.TrimSpace(kv[0]), 64)
+		if err != nil || secs < 0 || math.IsInf(secs, 0) {
+			return nil, ErrInvalidScript
+		}
+		pos, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || pos < 0 || pos > 4 {
+			return nil, ErrInvalidScript
+		}
+		events = append(events, Event{
+			Time:     time.Duration(secs * float64(time.Second)),
+			Position: pos,
+		})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time < events[j].Time
+	})
+	return events, nil
+}
+
+// LaunchPosition returns the Launch position of a Kiiroo position, 0 maps to
+// 0 and 4 maps to 99.
+func LaunchPosition(kiiroo int) int {
+	if kiiroo < 0 {
+		kiiroo = 0
+	} else if kiiroo > 4 {
+		kiiroo = 4
+	}
+	return kiiroo * 99 / 4
+}
+
+// Launch returns the Launch command that moves from the position of event
+// from to the position of event to, arriving at the time of event to.
+func Launch(from, to Event) (pos, spd int) {
+	return launch.Move(LaunchPosition(from.Position), LaunchPosition(to.Position), to.Time-from.Time)
+}
@@ -0,0 +1,127 @@
+package kiiroo
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseScript(t *testing.T) {
+	got, err := ParseScript(" {1.5:4, 0.25:1,2:0} ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{
+		{250 * time.Millisecond, 1},
+		{1500 * time.Millisecond, 4},
+		{2 * time.Second, 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, err := ParseScript("{}"); err != nil || len(got) != 0 {
+		t.Errorf("empty script: got %v, %v", got, err)
+	}
+	for _, s := range []string{
+		"",
+		"1.5:4",
+		"{1.5}",
+		"{1.5:4,}",
+		"{1.5:5}",
+		"{-1:2}",
+		"{a:2}",
+		"{1:2:3}",
+	} {
+		if _, err := ParseScript(s); err != ErrInvalidScript {
+			t.Errorf("%q: got error %v, want ErrInvalidScript", s, err)
+		}
+	}
+}
+
+func TestLaunch(t *testing.T) {
+	for _, tc := range []struct {
+		From, To Event
+		Pos, Spd int
+	}{
+		{Event{0, 0}, Event{500 * time.Millisecond, 4}, 99, 40},
+		{Event{0, 4}, Event{250 * time.Millisecond, 0}, 0, 80},
+		{Event{0, 0}, Event{400 * time.Millisecond, 2}, 49, 24},
+		{Event{0, 0}, Event{time.Second, 2}, 49, 20},
+		{Event{0, 4}, Event{5 * time.Second, 0}, 0, 20},
+		{Event{0, 1}, Event{0, 3}, 74, 80},
+	} {
+		pos, spd := Launch(tc.From, tc.To)
+		if pos != tc.Pos || spd != tc.Spd {
+			t.Errorf("%v -> %v: got (%d, %d), want (%d, %d)",
+				tc.From, tc.To, pos, spd, tc.Pos, tc.Spd)
+		}
+	}
+}
+
+// move is a recorded FleshlightLaunchFW12Cmd call.
+type move struct {
+	Pos, Spd int
+	Time     time.Time
+}
+
+type launcher struct {
+	m     sync.Mutex // Protects moves.
+	moves []move
+}
+
+func (l *launcher) FleshlightLaunchFW12Cmd(pos, spd int) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.moves = append(l.moves, move{pos, spd, time.Now()})
+	return nil
+}
+
+func TestPlayer(t *testing.T) {
+	l := &launcher{}
+	p := NewPlayer(l)
+	p.Delay = 10 * time.Millisecond
+	events := []Event{
+		{0, 0},
+		{100 * time.Millisecond, 4},
+		{120 * time.Millisecond, 4}, // Same position, skipped.
+		{200 * time.Millisecond, 0},
+	}
+	start := time.Now()
+	if err := p.PlayEvents(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.moves) != 3 {
+		t.Fatalf("got %d moves, want 3: %v", len(l.moves), l.moves)
+	}
+	for i, want := range [][2]int{{0, initialSpeed}, {99, 80}, {0, 80}} {
+		if m := l.moves[i]; m.Pos != want[0] || m.Spd != want[1] {
+			t.Errorf("move %d: got (%d, %d), want %v", i, m.Pos, m.Spd, want)
+		}
+	}
+	// The move to the last position starts at the time of the second event.
+	if d := l.moves[2].Time.Sub(start); d < 110*time.Millisecond {
+		t.Errorf("last move started after %s, want 110ms", d)
+	}
+}
+
+func TestPlayerCancel(t *testing.T) {
+	p := NewPlayer(&launcher{})
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan Event, 1)
+	events <- Event{0, 2}
+	done := make(chan error)
+	go func() {
+		done <- p.Play(ctx, events)
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("got error %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("player did not return after cancel")
+	}
+}
@@ -0,0 +1,123 @@
+package kiiroo
+
+import (
+	"context"
+	"time"
+)
+
+// Launcher is a device that can be moved, like golibbuttplug.Device.
+type Launcher interface {
+	FleshlightLaunchFW12Cmd(pos, spd int) error
+}
+
+// DefaultDelay is the default lookahead of a Player.
+const DefaultDelay = 500 * time.Millisecond
+
+// initialSpeed is the speed of the move to the first position.
+const initialSpeed = 50
+
+// Player plays Kiiroo events on a Launcher.
+type Player struct {
+	// Delay is how far playback runs behind the first event, so later
+	// events are received before they are needed. Events that are received
+	// late are played immediately. DefaultDelay is used when zero.
+	Delay time.Duration
+
+	launcher Launcher
+}
+
+// NewPlayer returns a Player for the launcher.
+func NewPlayer(l Launcher) *Player {
+	return &Player{launcher: l}
+}
+
+// Play plays the events from the channel in order of arrival. Playback starts
+// Delay after the first event is received. At the time of every event the
+// launcher is moved to the position of the next event, at the speed needed to
+// arrive on time. Events at the same position as the previous event are
+// skipped. Play returns when the channel is closed and the last move is
+// send, when ctx is done, or when the launcher returns an error.
+func (p *Player) Play(ctx context.Context, events <-chan Event) error {
+	delay := p.Delay
+	if delay == 0 {
+		delay = DefaultDelay
+	}
+	var cur Event
+	select {
+	case e, ok := <-events:
+		if !ok {
+			return nil
+		}
+		cur = e
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// Event time t is played at start + t.
+	start := time.Now().Add(delay).Add(-cur.Time)
+	if err := p.launcher.FleshlightLaunchFW12Cmd(LaunchPosition(cur.Position), initialSpeed); err != nil {
+		return err
+	}
+	for {
+		next, ok, err := p.next(ctx, events, cur)
+		if err != nil || !ok {
+			return err
+		}
+		if err := wait(ctx, start.Add(cur.Time)); err != nil {
+			return err
+		}
+		// Moves that start late must be faster.
+		from := cur
+		if late := time.Since(start.Add(cur.Time)); late > 0 {
+			from.Time += late
+		}
+		pos, spd := Launch(from, next)
+		if err := p.launcher.FleshlightLaunchFW12Cmd(pos, spd); err != nil {
+			return err
+		}
+		cur = next
+	}
+}
+
+// PlayEvents plays a list of events, eg: a parsed script.
+func (p *Player) PlayEvents(ctx context.Context, events []Event) error {
+	c := make(chan Event, len(events))
+	for _, e := range events {
+		c <- e
+	}
+	close(c)
+	return p.Play(ctx, c)
+}
+
+// Next returns the next event at a different position than cur. False is
+// returned when the channel is closed.
+func (p *Player) next(ctx context.Context, events <-chan Event, cur Event) (Event, bool, error) {
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return Event{}, false, nil
+			}
+			if LaunchPosition(e.Position) != LaunchPosition(cur.Position) {
+				return e, true, nil
+			}
+		case <-ctx.Done():
+			return Event{}, false, ctx.Err()
+		}
+	}
+}
+
+// Wait waits until t or until ctx is done.
+func wait(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,82 @@
+// Package launch converts between distance, duration and speed of Fleshlight
+// Launch moves.
+//
+// The Launch moves to a position (0-99) at a speed (0-99), the conversions
+// use the empirically tuned formula of the reference Buttplug implementation.
+package launch
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// MinPosition is the bottom position.
+	MinPosition = 0
+	// MaxPosition is the top position.
+	MaxPosition = 99
+	// MinSpeed is the slowest speed the Launch moves reliably at.
+	MinSpeed = 20
+	// MaxSpeed is the fastest speed the Launch moves reliably at.
+	MaxSpeed = 80
+)
+
+// ClampPosition returns pos limited to MinPosition and MaxPosition.
+func ClampPosition(pos int) int {
+	if pos < MinPosition {
+		return MinPosition
+	} else if pos > MaxPosition {
+		return MaxPosition
+	}
+	return pos
+}
+
+// ClampSpeed returns spd limited to MinSpeed and MaxSpeed.
+func ClampSpeed(spd int) int {
+	if spd < MinSpeed {
+		return MinSpeed
+	} else if spd > MaxSpeed {
+		return MaxSpeed
+	}
+	return spd
+}
+
+// Distance returns the distance between two positions.
+func Distance(from, to int) int {
+	dist := ClampPosition(to) - ClampPosition(from)
+	if dist < 0 {
+		return -dist
+	}
+	return dist
+}
+
+// Speed returns the speed needed to move dist in duration d, clamped with
+// ClampSpeed. MaxSpeed is returned when dist or d is not positive.
+func Speed(dist int, d time.Duration) int {
+	if dist <= 0 || d <= 0 {
+		return MaxSpeed
+	}
+	ms := float64(d) / float64(time.Millisecond) * 90 / float64(dist)
+	return ClampSpeed(int(25000 * math.Pow(ms, -1.05)))
+}
+
+// Duration returns the time it takes to move dist at speed spd. Speeds outside
+// of the device range 1-99 are limited to it.
+func Duration(dist, spd int) time.Duration {
+	if dist <= 0 {
+		return 0
+	}
+	if spd < 1 {
+		spd = 1
+	} else if spd > 99 {
+		spd = 99
+	}
+	ms := math.Pow(float64(spd)/25000, -0.95) * float64(dist) / 90
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// Move returns the speed to move from position from to position to in
+// duration d, and the position clamped to the device limits.
+func Move(from, to int, d time.Duration) (pos, spd int) {
+	return ClampPosition(to), Speed(Distance(from, to), d)
+}
@@ -0,0 +1,90 @@
+package launch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpeed(t *testing.T) {
+	for _, tc := range []struct {
+		Dist     int
+		Duration time.Duration
+		Want     int
+	}{
+		{99, 500 * time.Millisecond, 40},
+		{49, 400 * time.Millisecond, 24},
+		{50, 300 * time.Millisecond, 33},
+		{99, 250 * time.Millisecond, MaxSpeed},  // 83
+		{99, 1000 * time.Millisecond, MinSpeed}, // 19
+		{10, 100 * time.Millisecond, MinSpeed},  // 19
+		{0, time.Second, MaxSpeed},
+		{99, 0, MaxSpeed},
+	} {
+		if got := Speed(tc.Dist, tc.Duration); got != tc.Want {
+			t.Errorf("Speed(%d, %s) = %d, want %d", tc.Dist, tc.Duration, got, tc.Want)
+		}
+	}
+}
+
+func TestDuration(t *testing.T) {
+	for _, tc := range []struct {
+		Dist, Spd int
+		Want      time.Duration
+	}{
+		{99, 20, 962 * time.Millisecond},
+		{99, 50, 403 * time.Millisecond},
+		{99, 80, 257 * time.Millisecond},
+		{99, 99, 210 * time.Millisecond},
+		{50, 40, 251 * time.Millisecond},
+		{10, 80, 26 * time.Millisecond},
+		{99, 0, 16574 * time.Millisecond},
+		{99, 150, 210 * time.Millisecond},
+		{0, 50, 0},
+	} {
+		got := Duration(tc.Dist, tc.Spd).Truncate(time.Millisecond)
+		if got != tc.Want {
+			t.Errorf("Duration(%d, %d) = %s, want %s", tc.Dist, tc.Spd, got, tc.Want)
+		}
+	}
+}
+
+func TestMove(t *testing.T) {
+	for _, tc := range []struct {
+		From, To int
+		Duration time.Duration
+		Pos, Spd int
+	}{
+		{0, 99, 500 * time.Millisecond, 99, 40},
+		{99, 0, 500 * time.Millisecond, 0, 40},
+		{-10, 120, 500 * time.Millisecond, 99, 40},
+		{50, 50, time.Second, 50, MaxSpeed},
+	} {
+		pos, spd := Move(tc.From, tc.To, tc.Duration)
+		if pos != tc.Pos || spd != tc.Spd {
+			t.Errorf("Move(%d, %d, %s) = (%d, %d), want (%d, %d)",
+				tc.From, tc.To, tc.Duration, pos, spd, tc.Pos, tc.Spd)
+		}
+	}
+}
+
+func TestClamp(t *testing.T) {
+	for _, tc := range []struct {
+		In, Pos, Spd int
+	}{
+		{-1, MinPosition, MinSpeed},
+		{0, 0, MinSpeed},
+		{50, 50, 50},
+		{99, 99, MaxSpeed},
+		{100, MaxPosition, MaxSpeed},
+	} {
+		if got := ClampPosition(tc.In); got != tc.Pos {
+			t.Errorf("ClampPosition(%d) = %d, want %d", tc.In, got, tc.Pos)
+		}
+		if got := ClampSpeed(tc.In); got != tc.Spd {
+			t.Errorf("ClampSpeed(%d) = %d, want %d", tc.In, got, tc.Spd)
+		}
+	}
+	if got := Distance(80, 20); got != 60 {
+		t.Errorf("Distance(80, 20) = %d, want 60", got)
+	}
+}
@@ -0,0 +1,224 @@
+// Package lovense builds, validates and parses Lovense toy commands as used
+// by the LovenseCmd message.
+//
+// Commands have the form "Name:Arg:Arg;", eg: "Vibrate:10;".
+package lovense
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrSyntax is returned for commands that are not of the form
+	// "Name:Arg;".
+	ErrSyntax = errors.New("lovense: invalid command syntax")
+	// ErrUnknownCommand is returned for commands that are not known.
+	ErrUnknownCommand = errors.New("lovense: unknown command")
+	// ErrUnsupported is returned for commands the toy model does not
+	// support.
+	ErrUnsupported = errors.New("lovense: command not supported by model")
+	// ErrArgs is returned when a command has the wrong amount of arguments
+	// or an argument is not valid.
+	ErrArgs = errors.New("lovense: invalid arguments")
+	// ErrRange is returned when a level is out of range.
+	ErrRange = errors.New("lovense: level out of range")
+	// ErrResponse is returned when a toy responds with an error or the
+	// response can not be parsed.
+	ErrResponse = errors.New("lovense: invalid response")
+)
+
+// Command is a Lovense command.
+type Command struct {
+	// Name of the command, eg: "Vibrate".
+	Name string
+	// Args are the arguments of the command.
+	Args []string
+}
+
+// String returns the command as send to the toy, eg: "Vibrate:10;".
+func (c Command) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), ":") + ";"
+}
+
+// Parse parses a command string. Only the syntax is checked, use Validate to
+// check the command and its arguments.
+func Parse(s string) (Command, error) {
+	if !strings.HasSuffix(s, ";") {
+		return Command{}, ErrSyntax
+	}
+	parts := strings.Split(strings.TrimSuffix(s, ";"), ":")
+	for i, p := range parts {
+		if p == "" {
+			return Command{}, ErrSyntax
+		}
+		for j, r := range p {
+			letter := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+			digit := r >= '0' && r <= '9'
+			if !letter && !digit || i == 0 && j == 0 && !letter {
+				return Command{}, ErrSyntax
+			}
+		}
+	}
+	return Command{Name: parts[0], Args: parts[1:]}, nil
+}
+
+// Vibrate sets the vibration level, range 0-20. Edge toys vibrate both
+// motors.
+func Vibrate(level int) Command {
+	return Command{Name: "Vibrate", Args: []string{strconv.Itoa(level)}}
+}
+
+// VibrateMotor sets the vibration level of motor 1 or 2 of an Edge, range
+// 0-20.
+func VibrateMotor(motor, level int) Command {
+	return Command{Name: "Vibrate" + strconv.Itoa(motor), Args: []string{strconv.Itoa(level)}}
+}
+
+// Rotate sets the rotation level of a Nora, range 0-20.
+func Rotate(level int) Command {
+	return Command{Name: "Rotate", Args: []string{strconv.Itoa(level)}}
+}
+
+// RotateChange changes the rotation direction of a Nora.
+func RotateChange() Command {
+	return Command{Name: "RotateChange"}
+}
+
+// AirAuto sets the automatic air pump level of a Max, range 0-3.
+func AirAuto(level int) Command {
+	return Command{Name: "AirAuto", Args: []string{strconv.Itoa(level)}}
+}
+
+// AirIn inflates the air pump of a Max by level, range 1-3.
+func AirIn(level int) Command {
+	return Command{Name: "Air", Args: []string{"In", strconv.Itoa(level)}}
+}
+
+// AirOut deflates the air pump of a Max by level, range 1-3.
+func AirOut(level int) Command {
+	return Command{Name: "Air", Args: []string{"Out", strconv.Itoa(level)}}
+}
+
+// Light turns the light of the toy on or off.
+func Light(on bool) Command {
+	if on {
+		return Command{Name: "Light", Args: []string{"on"}}
+	}
+	return Command{Name: "Light", Args: []string{"off"}}
+}
+
+// Battery requests the battery level, see ParseBattery.
+func Battery() Command {
+	return Command{Name: "Battery"}
+}
+
+// DeviceType requests the model, firmware version and address of the toy,
+// see ParseDeviceType.
+func DeviceType() Command {
+	return Command{Name: "DeviceType"}
+}
+
+// Status requests the status of the toy, see ParseStatus.
+func Status() Command {
+	return Command{Name: "Status", Args: []string{"1"}}
+}
+
+// PowerOff turns the toy off.
+func PowerOff() Command {
+	return Command{Name: "PowerOff"}
+}
+
+// spec describes the arguments and models of a known command.
+type spec struct {
+	args   []string // Fixed arguments, "" is the level.
+	min    int      // Minimum level.
+	max    int      // Maximum level.
+	models []Model  // Models that support the command, nil is all.
+}
+
+// specs of the known commands by name.
+var specs = map[string][]spec{
+	"Vibrate":      {{args: []string{""}, max: 20}},
+	"Vibrate1":     {{args: []string{""}, max: 20, models: []Model{Edge}}},
+	"Vibrate2":     {{args: []string{""}, max: 20, models: []Model{Edge}}},
+	"Rotate":       {{args: []string{""}, max: 20, models: []Model{Nora}}},
+	"RotateChange": {{models: []Model{Nora}}},
+	"AirAuto":      {{args: []string{""}, max: 3, models: []Model{Max}}},
+	"Air": {
+		{args: []string{"In", ""}, min: 1, max: 3, models: []Model{Max}},
+		{args: []string{"Out", ""}, min: 1, max: 3, models: []Model{Max}},
+	},
+	"Light":      {{args: []string{"on"}}, {args: []string{"off"}}},
+	"Battery":    {{}},
+	"DeviceType": {{}},
+	"Status":     {{args: []string{""}, min: 1, max: 1}},
+	"PowerOff":   {{}},
+}
+
+// Validate checks that the command is known, its arguments are valid and the
+// model supports it. All models are accepted for Unknown.
+func (c Command) Validate(m Model) error {
+	specs, ok := specs[c.Name]
+	if !ok {
+		return ErrUnknownCommand
+	}
+	for _, s := range specs {
+		if !s.matchArgs(c.Args) {
+			continue
+		}
+		if !s.supports(m) {
+			return ErrUnsupported
+		}
+		if !s.inRange(c.Args) {
+			return ErrRange
+		}
+		return nil
+	}
+	return ErrArgs
+}
+
+// MatchArgs reports whether the fixed arguments match and the levels are
+// numbers.
+func (s spec) matchArgs(args []string) bool {
+	if len(args) != len(s.args) {
+		return false
+	}
+	for i, a := range s.args {
+		if a == "" {
+			if _, err := strconv.Atoi(args[i]); err != nil {
+				return false
+			}
+		} else if a != args[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// InRange reports whether all levels are in range.
+func (s spec) inRange(args []string) bool {
+	for i, a := range s.args {
+		if a != "" {
+			continue
+		}
+		if n, _ := strconv.Atoi(args[i]); n < s.min || n > s.max {
+			return false
+		}
+	}
+	return true
+}
+
+// Supports reports whether the model supports the command.
+func (s spec) supports(m Model) bool {
+	if m == Unknown || s.models == nil {
+		return true
+	}
+	for _, v := range s.models {
+		if v == m {
+			return true
+		}
+	}
+	return false
+}
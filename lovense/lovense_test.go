@@ -0,0 +1,142 @@
+package lovense
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCommandString(t *testing.T) {
+	for _, tc := range []struct {
+		Cmd  Command
+		Want string
+	}{
+		{Vibrate(10), "Vibrate:10;"},
+		{VibrateMotor(2, 5), "Vibrate2:5;"},
+		{Rotate(20), "Rotate:20;"},
+		{RotateChange(), "RotateChange;"},
+		{AirAuto(3), "AirAuto:3;"},
+		{AirIn(1), "Air:In:1;"},
+		{AirOut(2), "Air:Out:2;"},
+		{Light(false), "Light:off;"},
+		{Battery(), "Battery;"},
+		{DeviceType(), "DeviceType;"},
+		{Status(), "Status:1;"},
+		{PowerOff(), "PowerOff;"},
+	} {
+		if got := tc.Cmd.String(); got != tc.Want {
+			t.Errorf("got %q, want %q", got, tc.Want)
+		}
+		c, err := Parse(tc.Want)
+		if err != nil {
+			t.Errorf("%s: %v", tc.Want, err)
+		} else if !reflect.DeepEqual(c.String(), tc.Want) {
+			t.Errorf("%s: parsed as %+v", tc.Want, c)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, s := range []string{
+		"",
+		";",
+		"Vibrate:10",
+		"Vibrate 10;",
+		"Vibrate:;",
+		"Vibrate::10;",
+		":10;",
+		"1Vibrate;",
+		"Vibrate:-1;",
+		"Vibrate:10;;",
+	} {
+		if _, err := Parse(s); err != ErrSyntax {
+			t.Errorf("%q: got %v, want %v", s, err, ErrSyntax)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		Cmd   Command
+		Model Model
+		Err   error
+	}{
+		{Vibrate(0), Lush, nil},
+		{Vibrate(20), Unknown, nil},
+		{Vibrate(21), Lush, ErrRange},
+		{Vibrate(-1), Hush, ErrRange},
+		{VibrateMotor(1, 10), Edge, nil},
+		{VibrateMotor(1, 10), Lush, ErrUnsupported},
+		{VibrateMotor(3, 10), Edge, ErrUnknownCommand},
+		{Rotate(20), Nora, nil},
+		{Rotate(10), Max, ErrUnsupported},
+		{Rotate(21), Nora, ErrRange},
+		{RotateChange(), Nora, nil},
+		{RotateChange(), Unknown, nil},
+		{RotateChange(), Domi, ErrUnsupported},
+		{AirAuto(3), Max, nil},
+		{AirAuto(4), Max, ErrRange},
+		{AirIn(0), Max, ErrRange},
+		{AirOut(3), Max, nil},
+		{AirIn(1), Nora, ErrUnsupported},
+		{Command{Name: "Air", Args: []string{"Up", "1"}}, Max, ErrArgs},
+		{Command{Name: "Vibrate"}, Lush, ErrArgs},
+		{Command{Name: "Vibrate", Args: []string{"high"}}, Lush, ErrArgs},
+		{Command{Name: "Battery", Args: []string{"1"}}, Lush, ErrArgs},
+		{Command{Name: "Vibrte", Args: []string{"1"}}, Lush, ErrUnknownCommand},
+		{Light(true), Osci, nil},
+		{Battery(), Ambi, nil},
+		{Status(), Lush, nil},
+		{PowerOff(), Lush, nil},
+	} {
+		if err := tc.Cmd.Validate(tc.Model); err != tc.Err {
+			t.Errorf("%s on %s: got %v, want %v", tc.Cmd, tc.Model, err, tc.Err)
+		}
+	}
+}
+
+func TestModelFromName(t *testing.T) {
+	for name, want := range map[string]Model{
+		"Lovense Nora":   Nora,
+		"LOVENSE MAX":    Max,
+		"Lovense Edge 2": Edge,
+		"Lovense":        Unknown,
+		"Launch":         Unknown,
+		"Maximus":        Unknown,
+	} {
+		if got := ModelFromName(name); got != want {
+			t.Errorf("%q: got %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestParseResponses(t *testing.T) {
+	if err := ParseOK("OK;"); err != nil {
+		t.Error(err)
+	}
+	if err := ParseOK("ERR;"); err != ErrResponse {
+		t.Errorf("got %v, want %v", err, ErrResponse)
+	}
+	for resp, want := range map[string]int{"85;": 85, "s42;": 42, "0;": 0} {
+		if got, err := ParseBattery(resp); err != nil || got != want {
+			t.Errorf("battery %q: got %d, %v, want %d", resp, got, err, want)
+		}
+	}
+	for _, resp := range []string{"85", "101;", "ERR;", "x;", ";"} {
+		if _, err := ParseBattery(resp); err != ErrResponse {
+			t.Errorf("battery %q: got %v, want %v", resp, err, ErrResponse)
+		}
+	}
+	if got, err := ParseStatus("2;"); err != nil || got != 2 {
+		t.Errorf("status: got %d, %v", got, err)
+	}
+	info, err := ParseDeviceType("C:11:0082059AD3BD;")
+	want := Info{Model: Nora, Type: "C", Firmware: 11, Address: "0082059AD3BD"}
+	if err != nil || info != want {
+		t.Errorf("device type: got %+v, %v, want %+v", info, err, want)
+	}
+	for _, resp := range []string{"C:11;", "C:x:0082059AD3BD;", ":11:0082059AD3BD;", "ERR;"} {
+		if _, err := ParseDeviceType(resp); err != ErrResponse {
+			t.Errorf("device type %q: got %v, want %v", resp, err, ErrResponse)
+		}
+	}
+}
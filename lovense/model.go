@@ -0,0 +1,69 @@
+package lovense
+
+import "strings"
+
+// Model is a Lovense toy model.
+type Model int
+
+// Lovense toy models.
+const (
+	Unknown Model = iota
+	Lush
+	Hush
+	Nora
+	Max
+	Ambi
+	Edge
+	Domi
+	Osci
+)
+
+var modelNames = map[Model]string{
+	Unknown: "Unknown",
+	Lush:    "Lush",
+	Hush:    "Hush",
+	Nora:    "Nora",
+	Max:     "Max",
+	Ambi:    "Ambi",
+	Edge:    "Edge",
+	Domi:    "Domi",
+	Osci:    "Osci",
+}
+
+// modelTypes are the models by the type letter in a DeviceType response.
+var modelTypes = map[string]Model{
+	"S": Lush,
+	"Z": Hush,
+	"A": Nora,
+	"C": Nora,
+	"B": Max,
+	"L": Ambi,
+	"P": Edge,
+	"W": Domi,
+	"O": Osci,
+}
+
+func (m Model) String() string {
+	if s, ok := modelNames[m]; ok {
+		return s
+	}
+	return "Unknown"
+}
+
+// ModelFromName returns the model of a device by its name, eg: "Lovense
+// Nora". Unknown is returned when the name contains no model.
+func ModelFromName(name string) Model {
+	for _, w := range strings.Fields(strings.ToLower(name)) {
+		for m := Lush; m <= Osci; m++ {
+			if w == strings.ToLower(modelNames[m]) {
+				return m
+			}
+		}
+	}
+	return Unknown
+}
+
+// ModelFromType returns the model of a type letter in a DeviceType response.
+func ModelFromType(t string) Model {
+	return modelTypes[strings.ToUpper(t)]
+}
@@ -0,0 +1,85 @@
+package lovense
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Info is the response to the DeviceType command.
+type Info struct {
+	// Model of the toy.
+	Model Model
+	// Type letter of the model.
+	Type string
+	// Firmware version.
+	Firmware int
+	// Address is the bluetooth address of the toy.
+	Address string
+}
+
+// ParseOK returns ErrResponse unless the response is "OK;".
+func ParseOK(resp string) error {
+	if resp != "OK;" {
+		return ErrResponse
+	}
+	return nil
+}
+
+// ParseBattery parses the response to the Battery command, eg: "85;". Toys
+// that are running prefix the level with "s".
+func ParseBattery(resp string) (int, error) {
+	v, err := value(resp)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(v, "s"))
+	if err != nil || n < 0 || n > 100 {
+		return 0, ErrResponse
+	}
+	return n, nil
+}
+
+// ParseStatus parses the response to the Status command, eg: "2;". Status 2
+// means the toy is working normally.
+func ParseStatus(resp string) (int, error) {
+	v, err := value(resp)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, ErrResponse
+	}
+	return n, nil
+}
+
+// ParseDeviceType parses the response to the DeviceType command, eg:
+// "C:11:0082059AD3BD;".
+func ParseDeviceType(resp string) (Info, error) {
+	v, err := value(resp)
+	if err != nil {
+		return Info{}, err
+	}
+	parts := strings.Split(v, ":")
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return Info{}, ErrResponse
+	}
+	fw, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return Info{}, ErrResponse
+	}
+	return Info{
+		Model:    ModelFromType(parts[0]),
+		Type:     parts[0],
+		Firmware: fw,
+		Address:  parts[2],
+	}, nil
+}
+
+// Value returns the response without the trailing semicolon.
+func value(resp string) (string, error) {
+	if !strings.HasSuffix(resp, ";") || resp == "ERR;" {
+		return "", ErrResponse
+	}
+	return strings.TrimSuffix(resp, ";"), nil
+}
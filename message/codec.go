@@ -0,0 +1,672 @@
+package message
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// AppendOutgoing appends the JSON encoding of a frame of messages to dst and
+// returns the extended buffer. Known message types are encoded without
+// reflection, the output is the same as json.Marshal. Reusing dst between
+// frames avoids allocations.
+func AppendOutgoing(dst []byte, msgs OutgoingMessages) ([]byte, error) {
+	if msgs == nil {
+		return append(dst, "null"...), nil
+	}
+	dst = append(dst, '[')
+	for i := range msgs {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		var err error
+		if dst, err = appendOutgoing(dst, &msgs[i]); err != nil {
+			return dst, err
+		}
+	}
+	return append(dst, ']'), nil
+}
+
+// AppendOutgoing appends the JSON encoding of a single message. Messages
+// that do not contain exactly one known message are encoded by
+// encoding/json.
+func appendOutgoing(dst []byte, m *OutgoingMessage) ([]byte, error) {
+	t := m.single()
+	if m.Custom != nil || t == nil {
+		b, err := json.Marshal(m)
+		return append(dst, b...), err
+	}
+	dst, err := t.append(appendKey(append(dst, '{'), t.name), m)
+	return append(dst, '}'), err
+}
+
+// Single returns the type of the known message when exactly one is set.
+func (m *OutgoingMessage) single() *outgoingType {
+	var t *outgoingType
+	for i := range outgoingTypes {
+		if outgoingTypes[i].field(*m).isNil() {
+			continue
+		}
+		if t != nil {
+			return nil
+		}
+		t = &outgoingTypes[i]
+	}
+	return t
+}
+
+func appendEmpty(dst []byte, m *Empty) []byte {
+	dst = appendUint(append(dst, `{"Id":`...), m.ID)
+	return append(dst, '}')
+}
+
+func appendTest(dst []byte, m *Test) []byte {
+	dst = appendUint(append(dst, `{"Id":`...), m.ID)
+	dst = appendString(append(dst, `,"TestString":`...), m.TestString)
+	return append(dst, '}')
+}
+
+func appendRequestLog(dst []byte, m *RequestLog) []byte {
+	dst = appendUint(append(dst, `{"Id":`...), m.ID)
+	dst = appendString(append(dst, `,"LogLevel":`...), m.LogLevel)
+	return append(dst, '}')
+}
+
+func appendRequestServerInfo(dst []byte, m *RequestServerInfo) []byte {
+	dst = appendUint(append(dst, `{"Id":`...), m.ID)
+	dst = appendString(append(dst, `,"ClientName":`...), m.ClientName)
+	return append(dst, '}')
+}
+
+func appendDevice(dst []byte, m *Device) []byte {
+	dst = append(dst, '{')
+	if m.ID != 0 {
+		dst = append(appendUint(append(dst, `"Id":`...), m.ID), ',')
+	}
+	dst = appendString(append(dst, `"DeviceName":`...), m.DeviceName)
+	dst = appendUint(append(dst, `,"DeviceIndex":`...), m.DeviceIndex)
+	if len(m.DeviceMessages) > 0 {
+		dst = append(dst, `,"DeviceMessages":[`...)
+		for i, s := range m.DeviceMessages {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = appendString(dst, s)
+		}
+		dst = append(dst, ']')
+	}
+	return append(dst, '}')
+}
+
+func appendRawCmd(dst []byte, m *RawCmd) []byte {
+	dst = appendUint(append(dst, `{"Id":`...), m.ID)
+	dst = appendUint(append(dst, `,"DeviceIndex":`...), m.DeviceIndex)
+	dst = append(dst, `,"Command":`...)
+	if m.Command == nil {
+		dst = append(dst, "null"...)
+	} else {
+		n := len(dst) + 1
+		dst = append(dst, make([]byte, base64.StdEncoding.EncodedLen(len(m.Command))+2)...)
+		base64.StdEncoding.Encode(dst[n:], m.Command)
+		dst[n-1], dst[len(dst)-1] = '"', '"'
+	}
+	return append(dst, '}')
+}
+
+func appendSingleMotorVibrateCmd(dst []byte, m *SingleMotorVibrateCmd) ([]byte, error) {
+	dst = appendUint(append(dst, `{"Id":`...), m.ID)
+	dst = appendUint(append(dst, `,"DeviceIndex":`...), m.DeviceIndex)
+	dst, err := appendFloat(append(dst, `,"Speed":`...), m.Speed)
+	return append(dst, '}'), err
+}
+
+func appendKiirooCmd(dst []byte, m *KiirooCmd) []byte {
+	dst = appendUint(append(dst, `{"Id":`...), m.ID)
+	dst = appendUint(append(dst, `,"DeviceIndex":`...), m.DeviceIndex)
+	dst = strconv.AppendInt(append(dst, `,"Command":`...), int64(m.Command), 10)
+	return append(dst, '}')
+}
+
+func appendFleshlightLaunchFW12Cmd(dst []byte, m *FleshlightLaunchFW12Cmd) []byte {
+	dst = appendUint(append(dst, `{"Id":`...), m.ID)
+	dst = appendUint(append(dst, `,"DeviceIndex":`...), m.DeviceIndex)
+	dst = strconv.AppendInt(append(dst, `,"Position":`...), int64(m.Position), 10)
+	dst = strconv.AppendInt(append(dst, `,"Speed":`...), int64(m.Speed), 10)
+	return append(dst, '}')
+}
+
+func appendLovenseCmd(dst []byte, m *LovenseCmd) []byte {
+	dst = appendUint(append(dst, `{"Id":`...), m.ID)
+	dst = appendUint(append(dst, `,"DeviceIndex":`...), m.DeviceIndex)
+	dst = appendString(append(dst, `,"Command":`...), m.Command)
+	return append(dst, '}')
+}
+
+func appendVorzeA10CycloneCmd(dst []byte, m *VorzeA10CycloneCmd) []byte {
+	dst = appendUint(append(dst, `{"Id":`...), m.ID)
+	dst = appendUint(append(dst, `,"DeviceIndex":`...), m.DeviceIndex)
+	dst = strconv.AppendInt(append(dst, `,"Speed":`...), int64(m.Speed), 10)
+	dst = strconv.AppendBool(append(dst, `,"Clockwise":`...), m.Clockwise)
+	return append(dst, '}')
+}
+
+// AppendKey appends a quoted object key followed by a colon.
+func appendKey(dst []byte, key string) []byte {
+	dst = append(dst, '"')
+	dst = append(dst, key...)
+	return append(dst, '"', ':')
+}
+
+func appendUint(dst []byte, v uint32) []byte {
+	return strconv.AppendUint(dst, uint64(v), 10)
+}
+
+// AppendFloat formats a float the same way as encoding/json.
+func appendFloat(dst []byte, f float64) ([]byte, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return dst, &json.UnsupportedValueError{
+			Str: strconv.FormatFloat(f, 'g', -1, 64),
+		}
+	}
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	n := len(dst)
+	dst = strconv.AppendFloat(dst, f, format, -1, 64)
+	if format == 'e' {
+		// Clean up e-09 to e-9.
+		if m := len(dst); m-n >= 4 && dst[m-4] == 'e' && dst[m-3] == '-' && dst[m-2] == '0' {
+			dst[m-2] = dst[m-1]
+			dst = dst[:m-1]
+		}
+	}
+	return dst, nil
+}
+
+const hex = "0123456789abcdef"
+
+// AppendString appends a quoted string escaped the same way as
+// encoding/json.
+func appendString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				dst = append(dst, '\\', b)
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hex[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
+
+// DecodeIncoming decodes a frame of messages send by a server. Frames that
+// contain only known message types are decoded without reflection, other
+// frames are decoded by encoding/json.
+func decodeIncoming(frame []byte) (IncomingMessages, error) {
+	s := scanner{b: frame}
+	if msgs := s.incomingMessages(); !s.fail {
+		return msgs, nil
+	}
+	var msgs IncomingMessages
+	if err := json.Unmarshal(frame, &msgs); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// scanner is a JSON decoder for the known incoming message types. It only
+// accepts the subset of JSON that encoding/json decodes the same way; on
+// anything else fail is set and the frame must be decoded by
+// encoding/json.
+type scanner struct {
+	b    []byte
+	i    int
+	fail bool
+}
+
+func (s *scanner) incomingMessages() IncomingMessages {
+	var msgs IncomingMessages
+	s.ws()
+	if !s.consume('[') {
+		return nil
+	}
+	for n := 0; s.next(']', n); n++ {
+		msgs = append(msgs, IncomingMessage{})
+		s.incomingMessage(&msgs[len(msgs)-1])
+	}
+	s.ws()
+	if s.i != len(s.b) {
+		s.fail = true
+	}
+	if msgs == nil {
+		msgs = IncomingMessages{}
+	}
+	return msgs
+}
+
+func (s *scanner) incomingMessage(m *IncomingMessage) {
+	if !s.consume('{') {
+		return
+	}
+	for n := 0; s.next('}', n); n++ {
+		if t := incomingByName[string(s.key())]; t != nil {
+			t.scan(s, m)
+		} else {
+			s.fail = true
+		}
+	}
+}
+
+func (s *scanner) empty(m *Empty) {
+	if !s.consume('{') {
+		return
+	}
+	for n := 0; s.next('}', n); n++ {
+		switch string(s.key()) {
+		case "Id":
+			m.ID = s.uint32()
+		default:
+			s.fail = true
+		}
+	}
+}
+
+func (s *scanner) error(m *Error) {
+	if !s.consume('{') {
+		return
+	}
+	for n := 0; s.next('}', n); n++ {
+		switch string(s.key()) {
+		case "Id":
+			m.ID = s.uint32()
+		case "ErrorMessage":
+			m.ErrorMessage = s.string()
+		default:
+			s.fail = true
+		}
+	}
+}
+
+func (s *scanner) test(m *Test) {
+	if !s.consume('{') {
+		return
+	}
+	for n := 0; s.next('}', n); n++ {
+		switch string(s.key()) {
+		case "Id":
+			m.ID = s.uint32()
+		case "TestString":
+			m.TestString = s.string()
+		default:
+			s.fail = true
+		}
+	}
+}
+
+func (s *scanner) log(m *Log) {
+	if !s.consume('{') {
+		return
+	}
+	for n := 0; s.next('}', n); n++ {
+		switch string(s.key()) {
+		case "Id":
+			m.ID = s.uint32()
+		case "LogLevel":
+			m.LogLevel = s.string()
+		case "LogMessage":
+			m.LogMessage = s.string()
+		default:
+			s.fail = true
+		}
+	}
+}
+
+func (s *scanner) serverInfo(m *ServerInfo) {
+	if !s.consume('{') {
+		return
+	}
+	for n := 0; s.next('}', n); n++ {
+		switch string(s.key()) {
+		case "Id":
+			m.ID = s.uint32()
+		case "ServerName":
+			m.ServerName = s.string()
+		case "MessageVersion":
+			m.MessageVersion = s.uint32()
+		case "MajorVersion":
+			m.MajorVersion = s.uint32()
+		case "MinorVersion":
+			m.MinorVersion = s.uint32()
+		case "BuildVersion":
+			m.BuildVersion = s.uint32()
+		case "MaxPingTime":
+			m.MaxPingTime = s.uint32()
+		default:
+			s.fail = true
+		}
+	}
+}
+
+func (s *scanner) deviceList(m *DeviceList) {
+	if !s.consume('{') {
+		return
+	}
+	for n := 0; s.next('}', n); n++ {
+		switch string(s.key()) {
+		case "Id":
+			m.ID = s.uint32()
+		case "Devices":
+			if !s.consume('[') {
+				return
+			}
+			m.Devices = []Device{}
+			for n := 0; s.next(']', n); n++ {
+				var d Device
+				s.device(&d)
+				m.Devices = append(m.Devices, d)
+			}
+		default:
+			s.fail = true
+		}
+	}
+}
+
+func (s *scanner) device(m *Device) {
+	if !s.consume('{') {
+		return
+	}
+	for n := 0; s.next('}', n); n++ {
+		switch string(s.key()) {
+		case "Id":
+			m.ID = s.uint32()
+		case "DeviceName":
+			m.DeviceName = s.string()
+		case "DeviceIndex":
+			m.DeviceIndex = s.uint32()
+		case "DeviceMessages":
+			if !s.consume('[') {
+				return
+			}
+			m.DeviceMessages = []string{}
+			for n := 0; s.next(']', n); n++ {
+				m.DeviceMessages = append(m.DeviceMessages, s.string())
+			}
+		default:
+			s.fail = true
+		}
+	}
+}
+
+// Ws skips white space.
+func (s *scanner) ws() {
+	for s.i < len(s.b) {
+		switch s.b[s.i] {
+		case ' ', '\t', '\n', '\r':
+			s.i++
+		default:
+			return
+		}
+	}
+}
+
+// Consume skips white space and the expected character.
+func (s *scanner) consume(c byte) bool {
+	if s.fail {
+		return false
+	}
+	s.ws()
+	if s.i >= len(s.b) || s.b[s.i] != c {
+		s.fail = true
+		return false
+	}
+	s.i++
+	return true
+}
+
+// Next reports whether the array or object has another element. N is the
+// number of elements read so far, end is the closing character.
+func (s *scanner) next(end byte, n int) bool {
+	if s.fail {
+		return false
+	}
+	s.ws()
+	if s.i < len(s.b) && s.b[s.i] == end {
+		s.i++
+		return false
+	}
+	if n > 0 {
+		return s.consume(',')
+	}
+	return true
+}
+
+// Key reads an object key and the colon that follows it. Keys are matched
+// exactly, keys with escapes are not supported.
+func (s *scanner) key() []byte {
+	if !s.consume('"') {
+		return nil
+	}
+	start := s.i
+	for s.i < len(s.b) {
+		switch c := s.b[s.i]; {
+		case c == '"':
+			k := s.b[start:s.i]
+			s.i++
+			s.consume(':')
+			return k
+		case c == '\\' || c < 0x20 || c >= utf8.RuneSelf:
+			s.fail = true
+			return nil
+		}
+		s.i++
+	}
+	s.fail = true
+	return nil
+}
+
+// Uint32 reads an unsigned integer.
+func (s *scanner) uint32() uint32 {
+	if s.fail {
+		return 0
+	}
+	s.ws()
+	start := s.i
+	var v uint64
+	for s.i < len(s.b) && s.b[s.i] >= '0' && s.b[s.i] <= '9' {
+		v = v*10 + uint64(s.b[s.i]-'0')
+		s.i++
+		if v > math.MaxUint32 {
+			s.fail = true
+			return 0
+		}
+	}
+	n := s.i - start
+	if n == 0 || (n > 1 && s.b[start] == '0') {
+		s.fail = true
+		return 0
+	}
+	if s.i < len(s.b) {
+		switch s.b[s.i] {
+		case '.', 'e', 'E':
+			s.fail = true
+			return 0
+		}
+	}
+	return uint32(v)
+}
+
+// String reads a string value.
+func (s *scanner) string() string {
+	if !s.consume('"') {
+		return ""
+	}
+	start := s.i
+	escaped := false
+	for s.i < len(s.b) {
+		c := s.b[s.i]
+		switch {
+		case c == '"':
+			raw := s.b[start:s.i]
+			s.i++
+			if !escaped {
+				if !utf8.Valid(raw) {
+					s.fail = true
+					return ""
+				}
+				return string(raw)
+			}
+			return s.unquote(raw)
+		case c == '\\':
+			escaped = true
+			s.i += 2
+			continue
+		case c < 0x20:
+			s.fail = true
+			return ""
+		}
+		s.i++
+	}
+	s.fail = true
+	return ""
+}
+
+// Unquote decodes the escapes in a string. Surrogates are not supported.
+func (s *scanner) unquote(raw []byte) string {
+	if !utf8.Valid(raw) {
+		s.fail = true
+		return ""
+	}
+	b := make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		if c != '\\' {
+			b = append(b, c)
+			continue
+		}
+		i++
+		if i >= len(raw) {
+			s.fail = true
+			return ""
+		}
+		switch raw[i] {
+		case '"', '\\', '/':
+			b = append(b, raw[i])
+		case 'b':
+			b = append(b, '\b')
+		case 'f':
+			b = append(b, '\f')
+		case 'n':
+			b = append(b, '\n')
+		case 'r':
+			b = append(b, '\r')
+		case 't':
+			b = append(b, '\t')
+		case 'u':
+			if i+4 >= len(raw) {
+				s.fail = true
+				return ""
+			}
+			r, err := strconv.ParseUint(string(raw[i+1:i+5]), 16, 16)
+			if err != nil || (r >= 0xD800 && r < 0xE000) {
+				s.fail = true
+				return ""
+			}
+			b = append(b, string(rune(r))...)
+			i += 4
+		default:
+			s.fail = true
+			return ""
+		}
+	}
+	return string(b)
+}
+
+// nextReader is implemented by connections that can read a frame into a
+// buffer, like *websocket.Conn.
+type nextReader interface {
+	NextReader() (messageType int, r io.Reader, err error)
+}
+
+// FrameReader reads frames from a connection into a reused buffer. The
+// returned frame is only valid until the next read.
+type frameReader struct {
+	conn Conn
+	buf  bytes.Buffer
+}
+
+func (r *frameReader) read() ([]byte, error) {
+	nr, ok := r.conn.(nextReader)
+	if !ok {
+		_, p, err := r.conn.ReadMessage()
+		return p, err
+	}
+	_, rd, err := nr.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	r.buf.Reset()
+	if _, err := r.buf.ReadFrom(rd); err != nil {
+		return nil, err
+	}
+	return r.buf.Bytes(), nil
+}
+
+// FrameWriter writes frames of messages to a connection from a reused
+// buffer.
+type frameWriter struct {
+	conn Conn
+	buf  []byte
+}
+
+func (w *frameWriter) write(m OutgoingMessage) error {
+	var err error
+	w.buf = append(w.buf[:0], '[')
+	if w.buf, err = appendOutgoing(w.buf, &m); err != nil {
+		return err
+	}
+	w.buf = append(w.buf, ']')
+	return w.conn.WriteMessage(websocket.TextMessage, w.buf)
+}
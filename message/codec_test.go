@@ -0,0 +1,157 @@
+package message
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestAppendOutgoing(t *testing.T) {
+	frames := []OutgoingMessages{
+		nil,
+		{},
+		{{Custom: &Typed{Name: "VendorCmd", Value: &VendorCmd{ID: 1}}}},
+		{{Ping: &Empty{ID: 1}, Test: &Test{ID: 2}}},
+		{{}},
+		{
+			{RequestServerInfo: &RequestServerInfo{ID: 1, ClientName: "a \"quoted\" <name> & \\ \n\t\x01   é \xff"}},
+			{StopDeviceCmd: &Device{DeviceName: "dev", DeviceMessages: []string{"A", "B"}}},
+			{StopDeviceCmd: &Device{ID: 3, DeviceMessages: []string{}}},
+			{RawCmd: &RawCmd{ID: 4, Command: []byte{}}},
+			{RawCmd: &RawCmd{ID: 4}},
+			{SingleMotorVibrateCmd: &SingleMotorVibrateCmd{ID: 5, Speed: 1e-7}},
+			{SingleMotorVibrateCmd: &SingleMotorVibrateCmd{ID: 5, Speed: -1e21}},
+			{SingleMotorVibrateCmd: &SingleMotorVibrateCmd{ID: 5, Speed: 0.333}},
+			{KiirooCmd: &KiirooCmd{ID: 6, Command: -1}},
+			{FleshlightLaunchFW12Cmd: &FleshlightLaunchFW12Cmd{ID: math.MaxUint32, Position: 99, Speed: 20}},
+			{VorzeA10CycloneCmd: &VorzeA10CycloneCmd{ID: 7, Speed: 50, Clockwise: true}},
+		},
+	}
+	for _, c := range OutgoingJSONCases {
+		frames = append(frames, c.Msgs)
+	}
+	for _, msgs := range frames {
+		want, err := json.Marshal(msgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := AppendOutgoing([]byte("prefix"), msgs)
+		if err != nil {
+			t.Errorf("error encoding %s: %v", want, err)
+		} else if string(got) != "prefix"+string(want) {
+			t.Errorf("got %s, want prefix%s", got, want)
+		}
+	}
+	_, err := AppendOutgoing(nil, OutgoingMessages{
+		{SingleMotorVibrateCmd: &SingleMotorVibrateCmd{Speed: math.NaN()}},
+	})
+	if err == nil {
+		t.Errorf("NaN speed encoded")
+	}
+}
+
+func TestDecodeIncoming(t *testing.T) {
+	for _, c := range IncomingJSONCases {
+		s := scanner{b: []byte(c.JSON)}
+		msgs := s.incomingMessages()
+		if s.fail {
+			t.Errorf("case %s: not decoded by scanner", c.Name)
+		} else if !msgs.Equals(c.Msgs) {
+			t.Errorf("case %s: got %+v", c.Name, msgs)
+		}
+	}
+	for _, frame := range []string{
+		`[]`,
+		` [ { "Ok" : { "Id" : 0 } } ] `,
+		`[{"Ok":{"Id":1},"Error":{"Id":1,"ErrorMessage":"both"}}]`,
+		`[{"Error":{"Id":1,"ErrorMessage":"esc \" \\ \/ \b \f \n \r \t é  "}}]`,
+		`[{"DeviceList":{"Id":1,"Devices":[]}}]`,
+		`[{"DeviceAdded":{"Id":0,"DeviceName":"","DeviceIndex":1,"DeviceMessages":[]}}]`,
+		// Frames decoded by encoding/json.
+		`null`,
+		`[{"ok":{"id":1}}]`,
+		`[{"Ok":null}]`,
+		`[{"Ok":{"Id":1,"Extra":true}}]`,
+		`[{"RotateCmd":{"Id":1}}]`,
+		`[{"Error":{"Id":1,"ErrorMessage":"😀 \ud800"}}]`,
+		"[{\"Error\":{\"Id\":1,\"ErrorMessage\":\"\xff\"}}]",
+		// Invalid frames.
+		``,
+		`[`,
+		`[{"Ok":{"Id":1}}`,
+		`[{"Ok":{"Id":1}},]`,
+		`[{"Ok":{"Id":1}}] x`,
+		`[{"Ok":{"Id":01}}]`,
+		`[{"Ok":{"Id":-1}}]`,
+		`[{"Ok":{"Id":1.5}}]`,
+		`[{"Ok":{"Id":4294967296}}]`,
+		`[{"Ok":{,"Id":1}}]`,
+		`[{"Ok":{"Id":1 "Id":2}}]`,
+		"[{\"Error\":{\"Id\":1,\"ErrorMessage\":\"\n\"}}]",
+		`[{"Error":{"Id":1,"ErrorMessage":"\x"}}]`,
+	} {
+		var want IncomingMessages
+		wantErr := json.Unmarshal([]byte(frame), &want)
+		got, err := decodeIncoming([]byte(frame))
+		if (err != nil) != (wantErr != nil) {
+			t.Errorf("%s: got error %v, want %v", frame, err, wantErr)
+		} else if err == nil && !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", frame, got, want)
+		}
+	}
+}
+
+var benchOutgoing = OutgoingMessages{
+	{
+		FleshlightLaunchFW12Cmd: &FleshlightLaunchFW12Cmd{
+			ID:          1234,
+			DeviceIndex: 2,
+			Position:    80,
+			Speed:       40,
+		},
+	},
+}
+
+var benchIncoming = []byte(`[{"Ok":{"Id":1234}}]`)
+
+func BenchmarkEncodeOutgoing(b *testing.B) {
+	b.Run("json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := json.Marshal(benchOutgoing); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("codec", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			var err error
+			if buf, err = AppendOutgoing(buf[:0], benchOutgoing); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkDecodeIncoming(b *testing.B) {
+	b.Run("json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var msgs IncomingMessages
+			if err := json.Unmarshal(benchIncoming, &msgs); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("codec", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := decodeIncoming(benchIncoming); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
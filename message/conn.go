@@ -0,0 +1,11 @@
+package message
+
+// Conn is the websocket connection used to exchange messages with a Buttplug
+// server. It is implemented by *websocket.Conn.
+type Conn interface {
+	ReadJSON(v interface{}) error
+	WriteJSON(v interface{}) error
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	Close() error
+}
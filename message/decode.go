@@ -0,0 +1,280 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Unknown is a message of a type that is not known by this package. It is
+// kept so newer server messages can be inspected.
+type Unknown struct {
+	// Name of the message type.
+	Name string
+	// ID of the message, 0 when the message has no Id.
+	ID uint32 `json:"Id"`
+	// Data is the JSON encoded message.
+	Data json.RawMessage
+}
+
+// incomingMessage is an IncomingMessage without the JSON methods.
+type incomingMessage IncomingMessage
+
+// UnmarshalJSON decodes a message. A message of a registered type without a
+// field is stored in Custom, a message of an unknown type in Unknown.
+func (m *IncomingMessage) UnmarshalJSON(b []byte) error {
+	var im incomingMessage
+	if err := json.Unmarshal(b, &im); err != nil {
+		return err
+	}
+	*m = IncomingMessage(im)
+	if _, v := m.Message(); v != nil {
+		return nil
+	}
+	name, data, err := extraField(b, isIncomingType)
+	if err != nil || name == "" {
+		return err
+	}
+	e, err := decodeTyped(name, data)
+	if err != nil {
+		return err
+	}
+	switch e := e.(type) {
+	case *Typed:
+		m.Custom = e
+	case *Unknown:
+		m.Unknown = e
+	}
+	return nil
+}
+
+// MarshalJSON encodes a message including a custom message or a message of
+// an unknown type.
+func (m IncomingMessage) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(incomingMessage(m))
+	if err != nil {
+		return nil, err
+	}
+	if m.Custom != nil {
+		return mergeField(b, m.Custom.Name, m.Custom.Value)
+	}
+	if m.Unknown != nil {
+		return mergeField(b, m.Unknown.Name, m.Unknown.withID())
+	}
+	return b, nil
+}
+
+// outgoingMessage is an OutgoingMessage without the JSON methods.
+type outgoingMessage OutgoingMessage
+
+// UnmarshalJSON decodes a message. A message of a registered type without a
+// field is stored in Custom, a message of an unknown type is ignored.
+func (m *OutgoingMessage) UnmarshalJSON(b []byte) error {
+	var om outgoingMessage
+	if err := json.Unmarshal(b, &om); err != nil {
+		return err
+	}
+	*m = OutgoingMessage(om)
+	if _, v := m.Message(); v != nil {
+		return nil
+	}
+	name, data, err := extraField(b, isOutgoingType)
+	if err != nil || name == "" {
+		return err
+	}
+	if e, err := decodeTyped(name, data); err != nil {
+		return err
+	} else if t, ok := e.(*Typed); ok {
+		m.Custom = t
+	}
+	return nil
+}
+
+// MarshalJSON encodes a message including a custom message.
+func (m OutgoingMessage) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(outgoingMessage(m))
+	if err != nil || m.Custom == nil {
+		return b, err
+	}
+	return mergeField(b, m.Custom.Name, m.Custom.Value)
+}
+
+// extraField returns the first message type, and its data, of JSON object b
+// that is not one of the known types.
+func extraField(b []byte, known func(name string) bool) (string, json.RawMessage, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return "", nil, err
+	}
+	for _, typ := range sortedKeys(raw) {
+		if !known(typ) {
+			return typ, raw[typ], nil
+		}
+	}
+	return "", nil, nil
+}
+
+// IsIncomingType reports whether name is a built-in incoming message type.
+func isIncomingType(name string) bool {
+	return incomingByName[name] != nil
+}
+
+// IsOutgoingType reports whether name is a built-in outgoing message type.
+func isOutgoingType(name string) bool {
+	return outgoingByName[name] != nil
+}
+
+// mergeField adds a field to the JSON object b.
+func mergeField(b []byte, name string, v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, err
+	}
+	obj[name] = data
+	return json.Marshal(obj)
+}
+
+// withID returns the data with the id of the message.
+func (u Unknown) withID() json.RawMessage {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(u.Data, &obj); err != nil || obj == nil {
+		return u.Data
+	}
+	if _, ok := obj["Id"]; !ok && u.ID == 0 {
+		return u.Data
+	}
+	obj["Id"] = json.RawMessage(fmt.Sprint(u.ID))
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return u.Data
+	}
+	return b
+}
+
+// InvalidMessageError is returned by a Decoder when a message is rejected.
+type InvalidMessageError struct {
+	// Index of the message in the frame.
+	Index int
+	// Err describes why the message is invalid.
+	Err error
+}
+
+func (e *InvalidMessageError) Error() string {
+	return fmt.Sprintf("invalid message %d: %v", e.Index, e.Err)
+}
+
+// Decoder decodes frames of messages. The zero value decodes the same as
+// json.Unmarshal, but frames with known incoming message types are decoded
+// without reflection.
+type Decoder struct {
+	// Strict rejects messages that do not contain exactly one message
+	// type.
+	Strict bool
+	// Validate rejects messages that are missing fields or that are not
+	// valid according to the message spec, see IncomingMessage.Validate
+	// and OutgoingMessage.Validate.
+	Validate bool
+}
+
+// DecodeIncoming decodes a frame of messages send by a server.
+func (d Decoder) DecodeIncoming(frame []byte) (IncomingMessages, error) {
+	msgs, err := decodeIncoming(frame)
+	if err != nil {
+		return nil, err
+	}
+	if !d.Strict && !d.Validate {
+		return msgs, nil
+	}
+	var raw []map[string]json.RawMessage
+	if err := json.Unmarshal(frame, &raw); err != nil {
+		return nil, err
+	}
+	for i, m := range msgs {
+		var required []string
+		if t, _ := m.incoming(); t != nil {
+			required = t.required
+		}
+		_, v := m.Message()
+		if err := d.check(raw[i], m.Type(), required, v, m.Validate); err != nil {
+			return nil, &InvalidMessageError{Index: i, Err: err}
+		}
+	}
+	return msgs, nil
+}
+
+// DecodeOutgoing decodes a frame of messages send by a client.
+func (d Decoder) DecodeOutgoing(frame []byte) (OutgoingMessages, error) {
+	var msgs OutgoingMessages
+	if err := json.Unmarshal(frame, &msgs); err != nil {
+		return nil, err
+	}
+	if !d.Strict && !d.Validate {
+		return msgs, nil
+	}
+	var raw []map[string]json.RawMessage
+	if err := json.Unmarshal(frame, &raw); err != nil {
+		return nil, err
+	}
+	for i, m := range msgs {
+		var required []string
+		if t, _ := m.outgoing(); t != nil {
+			required = t.required
+		}
+		_, v := m.Message()
+		if err := d.check(raw[i], m.Type(), required, v, m.Validate); err != nil {
+			return nil, &InvalidMessageError{Index: i, Err: err}
+		}
+	}
+	return msgs, nil
+}
+
+// Check applies the strict and validation rules to a single message of type
+// typ with the required fields.
+func (d Decoder) check(raw map[string]json.RawMessage, typ string, required []string, v interface{}, validate func() error) error {
+	if d.Strict && len(raw) != 1 {
+		return fmt.Errorf("%d message types (%s), want 1", len(raw),
+			strings.Join(sortedKeys(raw), ", "))
+	}
+	if !d.Validate {
+		return nil
+	}
+	if err := validate(); err != nil {
+		return err
+	}
+	if _, ok := v.(Unknown); ok {
+		return nil
+	}
+	return requiredFields(typ, required, raw[typ])
+}
+
+// requiredFields checks if all required fields of the message type are in
+// the JSON object.
+func requiredFields(typ string, required []string, data json.RawMessage) error {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return fmt.Errorf("%s: %v", typ, err)
+	}
+	if obj == nil {
+		return fmt.Errorf("%s: null message", typ)
+	}
+	for _, name := range required {
+		if _, ok := obj[name]; !ok {
+			return fmt.Errorf("%s: missing field %s", typ, name)
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,128 @@
+package message
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestUnknownMessage(t *testing.T) {
+	var msgs IncomingMessages
+	frame := `[{"RotateCmd":{"Id":3,"DeviceIndex":1,"Rotations":[]}},{"Ok":{"Id":4}}]`
+	if err := json.Unmarshal([]byte(frame), &msgs); err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages, want 2", len(msgs))
+	}
+	id, v := msgs[0].Message()
+	u, ok := v.(Unknown)
+	if !ok || id != 3 || u.Name != "RotateCmd" {
+		t.Errorf("unknown message not preserved: %d %#v", id, v)
+	}
+	if id, _ := msgs[1].Message(); id != 4 || msgs[1].Unknown != nil {
+		t.Errorf("known message decoded as unknown: %+v", msgs[1])
+	}
+
+	msgs[0].SetID(7)
+	b, err := json.Marshal(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"RotateCmd":{"DeviceIndex":1,"Id":7,"Rotations":[]}},{"Ok":{"Id":4}}]`
+	if string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	strict := Decoder{Strict: true}
+	validate := Decoder{Validate: true}
+	cases := []struct {
+		Name    string
+		Decoder Decoder
+		Frame   string
+		Invalid bool
+	}{
+		{"Lenient", Decoder{}, `[{"Ok":{"Id":1},"Test":{"Id":1}},{}]`, false},
+		{"Strict", strict, `[{"Ok":{"Id":1}},{"Future":{"Id":0}}]`, false},
+		{"StrictMultiple", strict, `[{"Ok":{"Id":1},"Test":{"Id":1}}]`, true},
+		{"StrictZero", strict, `[{}]`, true},
+		{"Validate", validate, `[{"Ok":{"Id":1}}]`, false},
+		{"ValidateMissingField", validate, `[{"Log":{"Id":0,"LogLevel":"Info"}}]`, true},
+		{"ValidateEventID", validate, `[{"ScanningFinished":{"Id":1}}]`, true},
+		{"ValidateReplyID", validate, `[{"Ok":{"Id":0}}]`, true},
+		{"ValidateNull", validate, `[{"Ok":null}]`, true},
+		{"ValidateDevice", validate, `[{"DeviceAdded":{"Id":0,"DeviceName":"","DeviceIndex":1,"DeviceMessages":["StopDeviceCmd"]}}]`, true},
+		{"ValidateUnknown", validate, `[{"Future":{"Foo":"Bar"}}]`, false},
+	}
+	for _, c := range cases {
+		_, err := c.Decoder.DecodeIncoming([]byte(c.Frame))
+		if _, ok := err.(*InvalidMessageError); ok != c.Invalid || (err != nil && !ok) {
+			t.Errorf("case %s: error %v, want invalid %v", c.Name, err, c.Invalid)
+		}
+	}
+	if _, err := strict.DecodeIncoming([]byte(`[{`)); err == nil {
+		t.Errorf("syntax error not returned")
+	}
+}
+
+func TestIncomingValidate(t *testing.T) {
+	d := Decoder{Strict: true, Validate: true}
+	for _, c := range IncomingJSONCases {
+		if _, err := d.DecodeIncoming([]byte(c.JSON)); err != nil {
+			t.Errorf("case %s: %v", c.Name, err)
+		}
+	}
+}
+
+func TestOutgoingValidate(t *testing.T) {
+	d := Decoder{Strict: true, Validate: true}
+	for _, c := range OutgoingJSONCases {
+		if _, err := d.DecodeOutgoing([]byte(c.JSON)); err != nil {
+			t.Errorf("case %s: %v", c.Name, err)
+		}
+	}
+	cases := []struct {
+		Frame string
+		Want  string
+	}{
+		{`[{"Ping":{"Id":0}}]`, "Id 0"},
+		{`[{"Future":{"Id":1}}]`, "no message"},
+		{`[{"RequestLog":{"Id":1,"LogLevel":"Verbose"}}]`, "log level"},
+		{`[{"SingleMotorVibrateCmd":{"Id":1,"DeviceIndex":0,"Speed":1.5}}]`, "speed"},
+		{`[{"FleshlightLaunchFW12Cmd":{"Id":1,"DeviceIndex":0,"Position":100,"Speed":0}}]`, "position"},
+		{`[{"KiirooCmd":{"Id":1,"DeviceIndex":0,"Command":5}}]`, "command"},
+		{`[{"VorzeA10CycloneCmd":{"Id":1,"DeviceIndex":0,"Speed":-1,"Clockwise":true}}]`, "speed"},
+		{`[{"VorzeA10CycloneCmd":{"Id":1,"DeviceIndex":0,"Speed":1}}]`, "missing field Clockwise"},
+	}
+	for _, c := range cases {
+		_, err := d.DecodeOutgoing([]byte(c.Frame))
+		if err == nil || !strings.Contains(err.Error(), c.Want) {
+			t.Errorf("%s: error %v, want %q", c.Frame, err, c.Want)
+		}
+	}
+}
+
+func TestReceiverDecodeWith(t *testing.T) {
+	conn := &frameConn{
+		in: [][]byte{
+			[]byte(`[{"Ok":{"Id":1},"Test":{"Id":1}}]`),
+			[]byte(`[{"Ok":{"Id":2}}]`),
+		},
+		wait: make(chan struct{}),
+	}
+	done := make(chan struct{})
+	rc := NewReceiver(conn, done, DecodeWith(Decoder{Strict: true}))
+	r, err := rc.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(conn.wait)
+	m := <-r.Incoming()
+	if id, _ := m.Message(); id != 2 {
+		t.Errorf("got message %d, want 2", id)
+	}
+	<-done
+	rc.Stop()
+}
@@ -3,6 +3,8 @@ Package message contains types and handlers for Buttplug messages.
 */
 package message
 
+import "reflect"
+
 const (
 	// LogLevelOff ...
 	LogLevelOff = "Off"
@@ -39,31 +41,64 @@ type IncomingMessage struct {
 	DeviceList    *DeviceList `json:"DeviceList,omitempty"`
 	DeviceAdded   *Device     `json:"DeviceAdded,omitempty"`
 	DeviceRemoved *Device     `json:"DeviceRemoved,omitempty"`
+
+	// Custom is set for messages of a registered type that has no field.
+	Custom *Typed `json:"-"`
+	// Unknown is set when the message type is not known.
+	Unknown *Unknown `json:"-"`
+}
+
+// Type returns the name of the message type.
+func (m IncomingMessage) Type() string {
+	if t, _ := m.incoming(); t != nil {
+		return t.name
+	}
+	return extraType(m.Custom, m.Unknown)
+}
+
+// ID returns the id of the message. Unlike Message it does not copy the
+// message.
+func (m IncomingMessage) ID() uint32 {
+	if _, p := m.incoming(); p != nil {
+		id, _ := p.fields()
+		return *id
+	}
+	id, _ := extraMessage(m.Custom, m.Unknown)
+	return id
 }
 
 // Message returns the id and message.
 func (m IncomingMessage) Message() (id uint32, v interface{}) {
-	switch true {
-	case m.Ok != nil:
-		return m.Ok.ID, *m.Ok
-	case m.Error != nil:
-		return m.Error.ID, *m.Error
-	case m.Test != nil:
-		return m.Test.ID, *m.Test
-	case m.Log != nil:
-		return m.Log.ID, *m.Log
-	case m.ServerInfo != nil:
-		return m.ServerInfo.ID, *m.ServerInfo
-	case m.ScanningFinished != nil:
-		return m.ScanningFinished.ID, *m.ScanningFinished
-	case m.DeviceList != nil:
-		return m.DeviceList.ID, *m.DeviceList
-	case m.DeviceAdded != nil:
-		return m.DeviceAdded.ID, *m.DeviceAdded
-	case m.DeviceRemoved != nil:
-		return m.DeviceRemoved.ID, *m.DeviceRemoved
+	if _, p := m.incoming(); p != nil {
+		id, _ := p.fields()
+		return *id, p.value()
 	}
-	return 0, nil
+	return extraMessage(m.Custom, m.Unknown)
+}
+
+// SetID sets the id of the message.
+func (m *IncomingMessage) SetID(id uint32) {
+	if _, p := m.incoming(); p != nil {
+		f, _ := p.fields()
+		*f = id
+		return
+	}
+	extraSetID(m.Custom, m.Unknown, id)
+}
+
+// DeviceIndex returns the index of the device the message is about. False is
+// returned when the message is not about a single device.
+func (m IncomingMessage) DeviceIndex() (uint32, bool) {
+	if _, p := m.incoming(); p != nil {
+		if _, i := p.fields(); i != nil {
+			return *i, true
+		}
+		return 0, false
+	}
+	if m.Custom != nil {
+		return payloadDeviceIndex(reflect.ValueOf(m.Custom.Value))
+	}
+	return 0, false
 }
 
 // OutgoingMessage contains all messages a Buttplug server can receive.
@@ -87,6 +122,62 @@ type OutgoingMessage struct {
 	FleshlightLaunchFW12Cmd *FleshlightLaunchFW12Cmd `json:"FleshlightLaunchFW12Cmd,omitempty"`
 	LovenseCmd              *LovenseCmd              `json:"LovenseCmd,omitempty"`
 	VorzeA10CycloneCmd      *VorzeA10CycloneCmd      `json:"VorzeA10CycloneCmd,omitempty"`
+
+	// Custom is set for messages of a registered type that has no field.
+	Custom *Typed `json:"-"`
+}
+
+// Type returns the name of the message type.
+func (m OutgoingMessage) Type() string {
+	if t, _ := m.outgoing(); t != nil {
+		return t.name
+	}
+	return extraType(m.Custom, nil)
+}
+
+// ID returns the id of the message. Unlike Message it does not copy the
+// message.
+func (m OutgoingMessage) ID() uint32 {
+	if _, p := m.outgoing(); p != nil {
+		id, _ := p.fields()
+		return *id
+	}
+	id, _ := extraMessage(m.Custom, nil)
+	return id
+}
+
+// Message returns the id and message.
+func (m OutgoingMessage) Message() (id uint32, v interface{}) {
+	if _, p := m.outgoing(); p != nil {
+		id, _ := p.fields()
+		return *id, p.value()
+	}
+	return extraMessage(m.Custom, nil)
+}
+
+// SetID sets the id of the message.
+func (m *OutgoingMessage) SetID(id uint32) {
+	if _, p := m.outgoing(); p != nil {
+		f, _ := p.fields()
+		*f = id
+		return
+	}
+	extraSetID(m.Custom, nil, id)
+}
+
+// DeviceIndex returns the index of the device the message is for. False is
+// returned when the message is not a device message.
+func (m OutgoingMessage) DeviceIndex() (uint32, bool) {
+	if _, p := m.outgoing(); p != nil {
+		if _, i := p.fields(); i != nil {
+			return *i, true
+		}
+		return 0, false
+	}
+	if m.Custom != nil {
+		return payloadDeviceIndex(reflect.ValueOf(m.Custom.Value))
+	}
+	return 0, false
 }
 
 // Empty message is used for all request and responses without additional
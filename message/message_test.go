@@ -609,7 +609,7 @@ func (p *IncomingMessage) Equals(v *IncomingMessage) bool {
 		return false
 	}
 
-	switch true {
+	switch {
 	case p.Ok == nil && v.Ok != nil:
 		return false
 	case p.Ok != nil && *p.Ok != *v.Ok:
@@ -646,6 +646,10 @@ func (p *IncomingMessage) Equals(v *IncomingMessage) bool {
 		return false
 	case p.DeviceRemoved != nil && !reflect.DeepEqual(*p.DeviceRemoved, *v.DeviceRemoved):
 		return false
+	case p.Unknown == nil && v.Unknown != nil:
+		return false
+	case p.Unknown != nil && !reflect.DeepEqual(*p.Unknown, *v.Unknown):
+		return false
 	}
 	return true
 }
@@ -657,7 +661,7 @@ func (p *OutgoingMessage) Equals(v *OutgoingMessage) bool {
 	} else if p == nil || v == nil {
 		return false
 	}
-	switch true {
+	switch {
 	case p.Ping == nil && v.Ping != nil:
 		return false
 	case p.Ping != nil && *p.Ping != *v.Ping:
@@ -721,3 +725,50 @@ func (p *OutgoingMessage) Equals(v *OutgoingMessage) bool {
 	}
 	return true
 }
+
+func TestOutgoingMessageID(t *testing.T) {
+	for _, c := range OutgoingJSONCases {
+		var omsgs OutgoingMessages
+		if err := json.Unmarshal([]byte(c.JSON), &omsgs); err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range omsgs {
+			m.SetID(42)
+			if id, v := m.Message(); id != 42 || v == nil {
+				t.Errorf("case %s: id not set: %d", c.Name, id)
+			}
+		}
+	}
+}
+
+func TestIncomingMessageID(t *testing.T) {
+	for _, c := range IncomingJSONCases {
+		var imsgs IncomingMessages
+		if err := json.Unmarshal([]byte(c.JSON), &imsgs); err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range imsgs {
+			m.SetID(42)
+			if id, v := m.Message(); id != 42 || v == nil {
+				t.Errorf("case %s: id not set: %d", c.Name, id)
+			}
+		}
+	}
+}
+
+func TestOutgoingMessageDeviceIndex(t *testing.T) {
+	for _, c := range OutgoingJSONCases {
+		var omsgs OutgoingMessages
+		if err := json.Unmarshal([]byte(c.JSON), &omsgs); err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range omsgs {
+			index, ok := m.DeviceIndex()
+			_, v := m.Message()
+			_, isDevice := reflect.TypeOf(v).FieldByName("DeviceIndex")
+			if ok != isDevice || (ok && index != reflect.ValueOf(v).FieldByName("DeviceIndex").Interface().(uint32)) {
+				t.Errorf("case %s: device index %d, %v", c.Name, index, ok)
+			}
+		}
+	}
+}
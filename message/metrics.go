@@ -0,0 +1,197 @@
+package message
+
+import (
+	"encoding/json"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// Metric names reported to Metrics.
+const (
+	// MetricSent counts the messages written to the server, labeled by
+	// message type.
+	MetricSent = "messages_sent"
+	// MetricReceived counts the messages received from the server, labeled
+	// by message type.
+	MetricReceived = "messages_received"
+	// MetricCommandLatency observes the time between queueing a device
+	// command and receiving the reply, labeled by device index.
+	MetricCommandLatency = "command_latency"
+	// MetricErrors counts errors, labeled by class: "write", "queue_full",
+	// "dropped", "replaced", "decode", "overflow", "overflow_dropped",
+	// "server" and "timeout".
+	MetricErrors = "errors"
+	// MetricSendQueue is the amount of messages in the send queue.
+	MetricSendQueue = "send_queue_depth"
+	// MetricSubscribers is the amount of readers subscribed to the
+	// receiver.
+	MetricSubscribers = "subscribers"
+	// MetricConnects counts the connections made to a server. When an
+	// application reconnects by creating a new client with the same
+	// Metrics, every connect after the first is a reconnect.
+	MetricConnects = "connects"
+)
+
+// Metrics receives measurements of the message traffic. Implementations must
+// be safe for concurrent use and must not block.
+type Metrics interface {
+	// Add adds delta to the counter with the given name and label.
+	Add(name, label string, delta int64)
+	// Set sets the gauge with the given name.
+	Set(name string, value int64)
+	// Observe records a duration in the histogram with the given name and
+	// label.
+	Observe(name, label string, d time.Duration)
+}
+
+// NopMetrics discards all measurements.
+type NopMetrics struct{}
+
+// Add does nothing.
+func (NopMetrics) Add(name, label string, delta int64) {}
+
+// Set does nothing.
+func (NopMetrics) Set(name string, value int64) {}
+
+// Observe does nothing.
+func (NopMetrics) Observe(name, label string, d time.Duration) {}
+
+// metricsOrNop returns m, or a Metrics that discards everything when m is nil.
+func metricsOrNop(m Metrics) Metrics {
+	if m == nil {
+		return NopMetrics{}
+	}
+	return m
+}
+
+// HistogramBuckets are the upper bounds of the histogram buckets used by
+// ExpvarMetrics.
+var HistogramBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// ExpvarMetrics publishes metrics with the expvar package. Counters and
+// histograms are maps by label, histograms have cumulative bucket counts.
+type ExpvarMetrics struct {
+	m    sync.Mutex // Protects creating the variables in vars.
+	vars *expvar.Map
+}
+
+// NewExpvarMetrics returns Metrics published as an expvar map with the given
+// name. The map is reused when it is already published.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	if v, ok := expvar.Get(name).(*expvar.Map); ok {
+		return &ExpvarMetrics{vars: v}
+	}
+	return &ExpvarMetrics{vars: expvar.NewMap(name)}
+}
+
+// Add adds delta to the counter with the given name and label.
+func (e *ExpvarMetrics) Add(name, label string, delta int64) {
+	e.labels(name).Add(label, delta)
+}
+
+// Set sets the gauge with the given name.
+func (e *ExpvarMetrics) Set(name string, value int64) {
+	e.m.Lock()
+	v, ok := e.vars.Get(name).(*expvar.Int)
+	if !ok {
+		v = new(expvar.Int)
+		e.vars.Set(name, v)
+	}
+	e.m.Unlock()
+	v.Set(value)
+}
+
+// Observe records a duration in the histogram with the given name and label.
+func (e *ExpvarMetrics) Observe(name, label string, d time.Duration) {
+	labels := e.labels(name)
+	e.m.Lock()
+	h, ok := labels.Get(label).(*histogram)
+	if !ok {
+		h = newHistogram(HistogramBuckets)
+		labels.Set(label, h)
+	}
+	e.m.Unlock()
+	h.observe(d)
+}
+
+// Labels returns the map by label of the metric with the given name.
+func (e *ExpvarMetrics) labels(name string) *expvar.Map {
+	e.m.Lock()
+	defer e.m.Unlock()
+	v, ok := e.vars.Get(name).(*expvar.Map)
+	if !ok {
+		v = new(expvar.Map).Init()
+		e.vars.Set(name, v)
+	}
+	return v
+}
+
+// histogram is an expvar.Var that counts durations in buckets.
+type histogram struct {
+	bounds []time.Duration
+
+	m      sync.Mutex // Protects counts, sum and count.
+	counts []int64    // Counts per bucket, the last is for +Inf.
+	sum    time.Duration
+	count  int64
+}
+
+func newHistogram(bounds []time.Duration) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)+1),
+	}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(h.bounds) && d > h.bounds[i] {
+		i++
+	}
+	h.m.Lock()
+	h.counts[i]++
+	h.sum += d
+	h.count++
+	h.m.Unlock()
+}
+
+// String returns the histogram as JSON, durations are in seconds.
+func (h *histogram) String() string {
+	type bucket struct {
+		Le    string `json:"le"`
+		Count int64  `json:"count"`
+	}
+	var v struct {
+		Count   int64    `json:"count"`
+		Sum     float64  `json:"sum"`
+		Buckets []bucket `json:"buckets"`
+	}
+	h.m.Lock()
+	v.Count = h.count
+	v.Sum = h.sum.Seconds()
+	var n int64
+	for i, c := range h.counts {
+		n += c
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = h.bounds[i].String()
+		}
+		v.Buckets = append(v.Buckets, bucket{Le: le, Count: n})
+	}
+	h.m.Unlock()
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,200 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMetrics records measurements.
+type testMetrics struct {
+	m        sync.Mutex
+	counters map[string]int64
+	gauges   map[string][]int64
+	observed map[string]int
+}
+
+func newTestMetrics() *testMetrics {
+	return &testMetrics{
+		counters: make(map[string]int64),
+		gauges:   make(map[string][]int64),
+		observed: make(map[string]int),
+	}
+}
+
+func (m *testMetrics) Add(name, label string, delta int64) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.counters[name+"/"+label] += delta
+}
+
+func (m *testMetrics) Set(name string, value int64) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.gauges[name] = append(m.gauges[name], value)
+}
+
+func (m *testMetrics) Observe(name, label string, d time.Duration) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.observed[name+"/"+label]++
+}
+
+func (m *testMetrics) counter(name, label string) int64 {
+	m.m.Lock()
+	defer m.m.Unlock()
+	return m.counters[name+"/"+label]
+}
+
+// Gauge returns the last value of a gauge.
+func (m *testMetrics) gauge(name string) int64 {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if v := m.gauges[name]; len(v) > 0 {
+		return v[len(v)-1]
+	}
+	return 0
+}
+
+// History returns all values of a gauge.
+func (m *testMetrics) history(name string) []int64 {
+	m.m.Lock()
+	defer m.m.Unlock()
+	return append([]int64(nil), m.gauges[name]...)
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	name := fmt.Sprintf("golibbuttplug_test_%d", time.Now().UnixNano())
+	m := NewExpvarMetrics(name)
+	m.Add(MetricSent, "Ping", 2)
+	m.Add(MetricSent, "Ping", 1)
+	m.Set(MetricSendQueue, 4)
+	m.Observe(MetricCommandLatency, "1", 3*time.Millisecond)
+	m.Observe(MetricCommandLatency, "1", time.Minute)
+	if NewExpvarMetrics(name).vars != m.vars {
+		t.Errorf("published map not reused")
+	}
+
+	var got struct {
+		Sent    map[string]int64 `json:"messages_sent"`
+		Queue   int64            `json:"send_queue_depth"`
+		Latency map[string]struct {
+			Count   int64
+			Sum     float64
+			Buckets []struct {
+				Le    string
+				Count int64
+			}
+		} `json:"command_latency"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Sent["Ping"] != 3 || got.Queue != 4 {
+		t.Errorf("unexpected counters: %+v", got)
+	}
+	h := got.Latency["1"]
+	if h.Count != 2 || h.Sum != 60.003 || len(h.Buckets) != len(HistogramBuckets)+1 {
+		t.Fatalf("unexpected histogram: %+v", h)
+	}
+	if b := h.Buckets[1]; b.Le != "5ms" || b.Count != 1 {
+		t.Errorf("unexpected 5ms bucket: %+v", b)
+	}
+	if b := h.Buckets[len(h.Buckets)-1]; b.Le != "+Inf" || b.Count != 2 {
+		t.Errorf("unexpected +Inf bucket: %+v", b)
+	}
+}
+
+func TestSenderMetrics(t *testing.T) {
+	m := newTestMetrics()
+	conn := newGateConn()
+	s, results := queued(t, conn, []SenderOption{SenderMetrics(m)}, vibrate(1, 0.1), vibrate(2, 0.2))
+	defer s.Stop()
+	if d := m.gauge(MetricSendQueue); d != 2 {
+		t.Errorf("got queue depth %d, want 2", d)
+	}
+	if err := s.Send(vibrate(3, 0.3)); err != ErrQueueFull {
+		t.Fatalf("got %v, want %v", err, ErrQueueFull)
+	}
+	conn.release <- nil
+	conn.written(t, nil)
+	conn.written(t, errors.New("write failed"))
+	result(t, results[0])
+	result(t, results[1])
+	for _, c := range []struct {
+		Name, Label string
+		Want        int64
+	}{
+		{MetricSent, "Ping", 1},
+		{MetricSent, "SingleMotorVibrateCmd", 1},
+		{MetricErrors, "write", 1},
+		{MetricErrors, "queue_full", 1},
+	} {
+		if got := m.counter(c.Name, c.Label); got != c.Want {
+			t.Errorf("%s %s: got %d, want %d", c.Name, c.Label, got, c.Want)
+		}
+	}
+	if d := m.gauge(MetricSendQueue); d != 0 {
+		t.Errorf("got queue depth %d, want 0", d)
+	}
+}
+
+func TestHubMetrics(t *testing.T) {
+	m := newTestMetrics()
+	rc := &Receiver{hub: newHub(m)}
+	defer rc.Stop()
+	r, err := rc.Subscribe(BufferSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rc.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Unsubscribe(other)
+	rc.hub.incoming <- ok(1)
+	rc.hub.incoming <- ok(2)
+	<-r.Incoming()
+	if _, open := <-r.Incoming(); open {
+		t.Fatal("reader not closed")
+	}
+	// Wait until the hub is done with the overflow.
+	rc.Unsubscribe(r)
+	if h := m.history(MetricSubscribers); !reflect.DeepEqual(h, []int64{1, 2, 1, 0}) {
+		t.Errorf("got subscribers %v, want [1 2 1 0]", h)
+	}
+	if n := m.counter(MetricErrors, "overflow"); n != 1 {
+		t.Errorf("got %d overflows, want 1", n)
+	}
+}
+
+func TestHubMetricsDropped(t *testing.T) {
+	m := newTestMetrics()
+	rc := &Receiver{hub: newHub(m)}
+	defer rc.Stop()
+	newest, err := rc.Subscribe(BufferSize(1), OnOverflow(OverflowDropNewest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldest, err := rc.Subscribe(BufferSize(1), OnOverflow(OverflowDropOldest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(1); i <= 3; i++ {
+		rc.hub.incoming <- ok(i)
+	}
+	// Wait until the hub is done with the last message.
+	rc.Unsubscribe(newest)
+	rc.Unsubscribe(oldest)
+	if n := m.counter(MetricErrors, "overflow_dropped"); n != 4 {
+		t.Errorf("got %d drops, want 4", n)
+	}
+	if n := m.counter(MetricErrors, "overflow"); n != 0 {
+		t.Errorf("got %d overflows, want 0", n)
+	}
+}
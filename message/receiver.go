@@ -2,41 +2,92 @@ package message
 
 import (
 	"errors"
+	"log"
 	"sync"
-
-	"github.com/gorilla/websocket"
 )
 
 // Receiver can read Buttplug server messages from a websocket to multiple
 // readers. Readers can subscribe/unsubscribe from receiving messages.
 type Receiver struct {
-	once sync.Once // Make sure Stop() is execute only once.
-	conn *websocket.Conn
-	hub  *hub
+	once         sync.Once // Make sure Stop() is execute only once.
+	conn         Conn
+	hub          *hub
+	decoder      Decoder
+	metrics      Metrics
+	interceptors []IncomingInterceptor
+}
+
+// ReceiverOption configures a Receiver.
+type ReceiverOption func(*Receiver)
+
+// DecodeWith decodes the received frames with d. Frames with messages that
+// are rejected by the decoder are logged and dropped.
+func DecodeWith(d Decoder) ReceiverOption {
+	return func(r *Receiver) {
+		r.decoder = d
+	}
+}
+
+// IncomingInterceptor is called for every received message before it is
+// delivered to the readers. It can modify the message, returning false drops
+// it.
+type IncomingInterceptor func(m *IncomingMessage) bool
+
+// Intercept calls the interceptors in order for every received message. A
+// message dropped by an interceptor is not passed to the next.
+func Intercept(interceptors ...IncomingInterceptor) ReceiverOption {
+	return func(r *Receiver) {
+		r.interceptors = append(r.interceptors, interceptors...)
+	}
+}
+
+// ReceiverMetrics reports the received messages, dropped frames, reader
+// overflows and the amount of subscribed readers to m.
+func ReceiverMetrics(m Metrics) ReceiverOption {
+	return func(r *Receiver) {
+		r.metrics = m
+	}
 }
 
 // NewReceiver creates a Receiver for the given websocket connection. Done
 // channel is closed then receiver is done.
-func NewReceiver(conn *websocket.Conn, done chan struct{}) *Receiver {
+func NewReceiver(conn Conn, done chan struct{}, opts ...ReceiverOption) *Receiver {
 	r := &Receiver{
 		conn: conn,
-		hub:  newHub(),
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.metrics = metricsOrNop(r.metrics)
+	r.hub = newHub(r.metrics)
 	go r.run(done)
 	return r
 }
 
 // Run reads a message from the websocket connection and puts it on the hub.
 func (rc *Receiver) run(done chan struct{}) {
+	fr := &frameReader{conn: rc.conn}
 	for {
 		var msgs IncomingMessages
-		err := rc.conn.ReadJSON(&msgs)
+		p, err := fr.read()
+		if err == nil {
+			msgs, err = rc.decoder.DecodeIncoming(p)
+			if _, ok := err.(*InvalidMessageError); ok {
+				log.Printf("dropped frame: %v: %s", err, p)
+				rc.metrics.Add(MetricErrors, "decode", 1)
+				continue
+			}
+		}
 		if err != nil {
 			rc.conn.Close()
 			close(done)
 			return
 		}
 		for _, msg := range msgs {
+			rc.metrics.Add(MetricReceived, msg.Type(), 1)
+			if !rc.intercept(&msg) {
+				continue
+			}
 			select {
 			case rc.hub.incoming <- msg:
 			case <-rc.hub.stop:
@@ -46,17 +97,40 @@ func (rc *Receiver) run(done chan struct{}) {
 
 }
 
+// Intercept passes a message through the interceptors. False is returned when
+// the message is dropped.
+func (rc *Receiver) intercept(m *IncomingMessage) bool {
+	for _, i := range rc.interceptors {
+		if !i(m) {
+			return false
+		}
+	}
+	return true
+}
+
 // Subscribe creates a new reader that receives messages. A consumer should
-// call the Unsubscribe when it's done with the reader.
-func (rc *Receiver) Subscribe() (*Reader, error) {
+// call the Unsubscribe when it's done with the reader. Without options the
+// reader receives all messages, has a buffer of DefaultBufferSize messages
+// and is closed when the buffer overflows.
+func (rc *Receiver) Subscribe(opts ...SubscribeOption) (*Reader, error) {
 	r := &Reader{
-		buf: make(chan IncomingMessage, 10),
+		size: DefaultBufferSize,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.buf = make(chan IncomingMessage, r.size)
+	if r.policy == OverflowUnbounded {
+		r.queue = newQueue(r.buf)
 	}
 	select {
 	case rc.hub.subscribe <- r:
 		return r, nil
 	case <-rc.hub.stop:
-		return nil, errors.New("stopped")
+		if r.queue != nil {
+			r.queue.close()
+		}
+		return nil, ErrReceiverStopped
 	}
 }
 
@@ -76,10 +150,106 @@ func (rc *Receiver) Stop() {
 	})
 }
 
+// DefaultBufferSize is the amount of messages buffered for a reader.
+const DefaultBufferSize = 10
+
+var (
+	// ErrOverflow is the error of a reader that is closed because it did
+	// not keep up with the incoming messages.
+	ErrOverflow = errors.New("reader buffer overflow")
+	// ErrReceiverStopped is the error of a reader that is closed because
+	// the receiver is stopped.
+	ErrReceiverStopped = errors.New("stopped")
+)
+
+// OverflowPolicy decides what happens when a message is received for a
+// reader with a full buffer.
+type OverflowPolicy int
+
+const (
+	// OverflowClose closes the reader, Err returns ErrOverflow.
+	OverflowClose OverflowPolicy = iota
+	// OverflowDropNewest drops the received message.
+	OverflowDropNewest
+	// OverflowDropOldest drops the oldest buffered message.
+	OverflowDropOldest
+	// OverflowUnbounded queues messages until the reader has room, no
+	// messages are lost. Other readers and new subscriptions do not wait
+	// for the reader, so a consumer can make requests before it reads the
+	// next message. The queue has no limit: every message the reader has
+	// not read yet is kept in memory, a reader that stops reading grows
+	// without bound. Queued messages are dropped when the reader is
+	// closed.
+	OverflowUnbounded
+)
+
+// SubscribeOption configures a subscription.
+type SubscribeOption func(*Reader)
+
+// Filter only delivers messages for which match returns true. When multiple
+// filters are used a message must match all of them.
+func Filter(match func(m IncomingMessage) bool) SubscribeOption {
+	return func(r *Reader) {
+		r.filters = append(r.filters, match)
+	}
+}
+
+// FilterTypes only delivers messages of the given types, eg: "DeviceAdded".
+func FilterTypes(types ...string) SubscribeOption {
+	return Filter(func(m IncomingMessage) bool {
+		t := m.Type()
+		for _, v := range types {
+			if v == t {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// FilterID only delivers messages with the given id. Id 0 selects the events
+// that are not a reply to a client message.
+func FilterID(id uint32) SubscribeOption {
+	return Filter(func(m IncomingMessage) bool {
+		return m.ID() == id
+	})
+}
+
+// FilterDevice only delivers messages about the device with the given index.
+func FilterDevice(index uint32) SubscribeOption {
+	return Filter(func(m IncomingMessage) bool {
+		i, ok := m.DeviceIndex()
+		return ok && i == index
+	})
+}
+
+// BufferSize sets the amount of messages buffered for the reader.
+func BufferSize(n int) SubscribeOption {
+	return func(r *Reader) {
+		if n > 0 {
+			r.size = n
+		}
+	}
+}
+
+// OnOverflow sets what happens when the buffer of the reader is full.
+func OnOverflow(p OverflowPolicy) SubscribeOption {
+	return func(r *Reader) {
+		r.policy = p
+	}
+}
+
 // Reader is receives messages from the Receiver subscription.
 type Reader struct {
 	// buffered channel for subscriber to read
-	buf chan IncomingMessage
+	buf     chan IncomingMessage
+	size    int
+	policy  OverflowPolicy
+	filters []func(IncomingMessage) bool
+	queue   *queue // Queue of an OverflowUnbounded reader.
+
+	m   sync.Mutex // Protects err.
+	err error      // Why the reader is closed.
 }
 
 // Incoming returns a channel of incoming messages.
@@ -87,6 +257,107 @@ func (r *Reader) Incoming() <-chan IncomingMessage {
 	return r.buf
 }
 
+// Err returns why the incoming channel is closed: ErrOverflow or
+// ErrReceiverStopped. Nil is returned while the reader is open and after
+// it is unsubscribed.
+func (r *Reader) Err() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.err
+}
+
+// Match reports whether the message passes all filters.
+func (r *Reader) match(m IncomingMessage) bool {
+	for _, f := range r.filters {
+		if !f(m) {
+			return false
+		}
+	}
+	return true
+}
+
+// Close closes the incoming channel with an error.
+func (r *Reader) close(err error) {
+	if r.queue != nil {
+		r.queue.close()
+	}
+	r.m.Lock()
+	r.err = err
+	r.m.Unlock()
+	close(r.buf)
+}
+
+// Queue forwards messages to the buffer of a reader, so the hub never waits
+// for a reader.
+type queue struct {
+	m    sync.Mutex // Protects msgs.
+	msgs []IncomingMessage
+
+	signal chan struct{} // Signal that msgs is not empty.
+	stop   chan struct{}
+	done   chan struct{} // Closed when run returns.
+}
+
+// NewQueue starts forwarding to buf.
+func newQueue(buf chan<- IncomingMessage) *queue {
+	q := &queue{
+		signal: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go q.run(buf)
+	return q
+}
+
+// Push adds a message to the end of the queue.
+func (q *queue) push(m IncomingMessage) {
+	q.m.Lock()
+	q.msgs = append(q.msgs, m)
+	q.m.Unlock()
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Pop removes the first message of the queue. False is returned when the
+// queue is empty.
+func (q *queue) pop() (IncomingMessage, bool) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if len(q.msgs) == 0 {
+		return IncomingMessage{}, false
+	}
+	m := q.msgs[0]
+	q.msgs[0] = IncomingMessage{}
+	q.msgs = q.msgs[1:]
+	return m, true
+}
+
+func (q *queue) run(buf chan<- IncomingMessage) {
+	defer close(q.done)
+	for {
+		select {
+		case <-q.signal:
+		case <-q.stop:
+			return
+		}
+		for m, ok := q.pop(); ok; m, ok = q.pop() {
+			select {
+			case buf <- m:
+			case <-q.stop:
+				return
+			}
+		}
+	}
+}
+
+// Close stops forwarding and waits until the buffer is no longer used.
+func (q *queue) close() {
+	close(q.stop)
+	<-q.done
+}
+
 // Hub forwards messages to subscribed readers.
 type hub struct {
 	readers map[*Reader]bool
@@ -98,12 +369,15 @@ type hub struct {
 	unsubscribe chan *Reader
 	// stop the hub
 	stop chan bool
+	// metrics receives the amount of readers and overflows
+	metrics Metrics
 }
 
 // Hub broadcasts messages received on the incoming channel to all subscribed
 // readers.
-func newHub() *hub {
+func newHub(m Metrics) *hub {
 	r := &hub{
+		metrics:     m,
 		readers:     make(map[*Reader]bool),
 		incoming:    make(chan IncomingMessage),
 		subscribe:   make(chan *Reader),
@@ -118,27 +392,66 @@ func (h *hub) run() {
 	for {
 		select {
 		case <-h.stop:
-			for reader := range h.readers {
-				close(reader.buf)
-				delete(h.readers, reader)
-			}
+			h.closeAll()
 			return
 		case reader := <-h.subscribe:
 			h.readers[reader] = true
+			h.metrics.Set(MetricSubscribers, int64(len(h.readers)))
 		case reader := <-h.unsubscribe:
-			if _, ok := h.readers[reader]; ok {
-				close(reader.buf)
-				delete(h.readers, reader)
-			}
+			h.remove(reader, nil)
 		case msg := <-h.incoming:
 			for reader := range h.readers {
-				select {
-				case reader.buf <- msg:
-				default:
-					close(reader.buf)
-					delete(h.readers, reader)
+				if reader.match(msg) {
+					h.deliver(reader, msg)
 				}
 			}
 		}
 	}
 }
+
+// Deliver puts a message in the buffer of a reader according to its overflow
+// policy.
+func (h *hub) deliver(reader *Reader, msg IncomingMessage) {
+	if reader.queue != nil {
+		reader.queue.push(msg)
+		return
+	}
+	select {
+	case reader.buf <- msg:
+		return
+	default:
+	}
+	switch reader.policy {
+	case OverflowDropNewest:
+		h.metrics.Add(MetricErrors, "overflow_dropped", 1)
+	case OverflowDropOldest:
+		h.metrics.Add(MetricErrors, "overflow_dropped", 1)
+		select {
+		case <-reader.buf:
+		default:
+		}
+		select {
+		case reader.buf <- msg:
+		default:
+		}
+	default:
+		h.metrics.Add(MetricErrors, "overflow", 1)
+		h.remove(reader, ErrOverflow)
+	}
+}
+
+// Remove closes a reader and removes it from the hub.
+func (h *hub) remove(reader *Reader, err error) {
+	if _, ok := h.readers[reader]; ok {
+		reader.close(err)
+		delete(h.readers, reader)
+		h.metrics.Set(MetricSubscribers, int64(len(h.readers)))
+	}
+}
+
+// CloseAll closes all readers after the hub is stopped.
+func (h *hub) closeAll() {
+	for reader := range h.readers {
+		h.remove(reader, ErrReceiverStopped)
+	}
+}
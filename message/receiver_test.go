@@ -3,6 +3,7 @@ package message
 import (
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -101,3 +102,192 @@ func testReceive(tb testing.TB, nMsg, nSubs int) {
 		tb.Errorf("receiver didn't close stopchan")
 	}
 }
+
+func ok(id uint32) IncomingMessage {
+	return IncomingMessage{Ok: &Empty{ID: id}}
+}
+
+func deviceAdded(index uint32) IncomingMessage {
+	return IncomingMessage{DeviceAdded: &Device{DeviceName: "dev", DeviceIndex: index}}
+}
+
+// subscribe subscribes to a new hub.
+func subscribe(t *testing.T, opts ...SubscribeOption) (*Receiver, *Reader) {
+	rc := &Receiver{hub: newHub(NopMetrics{})}
+	r, err := rc.Subscribe(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rc, r
+}
+
+// ids returns the ids of the buffered messages.
+func ids(r *Reader) []uint32 {
+	var ids []uint32
+	for {
+		select {
+		case m, ok := <-r.Incoming():
+			if !ok {
+				return ids
+			}
+			id, _ := m.Message()
+			ids = append(ids, id)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestSubscribeFilter(t *testing.T) {
+	rc, r := subscribe(t, FilterTypes("Ok", "DeviceAdded"), FilterDevice(2))
+	defer rc.Stop()
+	rc.hub.incoming <- ok(1)
+	rc.hub.incoming <- deviceAdded(1)
+	rc.hub.incoming <- deviceAdded(2)
+	rc.hub.incoming <- IncomingMessage{DeviceRemoved: &Device{DeviceIndex: 2}}
+	rc.Unsubscribe(r)
+	var got []IncomingMessage
+	for m := range r.Incoming() {
+		got = append(got, m)
+	}
+	if len(got) != 1 || got[0].DeviceAdded == nil || got[0].DeviceAdded.DeviceIndex != 2 {
+		t.Errorf("unexpected messages: %+v", got)
+	}
+	if r.Err() != nil {
+		t.Errorf("unsubscribed reader has error %v", r.Err())
+	}
+
+	rc, r = subscribe(t, FilterID(3))
+	defer rc.Stop()
+	for i := uint32(1); i <= 4; i++ {
+		rc.hub.incoming <- ok(i)
+	}
+	rc.Unsubscribe(r)
+	if got := ids(r); !reflect.DeepEqual(got, []uint32{3}) {
+		t.Errorf("got ids %v, want [3]", got)
+	}
+}
+
+func TestSubscribeOverflow(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Policy OverflowPolicy
+		Want   []uint32
+		Err    error
+	}{
+		{"Close", OverflowClose, []uint32{1, 2}, ErrOverflow},
+		{"DropNewest", OverflowDropNewest, []uint32{1, 2}, nil},
+		{"DropOldest", OverflowDropOldest, []uint32{3, 4}, nil},
+	}
+	for _, c := range cases {
+		rc, r := subscribe(t, BufferSize(2), OnOverflow(c.Policy))
+		for i := uint32(1); i <= 4; i++ {
+			rc.hub.incoming <- ok(i)
+		}
+		// Wait until the last message is handled by the hub.
+		rc.Unsubscribe(r)
+		if got := ids(r); !reflect.DeepEqual(got, c.Want) {
+			t.Errorf("%s: got ids %v, want %v", c.Name, got, c.Want)
+		}
+		if err := r.Err(); err != c.Err {
+			t.Errorf("%s: got error %v, want %v", c.Name, err, c.Err)
+		}
+		rc.Stop()
+	}
+}
+
+func TestSubscribeUnbounded(t *testing.T) {
+	rc, r := subscribe(t, BufferSize(1), OnOverflow(OverflowUnbounded))
+	other, err := rc.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := make(chan struct{})
+	go func() {
+		for i := uint32(1); i <= 3; i++ {
+			rc.hub.incoming <- ok(i)
+		}
+		close(sent)
+	}()
+	var got []uint32
+	for len(got) < 3 {
+		select {
+		case m := <-r.Incoming():
+			id, _ := m.Message()
+			got = append(got, id)
+		case <-time.After(time.Second):
+			t.Fatalf("blocked reader did not receive all messages: %v", got)
+		}
+	}
+	<-sent
+	if !reflect.DeepEqual(got, []uint32{1, 2, 3}) {
+		t.Errorf("got ids %v, want [1 2 3]", got)
+	}
+	rc.Stop()
+	for range other.Incoming() {
+	}
+	if err := other.Err(); err != ErrReceiverStopped {
+		t.Errorf("got error %v, want ErrReceiverStopped", err)
+	}
+}
+
+func TestSubscribeUnboundedNoWait(t *testing.T) {
+	rc, blocked := subscribe(t, BufferSize(1), OnOverflow(OverflowUnbounded))
+	defer rc.Stop()
+	for i := uint32(1); i <= 3; i++ {
+		rc.hub.incoming <- ok(i)
+	}
+	// A consumer of the blocked reader makes a request before reading.
+	r, err := rc.Subscribe(FilterID(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.hub.incoming <- ok(4)
+	select {
+	case m := <-r.Incoming():
+		if m.ID() != 4 {
+			t.Errorf("got id %d, want 4", m.ID())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reply not received while a reader is blocked")
+	}
+	var got []uint32
+	for len(got) < 4 {
+		select {
+		case m := <-blocked.Incoming():
+			got = append(got, m.ID())
+		case <-time.After(time.Second):
+			t.Fatalf("blocked reader did not receive all messages: %v", got)
+		}
+	}
+	if !reflect.DeepEqual(got, []uint32{1, 2, 3, 4}) {
+		t.Errorf("got ids %v, want [1 2 3 4]", got)
+	}
+	rc.Unsubscribe(blocked)
+	if _, ok := <-blocked.Incoming(); ok {
+		t.Error("unsubscribed reader is not closed")
+	}
+}
+
+// BenchmarkHubFilterID measures delivering replies to readers that wait for
+// a single id, like the client does for every request.
+func BenchmarkHubFilterID(b *testing.B) {
+	const readers = 16
+	rc := &Receiver{hub: newHub(NopMetrics{})}
+	defer rc.Stop()
+	var rs []*Reader
+	for i := uint32(1); i <= readers; i++ {
+		r, err := rc.Subscribe(FilterID(i))
+		if err != nil {
+			b.Fatal(err)
+		}
+		rs = append(rs, r)
+	}
+	msg := ok(1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rc.hub.incoming <- msg
+		<-rs[0].Incoming()
+	}
+}
@@ -0,0 +1,120 @@
+package message
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Direction of a recorded frame, as seen from the client.
+type Direction string
+
+const (
+	// DirIncoming is a frame received from the server.
+	DirIncoming Direction = "in"
+	// DirOutgoing is a frame send to the server.
+	DirOutgoing Direction = "out"
+)
+
+// Record is a single websocket frame of a recorded session.
+type Record struct {
+	// Time the frame was read or written.
+	Time time.Time
+	// Direction of the frame.
+	Direction Direction
+	// Frame is the JSON message list as it was exchanged.
+	Frame json.RawMessage
+}
+
+// Recorder wraps a connection and writes every message frame that is read or
+// written to a writer, one JSON encoded Record per line.
+type Recorder struct {
+	Conn
+
+	m   sync.Mutex // Protects enc.
+	enc *json.Encoder
+}
+
+// NewRecorder returns a recorder that records frames on conn to w.
+func NewRecorder(conn Conn, w io.Writer) *Recorder {
+	return &Recorder{
+		Conn: conn,
+		enc:  json.NewEncoder(w),
+	}
+}
+
+// ReadMessage reads and records the next frame.
+func (r *Recorder) ReadMessage() (int, []byte, error) {
+	t, p, err := r.Conn.ReadMessage()
+	if err == nil && isDataMessage(t) {
+		r.record(DirIncoming, p)
+	}
+	return t, p, err
+}
+
+// ReadJSON reads and records the next frame and decodes it into v.
+func (r *Recorder) ReadJSON(v interface{}) error {
+	_, p, err := r.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, v)
+}
+
+// WriteMessage records and writes a frame.
+func (r *Recorder) WriteMessage(messageType int, data []byte) error {
+	if isDataMessage(messageType) {
+		r.record(DirOutgoing, data)
+	}
+	return r.Conn.WriteMessage(messageType, data)
+}
+
+// WriteJSON records and writes v as a JSON encoded frame.
+func (r *Recorder) WriteJSON(v interface{}) error {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return r.WriteMessage(websocket.TextMessage, p)
+}
+
+func (r *Recorder) record(dir Direction, p []byte) {
+	rec := Record{
+		Time:      time.Now(),
+		Direction: dir,
+		Frame:     p,
+	}
+	if !json.Valid(p) {
+		// Keep malformed frames as a JSON string.
+		rec.Frame, _ = json.Marshal(string(p))
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	if err := r.enc.Encode(rec); err != nil {
+		log.Printf("error recording frame: %v", err)
+	}
+}
+
+// ReadRecords reads all records written by a Recorder.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var recs []Record
+	dec := json.NewDecoder(r)
+	for {
+		var rec Record
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			return recs, nil
+		} else if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func isDataMessage(t int) bool {
+	return t == websocket.TextMessage || t == websocket.BinaryMessage
+}
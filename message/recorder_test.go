@@ -0,0 +1,72 @@
+package message
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// frameConn is a Conn that reads from a list of frames and stores written
+// frames. When wait is set reading blocks until it is closed.
+type frameConn struct {
+	in   [][]byte
+	out  [][]byte
+	wait chan struct{}
+}
+
+func (c *frameConn) ReadJSON(v interface{}) error  { panic("not implemented") }
+func (c *frameConn) WriteJSON(v interface{}) error { panic("not implemented") }
+func (c *frameConn) Close() error                  { return nil }
+
+func (c *frameConn) ReadMessage() (int, []byte, error) {
+	if c.wait != nil {
+		<-c.wait
+	}
+	if len(c.in) == 0 {
+		return 0, nil, &websocket.CloseError{Code: websocket.CloseNormalClosure}
+	}
+	p := c.in[0]
+	c.in = c.in[1:]
+	return websocket.TextMessage, p, nil
+}
+
+func (c *frameConn) WriteMessage(t int, p []byte) error {
+	c.out = append(c.out, p)
+	return nil
+}
+
+func TestRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	conn := &frameConn{
+		in: [][]byte{[]byte(`[{"Ok":{"Id":1}}]`)},
+	}
+	r := NewRecorder(conn, &buf)
+	if err := r.WriteJSON(OutgoingMessages{{Ping: &Empty{ID: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	var msgs IncomingMessages
+	if err := r.ReadJSON(&msgs); err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Ok == nil || msgs[0].Ok.ID != 1 {
+		t.Errorf("unexpected messages read: %+v", msgs)
+	}
+	if err := r.WriteMessage(websocket.CloseMessage, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err := ReadRecords(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(recs))
+	}
+	if recs[0].Direction != DirOutgoing || string(recs[0].Frame) != `[{"Ping":{"Id":1}}]` {
+		t.Errorf("unexpected outgoing record: %+v", recs[0])
+	}
+	if recs[1].Direction != DirIncoming || string(recs[1].Frame) != `[{"Ok":{"Id":1}}]` {
+		t.Errorf("unexpected incoming record: %+v", recs[1])
+	}
+}
@@ -0,0 +1,241 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Message is implemented by every message: IncomingMessage, OutgoingMessage,
+// Typed and Unknown.
+type Message interface {
+	// Type returns the name of the message type.
+	Type() string
+	// Message returns the id and message.
+	Message() (id uint32, v interface{})
+	// SetID sets the id of the message.
+	SetID(id uint32)
+}
+
+// Registry of message types by name.
+var registry = struct {
+	sync.RWMutex
+	types map[string]reflect.Type
+}{types: make(map[string]reflect.Type)}
+
+func init() {
+	for _, t := range incomingTypes {
+		Register(t.name, t.field(IncomingMessage{}))
+	}
+	for _, t := range outgoingTypes {
+		// Test is both an incoming and outgoing message.
+		if _, ok := registry.types[t.name]; ok {
+			continue
+		}
+		Register(t.name, t.field(OutgoingMessage{}))
+	}
+}
+
+// Register makes a message type known by name. Payload is a value (or
+// pointer to a value) of the struct the message decodes into and must have
+// an ID uint32 field. Messages of a registered type that has no field in
+// IncomingMessage or OutgoingMessage are stored in the Custom field.
+//
+// Register panics when the name is already registered or the payload has no
+// ID field.
+func Register(name string, payload interface{}) {
+	t := reflect.TypeOf(payload)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		panic("message: Register payload of " + name + " is not a struct")
+	}
+	if f, ok := t.FieldByName("ID"); !ok || f.Type.Kind() != reflect.Uint32 {
+		panic("message: Register payload of " + name + " has no ID uint32 field")
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.types[name]; ok {
+		panic("message: Register called twice for " + name)
+	}
+	registry.types[name] = t
+}
+
+// NewTyped returns an empty message of a registered type. False is returned
+// when the type is not registered.
+func NewTyped(name string) (*Typed, bool) {
+	registry.RLock()
+	t, ok := registry.types[name]
+	registry.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return &Typed{Name: name, Value: reflect.New(t).Interface()}, true
+}
+
+// Typed is a message of a registered type.
+type Typed struct {
+	// Name of the message type.
+	Name string
+	// Value is a pointer to the message.
+	Value interface{}
+}
+
+// Type returns the name of the message type.
+func (t Typed) Type() string {
+	return t.Name
+}
+
+// Message returns the id and message.
+func (t Typed) Message() (id uint32, v interface{}) {
+	p := reflect.ValueOf(t.Value)
+	if p.Kind() != reflect.Ptr || p.IsNil() {
+		return 0, nil
+	}
+	return payloadID(p), p.Elem().Interface()
+}
+
+// SetID sets the id of the message.
+func (t *Typed) SetID(id uint32) {
+	p := reflect.ValueOf(t.Value)
+	if p.Kind() != reflect.Ptr || p.IsNil() {
+		return
+	}
+	setPayloadID(p, id)
+}
+
+// MarshalJSON encodes the message as an object with the type name as key.
+func (t Typed) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{t.Name: t.Value})
+}
+
+// Type returns the name of the message type.
+func (u Unknown) Type() string {
+	return u.Name
+}
+
+// Message returns the id and message.
+func (u Unknown) Message() (id uint32, v interface{}) {
+	return u.ID, u
+}
+
+// SetID sets the id of the message.
+func (u *Unknown) SetID(id uint32) {
+	u.ID = id
+}
+
+// MarshalJSON encodes the message as an object with the type name as key.
+func (u Unknown) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]json.RawMessage{u.Name: u.withID()})
+}
+
+// Unmarshal decodes a frame of messages of any direction. Messages of a
+// registered type are returned as *Typed, others as *Unknown.
+func Unmarshal(frame []byte) ([]Message, error) {
+	var raw []map[string]json.RawMessage
+	if err := json.Unmarshal(frame, &raw); err != nil {
+		return nil, err
+	}
+	msgs := make([]Message, 0, len(raw))
+	for i, obj := range raw {
+		if len(obj) != 1 {
+			return nil, &InvalidMessageError{
+				Index: i,
+				Err:   fmt.Errorf("%d message types, want 1", len(obj)),
+			}
+		}
+		for name, data := range obj {
+			m, err := decodeTyped(name, data)
+			if err != nil {
+				return nil, &InvalidMessageError{Index: i, Err: err}
+			}
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs, nil
+}
+
+// Marshal encodes messages into a frame.
+func Marshal(msgs []Message) ([]byte, error) {
+	for _, m := range msgs {
+		if m == nil {
+			return nil, errors.New("nil message")
+		}
+	}
+	return json.Marshal(msgs)
+}
+
+// DecodeTyped decodes the data of a message as *Typed when the type is
+// registered, or as *Unknown otherwise.
+func decodeTyped(name string, data json.RawMessage) (Message, error) {
+	if t, ok := NewTyped(name); ok {
+		if err := json.Unmarshal(data, t.Value); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return t, nil
+	}
+	var id struct {
+		ID uint32 `json:"Id"`
+	}
+	// Messages without a valid id are kept with id 0.
+	json.Unmarshal(data, &id)
+	return &Unknown{Name: name, ID: id.ID, Data: data}, nil
+}
+
+// PayloadID returns the ID field of the message pointer p.
+func payloadID(p reflect.Value) uint32 {
+	return uint32(p.Elem().FieldByName("ID").Uint())
+}
+
+// SetPayloadID sets the ID field of the message pointer p.
+func setPayloadID(p reflect.Value, id uint32) {
+	p.Elem().FieldByName("ID").SetUint(uint64(id))
+}
+
+// PayloadDeviceIndex returns the DeviceIndex field of the message pointer p.
+func payloadDeviceIndex(p reflect.Value) (uint32, bool) {
+	if p.Kind() != reflect.Ptr || p.IsNil() {
+		return 0, false
+	}
+	f := p.Elem().FieldByName("DeviceIndex")
+	if !f.IsValid() || f.Kind() != reflect.Uint32 {
+		return 0, false
+	}
+	return uint32(f.Uint()), true
+}
+
+// ExtraType returns the type of a message that has no envelope field.
+func extraType(c *Typed, u *Unknown) string {
+	switch {
+	case c != nil:
+		return c.Type()
+	case u != nil:
+		return u.Type()
+	}
+	return ""
+}
+
+// ExtraMessage returns the id and message of a message that has no envelope
+// field.
+func extraMessage(c *Typed, u *Unknown) (uint32, interface{}) {
+	switch {
+	case c != nil:
+		return c.Message()
+	case u != nil:
+		return u.Message()
+	}
+	return 0, nil
+}
+
+// ExtraSetID sets the id of a message that has no envelope field.
+func extraSetID(c *Typed, u *Unknown, id uint32) {
+	switch {
+	case c != nil:
+		c.SetID(id)
+	case u != nil:
+		u.SetID(id)
+	}
+}
@@ -0,0 +1,159 @@
+package message
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// VendorCmd is a message that is not part of the spec.
+type VendorCmd struct {
+	ID          uint32 `json:"Id"`
+	DeviceIndex uint32
+	Pattern     []int
+}
+
+func init() {
+	Register("VendorCmd", VendorCmd{})
+}
+
+func TestRegister(t *testing.T) {
+	for _, c := range []struct {
+		Name    string
+		Payload interface{}
+	}{
+		{"VendorCmd", VendorCmd{}},
+		{"Ok", &Empty{}},
+		{"NoID", struct{ Speed float64 }{}},
+		{"NotStruct", 1},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: Register did not panic", c.Name)
+				}
+			}()
+			Register(c.Name, c.Payload)
+		}()
+	}
+	for _, name := range []string{"Ok", "Test", "ServerInfo", "RequestServerInfo", "VendorCmd"} {
+		if _, ok := NewTyped(name); !ok {
+			t.Errorf("%s not registered", name)
+		}
+	}
+}
+
+func TestCustomMessage(t *testing.T) {
+	frame := `[{"VendorCmd":{"DeviceIndex":2,"Id":5,"Pattern":[1,2]}}]`
+	var out OutgoingMessages
+	if err := json.Unmarshal([]byte(frame), &out); err != nil {
+		t.Fatal(err)
+	}
+	m := out[0]
+	if m.Type() != "VendorCmd" || m.Custom == nil {
+		t.Fatalf("custom message not decoded: %+v", m)
+	}
+	id, v := m.Message()
+	want := VendorCmd{ID: 5, DeviceIndex: 2, Pattern: []int{1, 2}}
+	if id != 5 || !reflect.DeepEqual(v, want) {
+		t.Errorf("got %d %#v, want %#v", id, v, want)
+	}
+	if i, ok := m.DeviceIndex(); !ok || i != 2 {
+		t.Errorf("DeviceIndex = %d, %t, want 2", i, ok)
+	}
+	m.SetID(6)
+	b, err := json.Marshal(OutgoingMessages{m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"VendorCmd":{"Id":6,"DeviceIndex":2,"Pattern":[1,2]}}]`; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+
+	var in IncomingMessages
+	if err := json.Unmarshal([]byte(frame), &in); err != nil {
+		t.Fatal(err)
+	}
+	if in[0].Custom == nil || in[0].Unknown != nil || in[0].Type() != "VendorCmd" {
+		t.Errorf("custom message not decoded: %+v", in[0])
+	}
+}
+
+func TestUnmarshalMarshal(t *testing.T) {
+	frame := `[{"Ok":{"Id":1}},{"VendorCmd":{"Id":2,"DeviceIndex":0,"Pattern":null}},{"RotateCmd":{"Id":3}}]`
+	msgs, err := Unmarshal([]byte(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for i, m := range msgs {
+		types = append(types, m.Type())
+		if id, _ := m.Message(); id != uint32(i+1) {
+			t.Errorf("%s: id %d, want %d", m.Type(), id, i+1)
+		}
+		m.SetID(uint32(i + 10))
+	}
+	if want := []string{"Ok", "VendorCmd", "RotateCmd"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("got types %v, want %v", types, want)
+	}
+	if _, ok := msgs[2].(*Unknown); !ok {
+		t.Errorf("unregistered type decoded as %T", msgs[2])
+	}
+	b, err := Marshal(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"Ok":{"Id":10}},{"VendorCmd":{"Id":11,"DeviceIndex":0,"Pattern":null}},{"RotateCmd":{"Id":12}}]`
+	if string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+	if _, err := Unmarshal([]byte(`[{"Ok":{"Id":1},"Test":{"Id":1}}]`)); err == nil {
+		t.Errorf("message with two types accepted")
+	}
+}
+
+func TestEnvelopeFields(t *testing.T) {
+	for _, m := range []interface {
+		Message
+		ID() uint32
+		DeviceIndex() (uint32, bool)
+	}{&IncomingMessage{}, &OutgoingMessage{}} {
+		v := reflect.ValueOf(m).Elem()
+		var names []string
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			names = append(names, name)
+			v.Set(reflect.Zero(v.Type()))
+			v.Field(i).Set(reflect.New(f.Type.Elem()))
+			m.SetID(7)
+			if got := m.Type(); got != name {
+				t.Errorf("%s: Type() = %q", name, got)
+			}
+			if id, p := m.Message(); id != 7 || m.ID() != 7 || p == nil {
+				t.Errorf("%s: Message() = %d, %v, ID() = %d", name, id, p, m.ID())
+			}
+			_, hasIndex := f.Type.Elem().FieldByName("DeviceIndex")
+			if _, ok := m.DeviceIndex(); ok != hasIndex {
+				t.Errorf("%s: DeviceIndex() ok = %v", name, ok)
+			}
+		}
+		var types []string
+		if _, ok := m.(*IncomingMessage); ok {
+			for _, typ := range incomingTypes {
+				types = append(types, typ.name)
+			}
+		} else {
+			for _, typ := range outgoingTypes {
+				types = append(types, typ.name)
+			}
+		}
+		if !reflect.DeepEqual(types, names) {
+			t.Errorf("%T: types %v, want fields %v", m, types, names)
+		}
+	}
+}
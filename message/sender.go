@@ -1,6 +1,7 @@
 package message
 
 import (
+	"context"
 	"errors"
 	"log"
 	"sync"
@@ -11,6 +12,21 @@ import (
 // buffersize is the amount of messages buffered by the Sender.
 const bufferSize = 256
 
+var (
+	// ErrQueueFull is returned when a message is send while the send queue
+	// is full.
+	ErrQueueFull = errors.New("write buffer full")
+	// ErrDropped is returned when a queued message is removed to make room
+	// for a newer message, or when it is superseded by a stop command.
+	ErrDropped = errors.New("message dropped from send queue")
+	// ErrReplaced is returned when a queued message is replaced by a newer
+	// message for the same device and of the same type.
+	ErrReplaced = errors.New("message replaced by newer message")
+	// ErrSenderStopped is returned for messages that are not written
+	// because the Sender is stopped.
+	ErrSenderStopped = errors.New("sender stopped")
+)
+
 // IDCounter is a concurrent safe id counter used for message id's.
 type IDCounter struct {
 	sync.Mutex
@@ -28,40 +44,129 @@ func (c *IDCounter) Generate() uint32 {
 	return c.value
 }
 
+// QueuePolicy decides how a Sender handles messages when the send queue is
+// full.
+type QueuePolicy int
+
+const (
+	// QueueError returns ErrQueueFull.
+	QueueError QueuePolicy = iota
+	// QueueBlock waits until there is room in the queue or the context is
+	// done.
+	QueueBlock
+	// QueueDropOldest removes the oldest message from the queue.
+	QueueDropOldest
+	// QueueCoalesce replaces a queued device message for the same device
+	// and of the same type, also when the queue is not full. The replaced
+	// message keeps its place in the queue. ErrQueueFull is returned when
+	// the queue is full and there is no message to replace.
+	QueueCoalesce
+)
+
 // Sender buffers and sends Buttplug messages over a websocket connection.
+// StopDeviceCmd and StopAllDevices messages are send before all other queued
+// messages and remove queued commands for the stopped devices.
 type Sender struct {
-	out  chan OutgoingMessage // buffered channelfor outgoing messages.
-	once sync.Once            // Make sure Stop() is execute only once.
+	policy  QueuePolicy
+	size    int
+	metrics Metrics
+
+	m       sync.Mutex    // Protects queue, prio, space and stopped.
+	queue   []*outgoing   // Queued messages.
+	prio    []*outgoing   // Queued stop messages, send first.
+	space   chan struct{} // Closed when a message is removed from queue.
+	stopped bool          // Stop was called.
+
+	wake chan struct{} // Signals writeLoop that a message is queued.
+	once sync.Once     // Make sure Stop() is execute only once.
 	stop chan bool
 }
 
-// NewSender creates a Sender for the given websocket.
-func NewSender(conn *websocket.Conn) (b *Sender) {
-	out := make(chan OutgoingMessage, bufferSize)
+// outgoing is a queued message.
+type outgoing struct {
+	msg     OutgoingMessage
+	written chan error // Receives the result of writing msg.
+	typ     string     // Message type of a device message.
+	index   uint32     // Device index of a device message.
+	device  bool       // Msg is a device message.
+}
+
+func newOutgoing(m OutgoingMessage) *outgoing {
+	o := &outgoing{msg: m, written: make(chan error, 1)}
+	if o.index, o.device = m.DeviceIndex(); o.device {
+		o.typ = m.Type()
+	}
+	return o
+}
+
+// SenderOption configures a Sender.
+type SenderOption func(*Sender)
+
+// SendPolicy sets how the Sender handles a full send queue. QueueError is
+// the default.
+func SendPolicy(p QueuePolicy) SenderOption {
+	return func(b *Sender) {
+		b.policy = p
+	}
+}
+
+// QueueSize sets the amount of messages buffered by the Sender.
+func QueueSize(n int) SenderOption {
+	return func(b *Sender) {
+		if n > 0 {
+			b.size = n
+		}
+	}
+}
+
+// SenderMetrics reports the messages written, write and queue errors, and
+// the depth of the send queue to m.
+func SenderMetrics(m Metrics) SenderOption {
+	return func(b *Sender) {
+		b.metrics = m
+	}
+}
+
+// NewSender creates a Sender for the given connection.
+func NewSender(conn Conn, opts ...SenderOption) (b *Sender) {
 	b = &Sender{
-		stop: make(chan bool),
-		out:  out,
+		size:  bufferSize,
+		space: make(chan struct{}),
+		wake:  make(chan struct{}, 1),
+		stop:  make(chan bool),
+	}
+	for _, opt := range opts {
+		opt(b)
 	}
+	b.metrics = metricsOrNop(b.metrics)
 	go b.writeLoop(conn)
 	return
 }
 
 // writeLoop reads messages from buffer and sends them over the websocket.
-func (b *Sender) writeLoop(conn *websocket.Conn) {
-Stop:
+func (b *Sender) writeLoop(conn Conn) {
+	w := &frameWriter{conn: conn}
 	for {
-		select {
-		case <-b.stop:
-			break Stop
-		case v := <-b.out:
-			err := conn.WriteJSON(OutgoingMessages{v})
-			if err == websocket.ErrCloseSent {
-				return
-			} else if err != nil {
-				log.Printf("error during write: %v", err)
-			}
+		o, ok := b.next()
+		if !ok {
+			break
+		}
+		err := w.write(o.msg)
+		if err == nil {
+			b.metrics.Add(MetricSent, o.msg.Type(), 1)
+		} else {
+			b.metrics.Add(MetricErrors, "write", 1)
+		}
+		o.written <- err
+		if err == websocket.ErrCloseSent {
+			b.Stop()
+			b.flush()
+			return
+		} else if err != nil {
+			log.Printf("error during write: %v", err)
 		}
 	}
+	b.flush()
 	err := conn.WriteMessage(
 		websocket.CloseMessage,
 		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
@@ -71,19 +176,201 @@ Stop:
 	}
 }
 
-// Send a message to the server.
+// Next waits for the next message to write. False is returned when the
+// sender is stopped.
+func (b *Sender) next() (*outgoing, bool) {
+	for {
+		select {
+		case <-b.stop:
+			return nil, false
+		default:
+		}
+		b.m.Lock()
+		var o *outgoing
+		if len(b.prio) > 0 {
+			o, b.prio = b.prio[0], b.prio[1:]
+		} else if len(b.queue) > 0 {
+			o, b.queue = b.queue[0], b.queue[1:]
+			b.freed()
+		}
+		if o != nil {
+			b.depth()
+		}
+		b.m.Unlock()
+		if o != nil {
+			return o, true
+		}
+		select {
+		case <-b.wake:
+		case <-b.stop:
+			return nil, false
+		}
+	}
+}
+
+// Flush fails all queued messages after the sender is stopped.
+func (b *Sender) flush() {
+	b.m.Lock()
+	defer b.m.Unlock()
+	for _, o := range append(b.prio, b.queue...) {
+		o.written <- ErrSenderStopped
+	}
+	b.prio, b.queue = nil, nil
+	b.depth()
+}
+
+// Depth reports the amount of queued messages. Caller must hold the lock.
+func (b *Sender) depth() {
+	b.metrics.Set(MetricSendQueue, int64(len(b.prio)+len(b.queue)))
+}
+
+// Dropped reports a queued message that is not written and sends err to it.
+func (b *Sender) dropped(o *outgoing, err error) {
+	class := "dropped"
+	if err == ErrReplaced {
+		class = "replaced"
+	}
+	b.metrics.Add(MetricErrors, class, 1)
+	o.written <- err
+}
+
+// Freed wakes up senders that wait for room in the queue. Caller must hold
+// the lock.
+func (b *Sender) freed() {
+	if b.policy != QueueBlock {
+		return
+	}
+	close(b.space)
+	b.space = make(chan struct{})
+}
+
+// Send a message to the server. Send returns when the message is queued,
+// write errors are logged.
 func (b *Sender) Send(m OutgoingMessage) error {
+	_, err := b.Queue(context.Background(), m)
+	return err
+}
+
+// SendContext sends a message to the server and waits until it is written.
+// The error of the write is returned, or why the message was not written.
+func (b *Sender) SendContext(ctx context.Context, m OutgoingMessage) error {
+	written, err := b.Queue(ctx, m)
+	if err != nil {
+		return err
+	}
+	select {
+	case err := <-written:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Queue adds a message to the send queue. The returned channel receives the
+// result of writing the message: nil, the write error, or ErrDropped,
+// ErrReplaced or ErrSenderStopped when the message is not written. With
+// QueueBlock Queue waits until there is room in the queue or ctx is done.
+func (b *Sender) Queue(ctx context.Context, m OutgoingMessage) (<-chan error, error) {
+	o := newOutgoing(m)
+	for {
+		b.m.Lock()
+		if b.stopped {
+			b.m.Unlock()
+			return nil, ErrSenderStopped
+		}
+		if m.StopDeviceCmd != nil || m.StopAllDevices != nil {
+			b.queueStop(o)
+			b.depth()
+			b.m.Unlock()
+			b.signal()
+			return o.written, nil
+		}
+		if b.policy == QueueCoalesce && b.replace(o) {
+			b.m.Unlock()
+			return o.written, nil
+		}
+		if len(b.queue) < b.size {
+			b.queue = append(b.queue, o)
+			b.depth()
+			b.m.Unlock()
+			b.signal()
+			return o.written, nil
+		}
+		switch b.policy {
+		case QueueDropOldest:
+			b.dropped(b.queue[0], ErrDropped)
+			b.queue = append(b.queue[1:], o)
+			b.m.Unlock()
+			return o.written, nil
+		case QueueBlock:
+			space := b.space
+			b.m.Unlock()
+			select {
+			case <-space:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-b.stop:
+				return nil, ErrSenderStopped
+			}
+		default:
+			b.m.Unlock()
+			b.metrics.Add(MetricErrors, "queue_full", 1)
+			return nil, ErrQueueFull
+		}
+	}
+}
+
+// QueueStop adds a stop message to the priority lane and removes queued
+// commands for the devices it stops. Caller must hold the lock.
+func (b *Sender) queueStop(o *outgoing) {
+	b.prio = append(b.prio, o)
+	queue := b.queue[:0]
+	for _, q := range b.queue {
+		if q.device && (o.msg.StopAllDevices != nil || q.index == o.index) {
+			b.dropped(q, ErrDropped)
+			continue
+		}
+		queue = append(queue, q)
+	}
+	if len(queue) < len(b.queue) {
+		for i := len(queue); i < len(b.queue); i++ {
+			b.queue[i] = nil
+		}
+		b.queue = queue
+		b.freed()
+	}
+}
+
+// Replace replaces a queued message for the same device and of the same
+// type. Caller must hold the lock.
+func (b *Sender) replace(o *outgoing) bool {
+	if !o.device {
+		return false
+	}
+	for i, q := range b.queue {
+		if q.device && q.index == o.index && q.typ == o.typ {
+			b.dropped(q, ErrReplaced)
+			b.queue[i] = o
+			return true
+		}
+	}
+	return false
+}
+
+// Signal wakes up the writeLoop.
+func (b *Sender) signal() {
 	select {
-	case b.out <- m:
-		return nil
+	case b.wake <- struct{}{}:
 	default:
-		return errors.New("write buffer full")
 	}
 }
 
 // Stop causes the sender to stop sending messages.
 func (b *Sender) Stop() {
 	b.once.Do(func() {
+		b.m.Lock()
+		b.stopped = true
+		b.m.Unlock()
 		close(b.stop)
 	})
 }
@@ -1,6 +1,8 @@
 package message
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -96,3 +98,213 @@ func readLoop(c *websocket.Conn) {
 		}
 	}
 }
+
+// gateConn is a Conn that holds every written data frame until it is
+// released.
+type gateConn struct {
+	frameConn
+	writing chan []byte // Receives frames that are being written.
+	release chan error  // Result of the write.
+}
+
+func newGateConn() *gateConn {
+	return &gateConn{
+		writing: make(chan []byte),
+		release: make(chan error),
+	}
+}
+
+func (c *gateConn) WriteMessage(t int, p []byte) error {
+	if t != websocket.TextMessage {
+		return nil
+	}
+	c.writing <- append([]byte(nil), p...)
+	return <-c.release
+}
+
+// written waits for the next frame that is written and lets it complete
+// with err.
+func (c *gateConn) written(t *testing.T, err error) string {
+	select {
+	case p := <-c.writing:
+		c.release <- err
+		return string(p)
+	case <-time.After(time.Second):
+		t.Fatal("no frame written")
+	}
+	return ""
+}
+
+func vibrate(index uint32, speed float64) OutgoingMessage {
+	return OutgoingMessage{
+		SingleMotorVibrateCmd: &SingleMotorVibrateCmd{ID: 1, DeviceIndex: index, Speed: speed},
+	}
+}
+
+func result(t *testing.T, c <-chan error) error {
+	select {
+	case err := <-c:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("no result")
+	}
+	return nil
+}
+
+// queued starts a sender with the writer blocked on a first message and
+// queues msgs.
+func queued(t *testing.T, conn *gateConn, opts []SenderOption, msgs ...OutgoingMessage) (*Sender, []<-chan error) {
+	s := NewSender(conn, append(opts, QueueSize(2))...)
+	if err := s.Send(OutgoingMessage{Ping: &Empty{ID: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	// Wait until the writer is busy.
+	p := <-conn.writing
+	if !strings.Contains(string(p), "Ping") {
+		t.Fatalf("unexpected frame %s", p)
+	}
+	var results []<-chan error
+	for _, m := range msgs {
+		c, err := s.Queue(context.Background(), m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, c)
+	}
+	return s, results
+}
+
+func TestSendQueueFull(t *testing.T) {
+	conn := newGateConn()
+	s, _ := queued(t, conn, nil, vibrate(1, 0.1), vibrate(1, 0.2))
+	defer s.Stop()
+	if err := s.Send(vibrate(1, 0.3)); err != ErrQueueFull {
+		t.Errorf("got %v, want ErrQueueFull", err)
+	}
+	conn.release <- nil
+}
+
+func TestSendDropOldest(t *testing.T) {
+	conn := newGateConn()
+	s, res := queued(t, conn, []SenderOption{SendPolicy(QueueDropOldest)},
+		vibrate(1, 0.1), vibrate(1, 0.2))
+	defer s.Stop()
+	c, err := s.Queue(context.Background(), vibrate(1, 0.3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := result(t, res[0]); err != ErrDropped {
+		t.Errorf("got %v, want ErrDropped", err)
+	}
+	conn.release <- nil
+	for _, want := range []string{"0.2", "0.3"} {
+		if p := conn.written(t, nil); !strings.Contains(p, want) {
+			t.Errorf("got %s, want speed %s", p, want)
+		}
+	}
+	if err := result(t, c); err != nil {
+		t.Errorf("write error: %v", err)
+	}
+}
+
+func TestSendCoalesce(t *testing.T) {
+	conn := newGateConn()
+	s, res := queued(t, conn, []SenderOption{SendPolicy(QueueCoalesce)},
+		vibrate(1, 0.1), vibrate(2, 0.2))
+	defer s.Stop()
+	if err := s.Send(vibrate(1, 0.3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := result(t, res[0]); err != ErrReplaced {
+		t.Errorf("got %v, want ErrReplaced", err)
+	}
+	if err := s.Send(vibrate(3, 0.4)); err != ErrQueueFull {
+		t.Errorf("got %v, want ErrQueueFull", err)
+	}
+	conn.release <- nil
+	for _, want := range []string{`"DeviceIndex":1,"Speed":0.3`, `"DeviceIndex":2,"Speed":0.2`} {
+		if p := conn.written(t, nil); !strings.Contains(p, want) {
+			t.Errorf("got %s, want %s", p, want)
+		}
+	}
+}
+
+func TestSendBlock(t *testing.T) {
+	conn := newGateConn()
+	s, _ := queued(t, conn, []SenderOption{SendPolicy(QueueBlock)},
+		vibrate(1, 0.1), vibrate(1, 0.2))
+	defer s.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.SendContext(ctx, vibrate(1, 0.3)); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want deadline exceeded", err)
+	}
+	errc := make(chan error)
+	go func() {
+		errc <- s.SendContext(context.Background(), vibrate(1, 0.4))
+	}()
+	conn.release <- nil
+	for _, want := range []string{"0.1", "0.2", "0.4"} {
+		if p := conn.written(t, nil); !strings.Contains(p, want) {
+			t.Errorf("got %s, want speed %s", p, want)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("write error: %v", err)
+	}
+}
+
+func TestSendStopPriority(t *testing.T) {
+	conn := newGateConn()
+	s, res := queued(t, conn, nil, vibrate(1, 0.1), vibrate(2, 0.2))
+	defer s.Stop()
+	if err := s.Send(OutgoingMessage{StopDeviceCmd: &Device{ID: 2, DeviceIndex: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := result(t, res[0]); err != ErrDropped {
+		t.Errorf("got %v, want ErrDropped", err)
+	}
+	conn.release <- nil
+	for _, want := range []string{"StopDeviceCmd", `"DeviceIndex":2`} {
+		if p := conn.written(t, nil); !strings.Contains(p, want) {
+			t.Errorf("got %s, want %s", p, want)
+		}
+	}
+
+	if err := s.Send(vibrate(2, 0.3)); err != nil {
+		t.Fatal(err)
+	}
+	if p := <-conn.writing; !strings.Contains(string(p), "0.3") {
+		t.Fatalf("unexpected frame %s", p)
+	}
+	c, _ := s.Queue(context.Background(), vibrate(2, 0.4))
+	if err := s.Send(OutgoingMessage{StopAllDevices: &Empty{ID: 3}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := result(t, c); err != ErrDropped {
+		t.Errorf("got %v, want ErrDropped", err)
+	}
+	conn.release <- nil
+	if p := conn.written(t, nil); !strings.Contains(p, "StopAllDevices") {
+		t.Errorf("got %s, want StopAllDevices", p)
+	}
+}
+
+func TestSendWriteError(t *testing.T) {
+	conn := newGateConn()
+	s := NewSender(conn)
+	defer s.Stop()
+	errc := make(chan error)
+	go func() {
+		errc <- s.SendContext(context.Background(), vibrate(1, 0.1))
+	}()
+	werr := errors.New("write failed")
+	conn.written(t, werr)
+	if err := <-errc; err != werr {
+		t.Errorf("got %v, want %v", err, werr)
+	}
+	s.Stop()
+	if err := s.Send(vibrate(1, 0.1)); err != ErrSenderStopped {
+		t.Errorf("got %v, want ErrSenderStopped", err)
+	}
+}
@@ -0,0 +1,267 @@
+package message
+
+// incomingType describes a built-in incoming message type.
+type incomingType struct {
+	name     string   // Message type name.
+	required []string // Required fields from the JSON schema of the spec.
+	// Field returns the message field of m.
+	field func(m IncomingMessage) payload
+	// Scan sets the message field of m to the message decoded by s.
+	scan func(s *scanner, m *IncomingMessage)
+}
+
+// outgoingType describes a built-in outgoing message type.
+type outgoingType struct {
+	name     string   // Message type name.
+	required []string // Required fields from the JSON schema of the spec.
+	// Field returns the message field of m.
+	field func(m OutgoingMessage) payload
+	// Append appends the JSON encoding of the message field of m to dst.
+	append func(dst []byte, m *OutgoingMessage) ([]byte, error)
+}
+
+// incomingTypes are the built-in incoming message types in the order of the
+// IncomingMessage fields.
+var incomingTypes = []incomingType{{
+	name:     "Ok",
+	required: []string{"Id"},
+	field:    func(m IncomingMessage) payload { return m.Ok },
+	scan:     func(s *scanner, m *IncomingMessage) { m.Ok = new(Empty); s.empty(m.Ok) },
+}, {
+	name:     "Error",
+	required: []string{"Id", "ErrorMessage"},
+	field:    func(m IncomingMessage) payload { return m.Error },
+	scan:     func(s *scanner, m *IncomingMessage) { m.Error = new(Error); s.error(m.Error) },
+}, {
+	name:     "Test",
+	required: []string{"Id", "TestString"},
+	field:    func(m IncomingMessage) payload { return m.Test },
+	scan:     func(s *scanner, m *IncomingMessage) { m.Test = new(Test); s.test(m.Test) },
+}, {
+	name:     "Log",
+	required: []string{"Id", "LogLevel", "LogMessage"},
+	field:    func(m IncomingMessage) payload { return m.Log },
+	scan:     func(s *scanner, m *IncomingMessage) { m.Log = new(Log); s.log(m.Log) },
+}, {
+	name:     "ServerInfo",
+	required: []string{"Id", "ServerName", "MessageVersion", "MaxPingTime"},
+	field:    func(m IncomingMessage) payload { return m.ServerInfo },
+	scan:     func(s *scanner, m *IncomingMessage) { m.ServerInfo = new(ServerInfo); s.serverInfo(m.ServerInfo) },
+}, {
+	name:     "ScanningFinished",
+	required: []string{"Id"},
+	field:    func(m IncomingMessage) payload { return m.ScanningFinished },
+	scan:     func(s *scanner, m *IncomingMessage) { m.ScanningFinished = new(Empty); s.empty(m.ScanningFinished) },
+}, {
+	name:     "DeviceList",
+	required: []string{"Id", "Devices"},
+	field:    func(m IncomingMessage) payload { return m.DeviceList },
+	scan:     func(s *scanner, m *IncomingMessage) { m.DeviceList = new(DeviceList); s.deviceList(m.DeviceList) },
+}, {
+	name:     "DeviceAdded",
+	required: []string{"Id", "DeviceName", "DeviceIndex", "DeviceMessages"},
+	field:    func(m IncomingMessage) payload { return m.DeviceAdded },
+	scan:     func(s *scanner, m *IncomingMessage) { m.DeviceAdded = new(Device); s.device(m.DeviceAdded) },
+}, {
+	name:     "DeviceRemoved",
+	required: []string{"Id", "DeviceIndex"},
+	field:    func(m IncomingMessage) payload { return m.DeviceRemoved },
+	scan:     func(s *scanner, m *IncomingMessage) { m.DeviceRemoved = new(Device); s.device(m.DeviceRemoved) },
+}}
+
+// outgoingTypes are the built-in outgoing message types in the order of the
+// OutgoingMessage fields.
+var outgoingTypes = []outgoingType{{
+	name:     "Ping",
+	required: []string{"Id"},
+	field:    func(m OutgoingMessage) payload { return m.Ping },
+	append:   func(dst []byte, m *OutgoingMessage) ([]byte, error) { return appendEmpty(dst, m.Ping), nil },
+}, {
+	name:     "Test",
+	required: []string{"Id", "TestString"},
+	field:    func(m OutgoingMessage) payload { return m.Test },
+	append:   func(dst []byte, m *OutgoingMessage) ([]byte, error) { return appendTest(dst, m.Test), nil },
+}, {
+	name:     "RequestLog",
+	required: []string{"Id", "LogLevel"},
+	field:    func(m OutgoingMessage) payload { return m.RequestLog },
+	append:   func(dst []byte, m *OutgoingMessage) ([]byte, error) { return appendRequestLog(dst, m.RequestLog), nil },
+}, {
+	name:     "RequestServerInfo",
+	required: []string{"Id", "ClientName"},
+	field:    func(m OutgoingMessage) payload { return m.RequestServerInfo },
+	append: func(dst []byte, m *OutgoingMessage) ([]byte, error) {
+		return appendRequestServerInfo(dst, m.RequestServerInfo), nil
+	},
+}, {
+	name:     "StartScanning",
+	required: []string{"Id"},
+	field:    func(m OutgoingMessage) payload { return m.StartScanning },
+	append:   func(dst []byte, m *OutgoingMessage) ([]byte, error) { return appendEmpty(dst, m.StartScanning), nil },
+}, {
+	name:     "StopScanning",
+	required: []string{"Id"},
+	field:    func(m OutgoingMessage) payload { return m.StopScanning },
+	append:   func(dst []byte, m *OutgoingMessage) ([]byte, error) { return appendEmpty(dst, m.StopScanning), nil },
+}, {
+	name:     "RequestDeviceList",
+	required: []string{"Id"},
+	field:    func(m OutgoingMessage) payload { return m.RequestDeviceList },
+	append: func(dst []byte, m *OutgoingMessage) ([]byte, error) {
+		return appendEmpty(dst, m.RequestDeviceList), nil
+	},
+}, {
+	name:     "StopDeviceCmd",
+	required: []string{"Id", "DeviceIndex"},
+	field:    func(m OutgoingMessage) payload { return m.StopDeviceCmd },
+	append:   func(dst []byte, m *OutgoingMessage) ([]byte, error) { return appendDevice(dst, m.StopDeviceCmd), nil },
+}, {
+	name:     "StopAllDevices",
+	required: []string{"Id"},
+	field:    func(m OutgoingMessage) payload { return m.StopAllDevices },
+	append:   func(dst []byte, m *OutgoingMessage) ([]byte, error) { return appendEmpty(dst, m.StopAllDevices), nil },
+}, {
+	name:     "RawCmd",
+	required: []string{"Id", "DeviceIndex", "Command"},
+	field:    func(m OutgoingMessage) payload { return m.RawCmd },
+	append:   func(dst []byte, m *OutgoingMessage) ([]byte, error) { return appendRawCmd(dst, m.RawCmd), nil },
+}, {
+	name:     "SingleMotorVibrateCmd",
+	required: []string{"Id", "DeviceIndex", "Speed"},
+	field:    func(m OutgoingMessage) payload { return m.SingleMotorVibrateCmd },
+	append: func(dst []byte, m *OutgoingMessage) ([]byte, error) {
+		return appendSingleMotorVibrateCmd(dst, m.SingleMotorVibrateCmd)
+	},
+}, {
+	name:     "KiirooCmd",
+	required: []string{"Id", "DeviceIndex", "Command"},
+	field:    func(m OutgoingMessage) payload { return m.KiirooCmd },
+	append:   func(dst []byte, m *OutgoingMessage) ([]byte, error) { return appendKiirooCmd(dst, m.KiirooCmd), nil },
+}, {
+	name:     "FleshlightLaunchFW12Cmd",
+	required: []string{"Id", "DeviceIndex", "Position", "Speed"},
+	field:    func(m OutgoingMessage) payload { return m.FleshlightLaunchFW12Cmd },
+	append: func(dst []byte, m *OutgoingMessage) ([]byte, error) {
+		return appendFleshlightLaunchFW12Cmd(dst, m.FleshlightLaunchFW12Cmd), nil
+	},
+}, {
+	name:     "LovenseCmd",
+	required: []string{"Id", "DeviceIndex", "Command"},
+	field:    func(m OutgoingMessage) payload { return m.LovenseCmd },
+	append:   func(dst []byte, m *OutgoingMessage) ([]byte, error) { return appendLovenseCmd(dst, m.LovenseCmd), nil },
+}, {
+	name:     "VorzeA10CycloneCmd",
+	required: []string{"Id", "DeviceIndex", "Speed", "Clockwise"},
+	field:    func(m OutgoingMessage) payload { return m.VorzeA10CycloneCmd },
+	append: func(dst []byte, m *OutgoingMessage) ([]byte, error) {
+		return appendVorzeA10CycloneCmd(dst, m.VorzeA10CycloneCmd), nil
+	},
+}}
+
+// Built-in message types by name.
+var (
+	incomingByName = make(map[string]*incomingType)
+	outgoingByName = make(map[string]*outgoingType)
+)
+
+func init() {
+	for i := range incomingTypes {
+		incomingByName[incomingTypes[i].name] = &incomingTypes[i]
+	}
+	for i := range outgoingTypes {
+		outgoingByName[outgoingTypes[i].name] = &outgoingTypes[i]
+	}
+}
+
+// incoming returns the type and field of the first set built-in message of
+// m, nil when no built-in message is set.
+func (m IncomingMessage) incoming() (*incomingType, payload) {
+	for i := range incomingTypes {
+		if p := incomingTypes[i].field(m); !p.isNil() {
+			return &incomingTypes[i], p
+		}
+	}
+	return nil, nil
+}
+
+// outgoing returns the type and field of the first set built-in message of
+// m, nil when no built-in message is set.
+func (m OutgoingMessage) outgoing() (*outgoingType, payload) {
+	for i := range outgoingTypes {
+		if p := outgoingTypes[i].field(m); !p.isNil() {
+			return &outgoingTypes[i], p
+		}
+	}
+	return nil, nil
+}
+
+// payload is a pointer to a built-in message struct.
+type payload interface {
+	// IsNil reports whether the pointer is nil.
+	isNil() bool
+	// Fields returns the id and device index fields, index is nil when
+	// the message has no device index.
+	fields() (id, index *uint32)
+	// Value returns a copy of the message.
+	value() interface{}
+}
+
+func (m *Empty) isNil() bool                 { return m == nil }
+func (m *Empty) fields() (id, index *uint32) { return &m.ID, nil }
+func (m *Empty) value() interface{}          { return *m }
+
+func (m *Error) isNil() bool                 { return m == nil }
+func (m *Error) fields() (id, index *uint32) { return &m.ID, nil }
+func (m *Error) value() interface{}          { return *m }
+
+func (m *Test) isNil() bool                 { return m == nil }
+func (m *Test) fields() (id, index *uint32) { return &m.ID, nil }
+func (m *Test) value() interface{}          { return *m }
+
+func (m *Log) isNil() bool                 { return m == nil }
+func (m *Log) fields() (id, index *uint32) { return &m.ID, nil }
+func (m *Log) value() interface{}          { return *m }
+
+func (m *ServerInfo) isNil() bool                 { return m == nil }
+func (m *ServerInfo) fields() (id, index *uint32) { return &m.ID, nil }
+func (m *ServerInfo) value() interface{}          { return *m }
+
+func (m *DeviceList) isNil() bool                 { return m == nil }
+func (m *DeviceList) fields() (id, index *uint32) { return &m.ID, nil }
+func (m *DeviceList) value() interface{}          { return *m }
+
+func (m *Device) isNil() bool                 { return m == nil }
+func (m *Device) fields() (id, index *uint32) { return &m.ID, &m.DeviceIndex }
+func (m *Device) value() interface{}          { return *m }
+
+func (m *RequestLog) isNil() bool                 { return m == nil }
+func (m *RequestLog) fields() (id, index *uint32) { return &m.ID, nil }
+func (m *RequestLog) value() interface{}          { return *m }
+
+func (m *RequestServerInfo) isNil() bool                 { return m == nil }
+func (m *RequestServerInfo) fields() (id, index *uint32) { return &m.ID, nil }
+func (m *RequestServerInfo) value() interface{}          { return *m }
+
+func (m *RawCmd) isNil() bool                 { return m == nil }
+func (m *RawCmd) fields() (id, index *uint32) { return &m.ID, &m.DeviceIndex }
+func (m *RawCmd) value() interface{}          { return *m }
+
+func (m *SingleMotorVibrateCmd) isNil() bool                 { return m == nil }
+func (m *SingleMotorVibrateCmd) fields() (id, index *uint32) { return &m.ID, &m.DeviceIndex }
+func (m *SingleMotorVibrateCmd) value() interface{}          { return *m }
+
+func (m *KiirooCmd) isNil() bool                 { return m == nil }
+func (m *KiirooCmd) fields() (id, index *uint32) { return &m.ID, &m.DeviceIndex }
+func (m *KiirooCmd) value() interface{}          { return *m }
+
+func (m *FleshlightLaunchFW12Cmd) isNil() bool                 { return m == nil }
+func (m *FleshlightLaunchFW12Cmd) fields() (id, index *uint32) { return &m.ID, &m.DeviceIndex }
+func (m *FleshlightLaunchFW12Cmd) value() interface{}          { return *m }
+
+func (m *LovenseCmd) isNil() bool                 { return m == nil }
+func (m *LovenseCmd) fields() (id, index *uint32) { return &m.ID, &m.DeviceIndex }
+func (m *LovenseCmd) value() interface{}          { return *m }
+
+func (m *VorzeA10CycloneCmd) isNil() bool                 { return m == nil }
+func (m *VorzeA10CycloneCmd) fields() (id, index *uint32) { return &m.ID, &m.DeviceIndex }
+func (m *VorzeA10CycloneCmd) value() interface{}          { return *m }
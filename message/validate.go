@@ -0,0 +1,113 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+)
+
+// logLevels are the valid log levels.
+var logLevels = map[string]bool{
+	LogLevelOff:   true,
+	LogLevelFatal: true,
+	LogLevelError: true,
+	LogLevelWarn:  true,
+	LogLevelInfo:  true,
+	LogLevelDebug: true,
+	LogLevelTrace: true,
+}
+
+// Validate checks if the message is valid according to the message spec.
+// Custom messages and messages of an unknown type are always valid.
+func (m IncomingMessage) Validate() error {
+	id, v := m.Message()
+	if v == nil {
+		return errors.New("no message")
+	}
+	typ := m.Type()
+	switch {
+	case m.Custom != nil, m.Unknown != nil:
+		return nil
+	case m.Log != nil, m.ScanningFinished != nil, m.DeviceAdded != nil,
+		m.DeviceRemoved != nil:
+		// Events are not a reply to a client message.
+		if id != 0 {
+			return fmt.Errorf("%s: Id %d, want 0", typ, id)
+		}
+	case m.Ok != nil, m.ServerInfo != nil, m.DeviceList != nil, m.Test != nil:
+		if id == 0 {
+			return fmt.Errorf("%s: Id 0 is reserved for events", typ)
+		}
+	}
+	switch {
+	case m.Log != nil:
+		if !logLevels[m.Log.LogLevel] {
+			return fmt.Errorf("Log: invalid level %q", m.Log.LogLevel)
+		}
+	case m.DeviceAdded != nil:
+		return validateDevice(*m.DeviceAdded)
+	case m.DeviceList != nil:
+		seen := make(map[uint32]bool)
+		for _, d := range m.DeviceList.Devices {
+			if err := validateDevice(d); err != nil {
+				return err
+			}
+			if seen[d.DeviceIndex] {
+				return fmt.Errorf("DeviceList: duplicate device index %d", d.DeviceIndex)
+			}
+			seen[d.DeviceIndex] = true
+		}
+	}
+	return nil
+}
+
+// ValidateDevice checks if a device has a name and supported messages.
+func validateDevice(d Device) error {
+	if d.DeviceName == "" {
+		return fmt.Errorf("device %d: empty name", d.DeviceIndex)
+	}
+	if len(d.DeviceMessages) == 0 {
+		return fmt.Errorf("device %d: no device messages", d.DeviceIndex)
+	}
+	return nil
+}
+
+// Validate checks if the message is valid according to the message spec.
+func (m OutgoingMessage) Validate() error {
+	id, v := m.Message()
+	if v == nil {
+		return errors.New("no message")
+	}
+	if id == 0 {
+		return fmt.Errorf("%s: Id 0 is reserved for events", m.Type())
+	}
+	switch {
+	case m.RequestLog != nil:
+		if !logLevels[m.RequestLog.LogLevel] {
+			return fmt.Errorf("invalid log level %q", m.RequestLog.LogLevel)
+		}
+	case m.SingleMotorVibrateCmd != nil:
+		if spd := m.SingleMotorVibrateCmd.Speed; spd < 0 || spd > 1 {
+			return fmt.Errorf("speed %g out of range [0.0-1.0]", spd)
+		}
+	case m.KiirooCmd != nil:
+		if cmd := m.KiirooCmd.Command; cmd < 0 || cmd > 4 {
+			return fmt.Errorf("command %d out of range [0-4]", cmd)
+		}
+	case m.FleshlightLaunchFW12Cmd != nil:
+		if pos := m.FleshlightLaunchFW12Cmd.Position; pos < 0 || pos > 99 {
+			return fmt.Errorf("position %d out of range [0-99]", pos)
+		}
+		if spd := m.FleshlightLaunchFW12Cmd.Speed; spd < 0 || spd > 99 {
+			return fmt.Errorf("speed %d out of range [0-99]", spd)
+		}
+	case m.VorzeA10CycloneCmd != nil:
+		if spd := m.VorzeA10CycloneCmd.Speed; spd < 0 || spd > 100 {
+			return fmt.Errorf("speed %d out of range [0-100]", spd)
+		}
+	case m.LovenseCmd != nil:
+		if m.LovenseCmd.Command == "" {
+			return errors.New("empty command")
+		}
+	}
+	return nil
+}
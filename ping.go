@@ -0,0 +1,240 @@
+package golibbuttplug
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/funjack/golibbuttplug/message"
+)
+
+// pingSamples is the amount of round-trip times used for percentiles.
+const pingSamples = 100
+
+// Health describes the quality of the connection with the server.
+type Health int
+
+const (
+	// Healthy means pings are answered in time.
+	Healthy Health = iota
+	// Degraded means pings are missed, or answered slower than the ping
+	// interval, but the client is still connected.
+	Degraded
+	// Lost means the connection is closed.
+	Lost
+)
+
+func (h Health) String() string {
+	switch h {
+	case Healthy:
+		return "healthy"
+	case Degraded:
+		return "degraded"
+	case Lost:
+		return "lost"
+	}
+	return fmt.Sprintf("Health(%d)", int(h))
+}
+
+// PingStats contains the round-trip times of pings send to the server.
+type PingStats struct {
+	// Last round-trip time.
+	Last time.Duration
+	// Average is the moving average of the round-trip times.
+	Average time.Duration
+	// P50, P90 and P99 are percentiles of the recent round-trip times.
+	P50, P90, P99 time.Duration
+
+	// Sent is the number of pings send.
+	Sent int
+	// Missed is the number of pings that failed or were not answered in
+	// time.
+	Missed int
+	// Health of the connection.
+	Health Health
+}
+
+// PingTolerance sets the number of consecutive missed pings that are
+// tolerated before the client is closed. The default is zero, the client is
+// closed on the first missed ping. A ping is missed when there is no reply
+// within half of the MaxPingTime of the server.
+func PingTolerance(n int) Option {
+	return func(c *Client) {
+		c.pings.tolerance = n
+	}
+}
+
+// OnHealthChange calls fn every time the health of the connection changes.
+// Fn is called from the goroutine sending pings and must not block.
+func OnHealthChange(fn func(h Health)) Option {
+	return func(c *Client) {
+		c.pings.onChange = fn
+	}
+}
+
+// PingStats returns the round-trip times of the pings send to the server.
+func (c *Client) PingStats() PingStats {
+	return c.pings.stats()
+}
+
+// Health returns the health of the connection.
+func (c *Client) Health() Health {
+	return c.pings.stats().Health
+}
+
+// pingMonitor keeps track of ping round-trip times and connection health.
+type pingMonitor struct {
+	tolerance int          // Consecutive misses before the client closes.
+	onChange  func(Health) // Called when health changes.
+
+	m        sync.Mutex      // Protects all fields below.
+	samples  []time.Duration // Recent round-trip times.
+	next     int             // Index in samples to overwrite.
+	last     time.Duration   // Last round-trip time.
+	avg      time.Duration   // Moving average.
+	sent     int             // Pings send.
+	missed   int             // Pings missed.
+	failures int             // Consecutive pings missed.
+	health   Health
+}
+
+// Success records the round-trip time of an answered ping. Pings that are
+// slower than interval degrade the connection.
+func (p *pingMonitor) success(rtt, interval time.Duration) {
+	p.m.Lock()
+	p.sent++
+	p.failures = 0
+	p.last = rtt
+	if p.avg == 0 {
+		p.avg = rtt
+	} else {
+		// Exponential moving average with a weight of 1/8.
+		p.avg += (rtt - p.avg) / 8
+	}
+	if len(p.samples) < pingSamples {
+		p.samples = append(p.samples, rtt)
+	} else {
+		p.samples[p.next] = rtt
+		p.next = (p.next + 1) % pingSamples
+	}
+	h := Healthy
+	if rtt > interval {
+		h = Degraded
+	}
+	p.m.Unlock()
+	p.setHealth(h)
+}
+
+// Miss records a ping that was not answered. False is returned when more
+// pings are missed than tolerated.
+func (p *pingMonitor) miss() bool {
+	p.m.Lock()
+	p.sent++
+	p.missed++
+	p.failures++
+	ok := p.failures <= p.tolerance
+	p.m.Unlock()
+	if ok {
+		p.setHealth(Degraded)
+	}
+	return ok
+}
+
+// SetHealth updates the health and calls onChange when it is changed.
+// Connections that are lost stay lost.
+func (p *pingMonitor) setHealth(h Health) {
+	p.m.Lock()
+	changed := p.health != h && p.health != Lost
+	if changed {
+		p.health = h
+	}
+	p.m.Unlock()
+	if changed && p.onChange != nil {
+		p.onChange(h)
+	}
+}
+
+// Stats returns a snapshot of the ping statistics.
+func (p *pingMonitor) stats() PingStats {
+	p.m.Lock()
+	defer p.m.Unlock()
+	s := PingStats{
+		Last:    p.last,
+		Average: p.avg,
+		Sent:    p.sent,
+		Missed:  p.missed,
+		Health:  p.health,
+	}
+	if len(p.samples) > 0 {
+		sorted := make([]time.Duration, len(p.samples))
+		copy(sorted, p.samples)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		s.P50 = percentile(sorted, 50)
+		s.P90 = percentile(sorted, 90)
+		s.P99 = percentile(sorted, 99)
+	}
+	return s
+}
+
+// Percentile returns the nearest-rank percentile n of the sorted durations.
+func percentile(sorted []time.Duration, n int) time.Duration {
+	i := (len(sorted)*n+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// PingLoop sends a ping every interval d. Pings that are not answered within
+// timeout are missed, the client is closed when more pings are missed than
+// tolerated.
+func (c *Client) pingLoop(d, timeout time.Duration) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for c.ping(d, timeout) {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-c.stop:
+			return
+		case <-t.C:
+		}
+	}
+	c.Close()
+}
+
+// Ping sends a ping to the server and records the result. False is returned
+// when the client must be closed.
+func (c *Client) ping(d, timeout time.Duration) bool {
+	id := c.counter.Generate()
+	m := message.OutgoingMessage{
+		Ping: &message.Empty{
+			ID: id,
+		},
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	r, err := c.roundTrip(ctx, m)
+	if err == nil && r.Error != nil {
+		err = fmt.Errorf("server error: %s", r.Error.ErrorMessage)
+	} else if err == nil && r.Ok == nil {
+		err = errors.New("did not receive ok")
+	}
+	if err == nil {
+		c.pings.success(time.Since(start), d)
+		return true
+	}
+	select {
+	case <-c.stop:
+		// Client is closing.
+		return false
+	default:
+	}
+	log.Printf("ping error: %v", err)
+	return c.pings.miss()
+}
@@ -0,0 +1,141 @@
+package golibbuttplug
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funjack/golibbuttplug/buttplugtest"
+)
+
+// healthRecorder records health changes.
+type healthRecorder struct {
+	m      sync.Mutex
+	states []Health
+}
+
+func (r *healthRecorder) record(h Health) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.states = append(r.states, h)
+}
+
+func (r *healthRecorder) get() []Health {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return append([]Health(nil), r.states...)
+}
+
+func TestPingTolerance(t *testing.T) {
+	s := newTestServer(&buttplugtest.Fault{
+		Match: buttplugtest.MatchType("Ping"),
+		After: 2,
+		Count: 1,
+		Drop:  true,
+	})
+	s.MaxPingTime = 100
+	var r healthRecorder
+	c, done := connect(t, s, PingTolerance(1), OnHealthChange(r.record))
+	defer done()
+
+	time.Sleep(500 * time.Millisecond)
+	select {
+	case <-c.Disconnected():
+		t.Fatal("client disconnected")
+	default:
+	}
+	stats := c.PingStats()
+	if stats.Missed != 1 || stats.Sent < 4 {
+		t.Errorf("unexpected ping counts: %+v", stats)
+	}
+	if stats.Last <= 0 || stats.Average <= 0 || stats.P50 > stats.P90 || stats.P90 > stats.P99 {
+		t.Errorf("unexpected round-trip times: %+v", stats)
+	}
+	if h := r.get(); len(h) < 2 || h[0] != Degraded || h[len(h)-1] != Healthy {
+		t.Errorf("unexpected health changes: %v", h)
+	}
+
+	done()
+	if h := r.get(); h[len(h)-1] != Lost {
+		t.Errorf("got health %v after close, want %v", h[len(h)-1], Lost)
+	}
+}
+
+func TestPingToleranceStrict(t *testing.T) {
+	s := newTestServer(&buttplugtest.Fault{
+		Match: buttplugtest.MatchType("Ping"),
+		After: 2,
+		Count: 1,
+		Drop:  true,
+	})
+	s.Strict = true
+	s.MaxPingTime = 200
+	c, done := connect(t, s, PingTolerance(1))
+	defer done()
+
+	time.Sleep(800 * time.Millisecond)
+	select {
+	case <-c.Disconnected():
+		t.Fatal("server ended the session after a missed ping")
+	default:
+	}
+	if stats := c.PingStats(); stats.Missed != 1 || stats.Health != Healthy {
+		t.Errorf("unexpected ping stats: %+v", stats)
+	}
+	// The ping after the missed one must reach the server well within
+	// MaxPingTime, or a slower network ends the session.
+	var last time.Time
+	for _, r := range s.LastConn().History() {
+		if r.Message.Ping == nil {
+			continue
+		}
+		if gap := r.Time.Sub(last); !last.IsZero() && gap > 150*time.Millisecond {
+			t.Errorf("%s between pings, MaxPingTime is 200ms", gap)
+		}
+		last = r.Time
+	}
+}
+
+func TestPingLost(t *testing.T) {
+	s := newTestServer(buttplugtest.DropPings())
+	s.MaxPingTime = 100
+	var r healthRecorder
+	c, done := connect(t, s, PingTolerance(2), OnHealthChange(r.record))
+	defer done()
+
+	select {
+	case <-c.Disconnected():
+	case <-time.After(time.Second):
+		t.Fatal("client not disconnected")
+	}
+	if stats := c.PingStats(); stats.Missed != 3 {
+		t.Errorf("got %d missed pings, want 3", stats.Missed)
+	}
+	want := []Health{Degraded, Lost}
+	if h := r.get(); len(h) != len(want) || h[0] != want[0] || h[1] != want[1] {
+		t.Errorf("got health changes %v, want %v", h, want)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i + 1)
+	}
+	for _, tc := range []struct {
+		Samples []time.Duration
+		N       int
+		Want    time.Duration
+	}{
+		{sorted, 50, 50},
+		{sorted, 90, 90},
+		{sorted, 99, 99},
+		{sorted[:1], 99, 1},
+		{sorted[:10], 50, 5},
+		{sorted[:10], 99, 10},
+	} {
+		if got := percentile(tc.Samples, tc.N); got != tc.Want {
+			t.Errorf("p%d of %d samples: got %d, want %d", tc.N, len(tc.Samples), got, tc.Want)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+/*
+Package proxy shares a single Buttplug server session with multiple clients.
+
+A Proxy holds one upstream Client connection and accepts Buttplug websocket
+clients itself. Messages of downstream clients are send upstream with new
+message ids, replies are send back with the id of the original message.
+Every downstream client sees all devices of the upstream server and receives
+all server events.
+
+When multiple clients send commands to the same device the Arbitration of the
+Proxy decides which client is in control. StopDeviceCmd and StopAllDevices
+are always forwarded. Devices that a client controls are stopped when it
+disconnects.
+*/
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/funjack/golibbuttplug"
+	"github.com/funjack/golibbuttplug/message"
+	"github.com/gorilla/websocket"
+)
+
+// DefaultHold is the default time a client stays in control of a device
+// after its last command.
+const DefaultHold = 2 * time.Second
+
+// sendTimeout is the maximum time to wait on a reply from upstream.
+var sendTimeout = 30 * time.Second
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// Arbitration decides what happens when multiple clients control the same
+// device.
+type Arbitration int
+
+const (
+	// LastWriter forwards all commands, the last command send wins.
+	LastWriter Arbitration = iota
+	// Priority rejects commands from a client when a client with a higher
+	// priority has send a command to the device within the hold time.
+	Priority
+)
+
+// Proxy is a http.Handler that serves Buttplug websocket clients and
+// forwards their messages to an upstream server.
+type Proxy struct {
+	// Arbitration used for conflicting device commands.
+	Arbitration Arbitration
+	// Priorities by client name, used by Priority arbitration. Clients
+	// that are not listed have priority 0.
+	Priorities map[string]int
+	// Hold is the time a client stays in control of a device after its
+	// last command, used by Priority arbitration.
+	Hold time.Duration
+
+	client *golibbuttplug.Client
+
+	m      sync.Mutex        // Protects owners.
+	owners map[uint32]*owner // Client in control by device index.
+}
+
+// owner is the client in control of a device.
+type owner struct {
+	session *session
+	last    time.Time
+}
+
+// New returns a proxy for the upstream client that uses LastWriter
+// arbitration.
+func New(c *golibbuttplug.Client) *Proxy {
+	return &Proxy{
+		Arbitration: LastWriter,
+		Hold:        DefaultHold,
+		client:      c,
+		owners:      make(map[uint32]*owner),
+	}
+}
+
+// ServeHTTP upgrades the request to a websocket and serves a downstream
+// client until it disconnects.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+	s := &session{
+		proxy: p,
+		conn:  conn,
+		done:  make(chan struct{}),
+	}
+	defer p.release(s)
+	defer close(s.done)
+	// Replies are send by forward.
+	events, err := p.client.Subscribe(message.FilterID(0))
+	if err != nil {
+		log.Printf("subscribe error: %v", err)
+		return
+	}
+	defer p.client.Unsubscribe(events)
+	go s.forwardEvents(events)
+	if err := s.readMessages(); err != nil {
+		log.Printf("client %q disconnected: %v", s.clientName(), err)
+	}
+}
+
+// priority returns the priority of a session.
+func (p *Proxy) priority(s *session) int {
+	return p.Priorities[s.clientName()]
+}
+
+// claim returns nil when the session may send a command to a device and
+// makes it the owner, otherwise the name of the client in control is returned
+// in an error.
+func (p *Proxy) claim(s *session, index uint32) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	now := time.Now()
+	o, ok := p.owners[index]
+	if ok && o.session != s && p.Arbitration == Priority &&
+		now.Sub(o.last) < p.Hold && p.priority(o.session) > p.priority(s) {
+		return fmt.Errorf("device %d is controlled by %s", index, o.session.clientName())
+	}
+	p.owners[index] = &owner{session: s, last: now}
+	return nil
+}
+
+// unclaim removes the owner of a device.
+func (p *Proxy) unclaim(index uint32) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	delete(p.owners, index)
+}
+
+// unclaimAll removes the owners of all devices.
+func (p *Proxy) unclaimAll() {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.owners = make(map[uint32]*owner)
+}
+
+// release stops and unclaims all devices controlled by the session.
+func (p *Proxy) release(s *session) {
+	p.m.Lock()
+	var indexes []uint32
+	for i, o := range p.owners {
+		if o.session == s {
+			indexes = append(indexes, i)
+			delete(p.owners, i)
+		}
+	}
+	p.m.Unlock()
+	for _, i := range indexes {
+		m := message.OutgoingMessage{
+			StopDeviceCmd: &message.Device{DeviceIndex: i},
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		if _, err := p.client.Send(ctx, m); err != nil {
+			log.Printf("error stopping device %d: %v", i, err)
+		}
+		cancel()
+	}
+}
+
+// session is a connection with a downstream client.
+type session struct {
+	proxy *Proxy
+	conn  *websocket.Conn
+	done  chan struct{} // Closed when the session has ended.
+
+	wm sync.Mutex // Protects writes to conn.
+
+	m         sync.Mutex // Protects name and handshake.
+	name      string     // Client name.
+	handshake bool       // RequestServerInfo received.
+}
+
+func (s *session) clientName() string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.name
+}
+
+func (s *session) hasHandshake() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.handshake
+}
+
+// ReadMessages reads and handles messages until the connection fails.
+func (s *session) readMessages() error {
+	for {
+		var msgs message.OutgoingMessages
+		err := s.conn.ReadJSON(&msgs)
+		switch err.(type) {
+		case nil:
+		case *json.SyntaxError, *json.UnmarshalTypeError:
+			log.Printf("error reading message: %v", err)
+			continue
+		default:
+			return err
+		}
+		for _, m := range msgs {
+			s.handleMessage(m)
+		}
+	}
+}
+
+// HandleMessage replies to a message, forwarding it upstream when needed.
+func (s *session) handleMessage(m message.OutgoingMessage) {
+	id, v := m.Message()
+	if v == nil {
+		s.sendError(id, "unknown message")
+		return
+	}
+	if m.RequestServerInfo == nil && !s.hasHandshake() {
+		s.sendError(id, "RequestServerInfo expected")
+		return
+	}
+	switch {
+	case m.RequestServerInfo != nil:
+		s.m.Lock()
+		s.name = m.RequestServerInfo.ClientName
+		s.handshake = true
+		s.m.Unlock()
+		si := s.proxy.client.ServerInfo()
+		si.ID = id
+		// The proxy keeps the upstream session alive.
+		si.MaxPingTime = 0
+		s.send(message.IncomingMessage{ServerInfo: &si})
+		return
+	case m.Ping != nil:
+		s.send(message.IncomingMessage{Ok: &message.Empty{ID: id}})
+		return
+	case m.RequestDeviceList != nil:
+		s.send(message.IncomingMessage{DeviceList: s.deviceList(id)})
+		return
+	case m.StopDeviceCmd != nil:
+		s.proxy.unclaim(m.StopDeviceCmd.DeviceIndex)
+	case m.StopAllDevices != nil:
+		s.proxy.unclaimAll()
+	default:
+		if index, ok := m.DeviceIndex(); ok {
+			if err := s.proxy.claim(s, index); err != nil {
+				s.sendError(id, err.Error())
+				return
+			}
+		}
+	}
+	s.forward(id, m)
+}
+
+// Forward sends a message upstream and the reply back to the client.
+func (s *session) forward(id uint32, m message.OutgoingMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	r, err := s.proxy.client.Send(ctx, m)
+	if _, v := r.Message(); v == nil {
+		// Interceptors can return an empty reply without an error.
+		if err == nil {
+			err = errors.New("no reply")
+		}
+		s.sendError(id, err.Error())
+		return
+	}
+	// Replies are shared with other readers of the client.
+	r, err = clone(r)
+	if err != nil {
+		s.sendError(id, err.Error())
+		return
+	}
+	r.SetID(id)
+	s.send(r)
+}
+
+// clone returns a deep copy of a message.
+func clone(m message.IncomingMessage) (c message.IncomingMessage, err error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
+
+// DeviceList returns the devices of the upstream client.
+func (s *session) deviceList(id uint32) *message.DeviceList {
+	dl := &message.DeviceList{ID: id, Devices: []message.Device{}}
+	for _, d := range s.proxy.client.Devices() {
+		dl.Devices = append(dl.Devices, message.Device{
+			DeviceName:     d.Name(),
+			DeviceIndex:    d.Index(),
+			DeviceMessages: d.Supported(),
+		})
+	}
+	sort.Slice(dl.Devices, func(i, j int) bool {
+		return dl.Devices[i].DeviceIndex < dl.Devices[j].DeviceIndex
+	})
+	return dl
+}
+
+// ForwardEvents sends the server events read from r to the client.
+func (s *session) forwardEvents(r *message.Reader) {
+	for {
+		select {
+		case m, ok := <-r.Incoming():
+			if !ok {
+				s.conn.Close()
+				return
+			}
+			if !s.hasHandshake() {
+				// Events are only send after the handshake.
+				continue
+			}
+			s.send(m)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *session) sendError(id uint32, msg string) {
+	s.send(message.IncomingMessage{
+		Error: &message.Error{
+			ID:           id,
+			ErrorMessage: msg,
+		},
+	})
+}
+
+func (s *session) send(m message.IncomingMessage) {
+	s.wm.Lock()
+	defer s.wm.Unlock()
+	if err := s.conn.WriteJSON(message.IncomingMessages{m}); err != nil {
+		log.Printf("error writing to %q: %v", s.clientName(), err)
+	}
+}
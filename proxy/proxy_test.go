@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/funjack/golibbuttplug"
+	"github.com/funjack/golibbuttplug/buttplugtest"
+	"github.com/funjack/golibbuttplug/message"
+)
+
+func wsURL(s string) string {
+	return "ws" + strings.TrimPrefix(s, "http")
+}
+
+// newProxy starts a strict test server, a proxy connected to it and
+// returns a function to connect downstream clients. Opts are used for the
+// upstream client.
+func newProxy(t *testing.T, opts ...golibbuttplug.Option) (*buttplugtest.TestServer, *Proxy, func(name string) *golibbuttplug.Client, func()) {
+	s := &buttplugtest.TestServer{
+		InitialDevices: buttplugtest.DefaultTestServer.InitialDevices,
+		Strict:         true,
+	}
+	ts := httptest.NewServer(s)
+	up, err := golibbuttplug.NewClient(context.Background(), wsURL(ts.URL), "Proxy", nil, opts...)
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	p := New(up)
+	ps := httptest.NewServer(p)
+	var clients []*golibbuttplug.Client
+	connect := func(name string) *golibbuttplug.Client {
+		c, err := golibbuttplug.NewClient(context.Background(), wsURL(ps.URL), name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, c)
+		return c
+	}
+	return s, p, connect, func() {
+		for _, c := range clients {
+			c.Close()
+		}
+		ps.Close()
+		up.Close()
+		ts.Close()
+	}
+}
+
+// device returns the device with the given index.
+func device(t *testing.T, c *golibbuttplug.Client, index uint32) *golibbuttplug.Device {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		for _, d := range c.Devices() {
+			if d.Index() == index {
+				return d
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("device %d not found", index)
+	return nil
+}
+
+func TestProxy(t *testing.T) {
+	s, _, connect, done := newProxy(t)
+	defer done()
+
+	player := connect("player")
+	bot := connect("bot")
+	if n := len(bot.Devices()); n != 3 {
+		t.Fatalf("bot has %d devices, want 3", n)
+	}
+	if err := device(t, player, 2).FleshlightLaunchFW12Cmd(10, 50); err != nil {
+		t.Errorf("player: %v", err)
+	}
+	if err := device(t, bot, 2).FleshlightLaunchFW12Cmd(90, 50); err != nil {
+		t.Errorf("bot: %v", err)
+	}
+	conn := s.LastConn()
+	for _, pos := range []int{10, 90} {
+		want := message.OutgoingMessage{
+			FleshlightLaunchFW12Cmd: &message.FleshlightLaunchFW12Cmd{
+				DeviceIndex: 2,
+				Position:    pos,
+				Speed:       50,
+			},
+		}
+		if _, err := conn.WaitFor(time.Second, buttplugtest.MatchMessage(want)); err != nil {
+			t.Errorf("launch to %d not received upstream: %v", pos, err)
+		}
+	}
+	if err := device(t, bot, 0).FleshlightLaunchFW12Cmd(10, 50); err == nil {
+		t.Errorf("unsupported command did not fail")
+	}
+
+	// Events are forwarded to all clients.
+	s.AddDevice(buttplugtest.DefaultAddDeviceMessage)
+	device(t, player, 3)
+	device(t, bot, 3)
+}
+
+func TestProxyEmptyReply(t *testing.T) {
+	_, _, connect, done := newProxy(t, golibbuttplug.Intercept(
+		func(ctx context.Context, m message.OutgoingMessage, next golibbuttplug.RoundTripFunc) (message.IncomingMessage, error) {
+			if m.StopAllDevices != nil {
+				return message.IncomingMessage{}, nil
+			}
+			return next(ctx, m)
+		}))
+	defer done()
+
+	c := connect("player")
+	if err := c.StopAllDevices(); err == nil || !strings.Contains(err.Error(), "no reply") {
+		t.Errorf("got error %v, want no reply", err)
+	}
+	if err := device(t, c, 2).FleshlightLaunchFW12Cmd(10, 50); err != nil {
+		t.Errorf("proxy stopped working after an empty reply: %v", err)
+	}
+}
+
+func TestProxyPriority(t *testing.T) {
+	_, p, connect, done := newProxy(t)
+	defer done()
+	p.Arbitration = Priority
+	p.Priorities = map[string]int{"player": 10}
+	p.Hold = 100 * time.Millisecond
+
+	player := connect("player")
+	bot := connect("bot")
+	if err := device(t, player, 0).SingleMotorVibrateCmd(0.5); err != nil {
+		t.Fatalf("player: %v", err)
+	}
+	if err := device(t, bot, 0).SingleMotorVibrateCmd(1); err == nil {
+		t.Errorf("bot took over device from player")
+	}
+	if err := device(t, bot, 1).SingleMotorVibrateCmd(1); err != nil {
+		t.Errorf("bot controlling other device: %v", err)
+	}
+	if err := device(t, player, 1).SingleMotorVibrateCmd(0.2); err != nil {
+		t.Errorf("player taking over device from bot: %v", err)
+	}
+	if err := device(t, bot, 0).StopDeviceCmd(); err != nil {
+		t.Errorf("bot stopping device: %v", err)
+	}
+	if err := device(t, bot, 0).SingleMotorVibrateCmd(1); err != nil {
+		t.Errorf("bot controlling stopped device: %v", err)
+	}
+	if err := device(t, player, 1).SingleMotorVibrateCmd(0.2); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(p.Hold)
+	if err := device(t, bot, 1).SingleMotorVibrateCmd(1); err != nil {
+		t.Errorf("bot controlling device after hold: %v", err)
+	}
+}
+
+func TestProxyReleaseOnDisconnect(t *testing.T) {
+	s, _, connect, done := newProxy(t)
+	defer done()
+
+	player := connect("player")
+	if err := device(t, player, 0).SingleMotorVibrateCmd(0.5); err != nil {
+		t.Fatal(err)
+	}
+	player.Close()
+	want := message.OutgoingMessage{
+		StopDeviceCmd: &message.Device{DeviceIndex: 0},
+	}
+	if _, err := s.LastConn().WaitFor(time.Second, buttplugtest.MatchMessage(want)); err != nil {
+		t.Errorf("device not stopped after disconnect: %v", err)
+	}
+}
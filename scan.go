@@ -0,0 +1,88 @@
+package golibbuttplug
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ScanEvent is reported during a scan.
+type ScanEvent struct {
+	// Device is the device that is found, nil for other events. Scan of
+	// Client reports a *Device.
+	Device DeviceAPI
+	// Finished is true when the server reports that scanning has finished.
+	// It is the last event of a scan.
+	Finished bool
+}
+
+// Scan asks the server to scan for devices and calls fn for every device
+// that is found. Scanning runs until the server reports it has finished, d
+// has elapsed, fn returns false or ctx is done. A duration of zero scans
+// until one of the others happens, also for servers that never report
+// scanning has finished. Scanning is always stopped before Scan returns.
+//
+// An error is returned when ctx is done, or when scanning could not be
+// started or stopped.
+func (c *Client) Scan(ctx context.Context, d time.Duration, fn func(e ScanEvent) bool) error {
+	seen := make(map[*Device]bool)
+	for _, dev := range c.Devices() {
+		seen[dev] = true
+	}
+	c.m.RLock()
+	finished := c.finished
+	c.m.RUnlock()
+	if err := c.StartScanning(); err != nil {
+		return err
+	}
+	var timeout <-chan time.Time
+	if d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+	for done := false; ; {
+		c.m.RLock()
+		added := c.added
+		c.m.RUnlock()
+		for _, dev := range c.Devices() {
+			if seen[dev] {
+				continue
+			}
+			seen[dev] = true
+			if !fn(ScanEvent{Device: dev}) {
+				if done {
+					return nil
+				}
+				return c.stopScanning()
+			}
+		}
+		if done {
+			fn(ScanEvent{Finished: true})
+			return nil
+		}
+		select {
+		case <-added:
+		case <-finished:
+			// Events are handled in order, devices found before
+			// scanning finished are known. Report them first.
+			done = true
+		case <-timeout:
+			return c.stopScanning()
+		case <-ctx.Done():
+			c.stopScanning()
+			return ctx.Err()
+		case <-c.stop:
+			return errors.New("client stopped")
+		}
+	}
+}
+
+// StopScanning stops scanning during a scan.
+func (c *Client) stopScanning() error {
+	if err := c.StopScanning(); err != nil {
+		return fmt.Errorf("stop scanning: %v", err)
+	}
+	return nil
+}
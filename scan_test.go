@@ -0,0 +1,93 @@
+package golibbuttplug
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/funjack/golibbuttplug/buttplugtest"
+	"github.com/funjack/golibbuttplug/message"
+)
+
+var vorze = &message.Device{
+	DeviceName:     "Vorze A10 Cyclone",
+	DeviceIndex:    5,
+	DeviceMessages: []string{CommandVorzeA10Cyclone, CommandStopDevice},
+}
+
+// stopped reports whether the client sent StopScanning.
+func stopped(conn *buttplugtest.Conn) bool {
+	for _, r := range conn.History() {
+		if r.Message.StopScanning != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func TestScan(t *testing.T) {
+	s := newTestServer()
+	c, done := connect(t, s)
+	defer done()
+	conn := s.LastConn()
+
+	go func() {
+		if _, err := conn.WaitFor(time.Second, buttplugtest.MatchType("StartScanning")); err != nil {
+			t.Error(err)
+			return
+		}
+		// Devices found right before scanning finished are reported.
+		conn.AddDevice(vorze)
+		conn.SendScanningFinished()
+	}()
+	var events []ScanEvent
+	err := c.Scan(context.Background(), time.Second, func(e ScanEvent) bool {
+		events = append(events, e)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Device == nil || events[0].Device.Index() != 5 || !events[1].Finished {
+		t.Errorf("unexpected events: %+v", events)
+	}
+	if stopped(conn) {
+		t.Errorf("StopScanning sent after scanning finished")
+	}
+}
+
+func TestScanStop(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Ctx    time.Duration
+		Scan   time.Duration
+		Result bool
+		Err    error
+	}{
+		{"Duration", time.Second, 50 * time.Millisecond, true, nil},
+		{"Cancel", 50 * time.Millisecond, 0, true, context.DeadlineExceeded},
+		{"Found", time.Second, 0, false, nil},
+	}
+	for _, tc := range cases {
+		s := newTestServer()
+		c, done := connect(t, s)
+		conn := s.LastConn()
+		go func() {
+			if _, err := conn.WaitFor(time.Second, buttplugtest.MatchType("StartScanning")); err == nil {
+				conn.AddDevice(vorze)
+			}
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), tc.Ctx)
+		err := c.Scan(ctx, tc.Scan, func(e ScanEvent) bool {
+			return tc.Result
+		})
+		cancel()
+		if err != tc.Err {
+			t.Errorf("%s: got error %v, want %v", tc.Name, err, tc.Err)
+		}
+		if !stopped(conn) {
+			t.Errorf("%s: scanning not stopped", tc.Name)
+		}
+		done()
+	}
+}
@@ -0,0 +1,209 @@
+package golibbuttplug
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/funjack/golibbuttplug/message"
+)
+
+// Span events recorded while a message is exchanged with the server.
+const (
+	// EventQueued is recorded when the message is added to the send queue.
+	EventQueued = "queued"
+	// EventWritten is recorded when the message is written to the server.
+	EventWritten = "written"
+)
+
+// Outcomes of a span.
+const (
+	// OutcomeOK means the server replied without an error.
+	OutcomeOK = "ok"
+	// OutcomeServerError means the server replied with an Error message.
+	OutcomeServerError = "server_error"
+	// OutcomeTimeout means the context deadline passed before the reply
+	// was received.
+	OutcomeTimeout = "timeout"
+	// OutcomeCanceled means the context was canceled before the reply was
+	// received.
+	OutcomeCanceled = "canceled"
+	// OutcomeError means the message could not be send or no reply was
+	// received.
+	OutcomeError = "error"
+)
+
+// SpanInfo describes the message a span is started for.
+type SpanInfo struct {
+	// Type of the message, eg: "FleshlightLaunchFW12Cmd".
+	Type string
+	// ID of the message.
+	ID uint32
+	// DeviceIndex of a device message, only valid when Device is true.
+	DeviceIndex uint32
+	// Device is true for device messages.
+	Device bool
+	// Message is the message send to the server.
+	Message message.OutgoingMessage
+}
+
+// Tracer starts a span for every message the client sends to the server
+// that expects a reply.
+type Tracer interface {
+	// Start starts a span. Ctx is the context of the request.
+	Start(ctx context.Context, info SpanInfo) Span
+}
+
+// Span traces a message from the moment it is queued until the reply is
+// received.
+type Span interface {
+	// Event records that something happened, see EventQueued and
+	// EventWritten.
+	Event(name string)
+	// End finishes the span with the reply of the server, or the error
+	// why no reply was received.
+	End(reply message.IncomingMessage, err error)
+}
+
+// Trace starts a span with t for every request send to the server. Trace can
+// be used multiple times, the tracers are called in order.
+func Trace(t Tracer) Option {
+	return func(c *Client) {
+		c.tracers = append(c.tracers, t)
+	}
+}
+
+// Outcome classifies the result of a request.
+func Outcome(reply message.IncomingMessage, err error) string {
+	switch {
+	case err == context.DeadlineExceeded:
+		return OutcomeTimeout
+	case err == context.Canceled:
+		return OutcomeCanceled
+	case err != nil:
+		return OutcomeError
+	case reply.Error != nil:
+		return OutcomeServerError
+	}
+	return OutcomeOK
+}
+
+// newSpanInfo returns the span info for message m.
+func newSpanInfo(m message.OutgoingMessage) SpanInfo {
+	index, device := m.DeviceIndex()
+	return SpanInfo{
+		Type:        m.Type(),
+		ID:          m.ID(),
+		DeviceIndex: index,
+		Device:      device,
+		Message:     m,
+	}
+}
+
+// tracers starts spans with all tracers.
+type tracers []Tracer
+
+func (ts tracers) Start(ctx context.Context, info SpanInfo) Span {
+	switch len(ts) {
+	case 0:
+		return nopSpan{}
+	case 1:
+		return ts[0].Start(ctx, info)
+	}
+	s := make(spans, len(ts))
+	for i, t := range ts {
+		s[i] = t.Start(ctx, info)
+	}
+	return s
+}
+
+// spans forwards to multiple spans.
+type spans []Span
+
+func (s spans) Event(name string) {
+	for _, v := range s {
+		v.Event(name)
+	}
+}
+
+func (s spans) End(reply message.IncomingMessage, err error) {
+	for _, v := range s {
+		v.End(reply, err)
+	}
+}
+
+// nopSpan is the span used when there are no tracers.
+type nopSpan struct{}
+
+func (nopSpan) Event(name string)                            {}
+func (nopSpan) End(reply message.IncomingMessage, err error) {}
+
+// SpanEvent is an event recorded by a RecordedSpan.
+type SpanEvent struct {
+	Name string
+	Time time.Time
+}
+
+// RecordedSpan is a span recorded by a TraceRecorder.
+type RecordedSpan struct {
+	SpanInfo
+	Start  time.Time
+	End    time.Time
+	Events []SpanEvent
+	Reply  message.IncomingMessage
+	Err    error
+	// Outcome of the request, see Outcome.
+	Outcome string
+}
+
+// TraceRecorder is a Tracer that keeps all finished spans in memory. It is
+// intended for tests.
+type TraceRecorder struct {
+	m     sync.Mutex // Protects spans.
+	spans []RecordedSpan
+}
+
+// Start starts recording a span.
+func (r *TraceRecorder) Start(ctx context.Context, info SpanInfo) Span {
+	return &recordingSpan{
+		recorder: r,
+		span: RecordedSpan{
+			SpanInfo: info,
+			Start:    time.Now(),
+		},
+	}
+}
+
+// Spans returns the finished spans in the order they ended.
+func (r *TraceRecorder) Spans() []RecordedSpan {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return append([]RecordedSpan(nil), r.spans...)
+}
+
+// recordingSpan is a span that is being recorded.
+type recordingSpan struct {
+	recorder *TraceRecorder
+
+	m    sync.Mutex // Protects span.
+	span RecordedSpan
+}
+
+func (s *recordingSpan) Event(name string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.span.Events = append(s.span.Events, SpanEvent{Name: name, Time: time.Now()})
+}
+
+func (s *recordingSpan) End(reply message.IncomingMessage, err error) {
+	s.m.Lock()
+	s.span.End = time.Now()
+	s.span.Reply = reply
+	s.span.Err = err
+	s.span.Outcome = Outcome(reply, err)
+	span := s.span
+	s.m.Unlock()
+	s.recorder.m.Lock()
+	s.recorder.spans = append(s.recorder.spans, span)
+	s.recorder.m.Unlock()
+}
@@ -0,0 +1,83 @@
+package golibbuttplug
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/funjack/golibbuttplug/buttplugtest"
+	"github.com/funjack/golibbuttplug/message"
+)
+
+func TestTrace(t *testing.T) {
+	s := newTestServer(
+		&buttplugtest.Fault{
+			Match: buttplugtest.MatchType("SingleMotorVibrateCmd"),
+			Error: "device busy",
+		},
+		&buttplugtest.Fault{
+			Match: buttplugtest.MatchType("RawCmd"),
+			Drop:  true,
+		},
+	)
+	var first, second TraceRecorder
+	c, done := connect(t, s, Trace(&first), Trace(&second))
+	defer done()
+
+	devices := c.Devices()
+	if err := devices[2].FleshlightLaunchFW12Cmd(10, 50); err != nil {
+		t.Fatal(err)
+	}
+	if err := devices[0].SingleMotorVibrateCmd(0.5); err == nil {
+		t.Fatal("expected server error")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := c.Send(ctx, message.OutgoingMessage{
+		RawCmd: &message.RawCmd{DeviceIndex: 0, Command: []byte{0}},
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	var spans []RecordedSpan
+	for _, s := range first.Spans() {
+		if s.Type != "Ping" {
+			spans = append(spans, s)
+		}
+	}
+	want := []struct {
+		Type    string
+		Device  bool
+		Index   uint32
+		Outcome string
+	}{
+		{"RequestServerInfo", false, 0, OutcomeOK},
+		{"RequestDeviceList", false, 0, OutcomeOK},
+		{"FleshlightLaunchFW12Cmd", true, 2, OutcomeOK},
+		{"SingleMotorVibrateCmd", true, 0, OutcomeServerError},
+		{"RawCmd", true, 0, OutcomeTimeout},
+	}
+	if len(spans) != len(want) {
+		t.Fatalf("got %d spans, want %d: %+v", len(spans), len(want), spans)
+	}
+	for i, w := range want {
+		s := spans[i]
+		if s.Type != w.Type || s.Device != w.Device || s.DeviceIndex != w.Index || s.Outcome != w.Outcome {
+			t.Errorf("span %d: got %s device=%v index=%d outcome=%s, want %+v",
+				i, s.Type, s.Device, s.DeviceIndex, s.Outcome, w)
+		}
+		if id, _ := s.Message.Message(); s.ID == 0 || s.ID != id {
+			t.Errorf("span %d: invalid id %d", i, s.ID)
+		}
+		if len(s.Events) != 2 || s.Events[0].Name != EventQueued || s.Events[1].Name != EventWritten {
+			t.Errorf("span %d: unexpected events %+v", i, s.Events)
+		}
+		if s.End.Before(s.Start) {
+			t.Errorf("span %d: ends before start", i)
+		}
+	}
+	if n := len(second.Spans()); n < len(spans) {
+		t.Errorf("second tracer recorded %d spans, want at least %d", n, len(spans))
+	}
+}